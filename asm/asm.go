@@ -11,22 +11,87 @@ import (
 
 // assembler holds some assembler state.
 type assembler struct {
-	ast    *parser.AST               // Source AST.
-	code   []cpu.Word                // Final program.
-	labels map[string]cpu.Word       // Map of defined labels with their address.
-	refs   map[cpu.Word]*parser.Name // Indices into `code` holding unresolved label references.
-	debug  *DebugInfo                // Maps binary instructions to original source locations.
+	ast     *parser.AST          // Source AST.
+	code    []cpu.Word           // Final program.
+	labels  map[string]cpu.Word  // Map of defined labels with their address.
+	refs    []*fixup             // Expressions holding unresolved label references.
+	debug   *DebugInfo           // Maps binary instructions to original source locations.
+	object  bool                 // Are we building a relocatable object?
+	imports map[string]int64     // Values of undefined labels, once all labels are known.
+	shift   int64                // Offset added to label addresses during evaluation.
+	errs    parser.ErrorList     // Errors found while building the program.
+	layout  map[string]cpu.Word  // Label addresses from the previous pass.
+	compact map[parser.Node]bool // Operands encoded compactly in the previous pass.
+	next    map[parser.Node]bool // Operands encoded compactly in the current pass.
+	long    map[parser.Node]bool // Operands fixed to their long form.
+	options Options              // Optional behaviour.
+}
+
+// Options control optional assembler behaviour.
+type Options struct {
+	// RelativeJumps allows `set pc, label` to be encoded as `add pc, n`
+	// or `sub pc, n`, if this saves a word. These take one cycle more
+	// than `set` and they set EX to 0.
+	RelativeJumps bool
 }
 
 // Assemble takes the given AST and attempts to assemble it into a compiled program.
 //
 // It returns either an error, or the program along with debug symbols.
+// Build errors do not stop the assembler. If there is more than one,
+// a parser.ErrorList is returned, holding at most ast.MaxErrors of them.
 func Assemble(ast *parser.AST) (prog []cpu.Word, dbg *DebugInfo, err error) {
+	return AssembleWith(ast, Options{})
+}
+
+// AssembleWith is like Assemble, with the given options.
+func AssembleWith(ast *parser.AST, opt Options) (prog []cpu.Word, dbg *DebugInfo, err error) {
 	var asm assembler
-	asm.ast = ast
-	asm.labels = make(map[string]cpu.Word)
-	asm.refs = make(map[cpu.Word]*parser.Name)
-	asm.debug = new(DebugInfo)
+	asm.options = opt
+
+	if err = asm.assemble(ast); err != nil {
+		return
+	}
+
+	// Fix unresolved label references.
+	for _, v := range asm.refs {
+		val, name, err := asm.eval(v.nodes)
+		if err != nil {
+			asm.errs.Add(err)
+			continue
+		}
+
+		if name != nil {
+			asm.errs.Add(NewBuildError(
+				ast.Files[name.File()], name.Line(), name.Col(),
+				"Unknown label reference %q.", name.Data))
+			continue
+		}
+
+		asm.code[v.addr] = val
+	}
+
+	if err = asm.errs.Err(ast.MaxErrors); err != nil {
+		return
+	}
+
+	prog = asm.code
+	dbg = asm.debug
+	return
+}
+
+// assemble compiles the given AST. Label references which can not
+// be resolved in a single pass, are left in a.refs.
+//
+// Errors in individual instructions are collected in a.errs.
+// Only errors which prevent the build altogether are returned.
+func (a *assembler) assemble(ast *parser.AST) (err error) {
+	a.ast = ast
+
+	// Expand macros and conditional code, in case the caller has not done so.
+	if err = ast.Expand(); err != nil {
+		return
+	}
 
 	// Process function definitions.
 	// This also processes function-local constants.
@@ -43,33 +108,23 @@ func Assemble(ast *parser.AST) (prog []cpu.Word, dbg *DebugInfo, err error) {
 	ast.Root.SetChildren(list)
 
 	// Compile program.
-	if err = asm.buildNodes(ast.Root.Children()); err != nil {
-		return
-	}
+	a.pass(list, nil)
 
-	// Fix unresolved label references.
-	for k, v := range asm.refs {
-		addr, ok := asm.labels[v.Data]
-
-		if ok {
-			asm.code[k] = addr
-			continue
-		}
-
-		return nil, nil, NewBuildError(
-			ast.Files[v.File()], v.Line(), v.Col(),
-			"Unknown label reference %q.", v.Data)
+	// Forward references start out in their long form. See if they
+	// fit in a shorter one, now that we know where labels end up.
+	if !a.object && len(a.refs) > 0 {
+		a.relax(list)
 	}
 
-	asm.debug.SetFileDefs(ast.Files)
-
-	prog = asm.code
-	dbg = asm.debug
+	a.debug.SetFileDefs(ast.Files)
+	a.debug.SetLabels(a.labels)
 	return
 }
 
 // buildNodes compiles the given ast root nodes
-func (a *assembler) buildNodes(nodes []parser.Node) (err error) {
+func (a *assembler) buildNodes(nodes []parser.Node) {
+	var err error
+
 	for i := range nodes {
 		switch tt := nodes[i].(type) {
 		case *parser.Comment:
@@ -79,10 +134,10 @@ func (a *assembler) buildNodes(nodes []parser.Node) (err error) {
 			a.labels[tt.Data] = cpu.Word(len(a.code))
 
 		case *parser.Function:
-			err = a.buildFunction(tt)
+			a.buildFunction(tt)
 
 		case *parser.Instruction:
-			err = a.buildInstruction(tt.Children())
+			err = a.build(tt)
 
 		default:
 			err = NewBuildError(
@@ -92,15 +147,16 @@ func (a *assembler) buildNodes(nodes []parser.Node) (err error) {
 		}
 
 		if err != nil {
-			return
+			a.errs.Add(err)
+			err = nil
 		}
 	}
-
-	return
 }
 
 // buildFunction compiles the given function.
-func (a *assembler) buildFunction(f *parser.Function) (err error) {
+func (a *assembler) buildFunction(f *parser.Function) {
+	var err error
+
 	nodes := f.Children()
 	name := nodes[0].(*parser.Label)
 
@@ -115,7 +171,7 @@ func (a *assembler) buildFunction(f *parser.Function) (err error) {
 			a.labels[tt.Data] = cpu.Word(len(a.code))
 
 		case *parser.Instruction:
-			err = a.buildInstruction(tt.Children())
+			err = a.build(tt)
 
 		default:
 			err = NewBuildError(
@@ -125,11 +181,25 @@ func (a *assembler) buildFunction(f *parser.Function) (err error) {
 		}
 
 		if err != nil {
-			return
+			a.errs.Add(err)
+			err = nil
 		}
 	}
 
 	a.debug.SetFunctionEnd(cpu.Word(len(a.code)), nodes[len(nodes)-1].Line())
+}
+
+// build compiles the given instruction. If this fails, any code and
+// fixups it produced are discarded, so the build can carry on with
+// the next instruction.
+func (a *assembler) build(instr *parser.Instruction) (err error) {
+	code, refs := len(a.code), len(a.refs)
+
+	if err = a.buildInstruction(instr.Children()); err != nil {
+		a.code = a.code[:code]
+		a.refs = a.refs[:refs]
+	}
+
 	return
 }
 
@@ -137,6 +207,16 @@ func (a *assembler) buildFunction(f *parser.Function) (err error) {
 func (a *assembler) buildInstruction(nodes []parser.Node) (err error) {
 	name := nodes[0].(*parser.Name)
 
+	// A trailing comment on an instruction without arguments ends
+	// up as an expression of its own. It is not an argument.
+	if len(nodes) > 1 {
+		if expr, ok := nodes[len(nodes)-1].(*parser.Expression); ok {
+			if len(parser.StripComments(expr.Children())) == 0 {
+				nodes = nodes[:len(nodes)-1]
+			}
+		}
+	}
+
 	if name.Data == "dat" {
 		return a.buildData(nodes)
 	}
@@ -157,6 +237,17 @@ func (a *assembler) buildInstruction(nodes []parser.Node) (err error) {
 		)
 	}
 
+	switch name.Data {
+	case "org", "reserve", "fill", "align":
+		return a.buildDirective(name, nodes[1:])
+	}
+
+	if name.Data == "set" && a.options.RelativeJumps {
+		if ok, err := a.buildJump(nodes); ok || err != nil {
+			return err
+		}
+	}
+
 	var va, vb cpu.Word
 	var argv []cpu.Word
 	var symbols []parser.Node
@@ -165,15 +256,15 @@ func (a *assembler) buildInstruction(nodes []parser.Node) (err error) {
 
 	switch op.argc {
 	case 2:
-		va, err = a.buildOperand(&argv, &symbols, nodes[1].(*parser.Expression).Children()[0], true)
+		va, err = a.buildOperand(&argv, &symbols, nodes[1].(*parser.Expression), true)
 		if err != nil {
 			return
 		}
 
-		vb, err = a.buildOperand(&argv, &symbols, nodes[2].(*parser.Expression).Children()[0], false)
+		vb, err = a.buildOperand(&argv, &symbols, nodes[2].(*parser.Expression), false)
 
 	case 1:
-		va, err = a.buildOperand(&argv, &symbols, nodes[1].(*parser.Expression).Children()[0], false)
+		va, err = a.buildOperand(&argv, &symbols, nodes[1].(*parser.Expression), false)
 	}
 
 	if err != nil {
@@ -196,165 +287,175 @@ func (a *assembler) buildInstruction(nodes []parser.Node) (err error) {
 // The `first` parameter determines if we are parsing the A or B parameter
 // in something like 'set A, B'. This makes a difference when encoding
 // small literal numbers.
-func (a *assembler) buildOperand(argv *[]cpu.Word, symbols *[]parser.Node, node parser.Node, first bool) (val cpu.Word, err error) {
-	switch tt := node.(type) {
-	case *parser.Name:
-		if reg, ok := registers[tt.Data]; ok {
-			return reg, nil
-		}
+func (a *assembler) buildOperand(argv *[]cpu.Word, symbols *[]parser.Node, expr *parser.Expression, first bool) (val cpu.Word, err error) {
+	nodes := parser.StripComments(expr.Children())
 
-		if addr, ok := a.labels[tt.Data]; ok {
-			if !first && (addr == 0xffff || addr <= 0x1e) {
-				return addr + 0x21, nil
+	if len(nodes) == 1 {
+		switch tt := nodes[0].(type) {
+		case *parser.Name:
+			if reg, ok := registers[tt.Data]; ok {
+				return reg, nil
 			}
 
-			*symbols = append(*symbols, tt)
-			*argv = append(*argv, addr)
-			return 0x1f, nil
-		}
-
-		a.refs[cpu.Word(len(a.code)+1+len(*argv))] = tt
-		*symbols = append(*symbols, tt)
-		*argv = append(*argv, 0)
-		return 0x1f, nil
-
-	case parser.NumericNode:
-		num, err := tt.Parse()
-		if err != nil {
-			return 0, err
-		}
+		case *parser.Block:
+			return a.buildBlock(argv, symbols, tt)
 
-		if !first && (num == 0xffff || num <= 0x1e) {
-			return num + 0x21, nil
+		case *parser.String:
+			return 0, a.errorf(tt, "Unexpected node %T. Want Name, Number, Block or Expression.", tt)
 		}
+	}
 
-		*symbols = append(*symbols, tt)
-		*argv = append(*argv, num)
-		return 0x1f, nil
+	num, unresolved, err := a.eval(nodes)
+	if err != nil {
+		return
+	}
 
-	case *parser.Block:
-		return a.buildBlock(argv, symbols, tt)
+	// Label addresses in object files are not final. They can not be
+	// encoded as short literals.
+	short := unresolved == nil && !first && isShort(num) &&
+		!(a.object && hasLabelRefs(nodes))
 
-	default:
-		return 0, NewBuildError(
-			a.ast.Files[tt.File()], tt.Line(), tt.Col(),
-			"Unexpected node %T. Want Name, Number or Block.", tt,
-		)
+	if a.fits(expr, short) {
+		return num + 0x21, nil
 	}
 
-	return
+	a.emitValue(argv, symbols, expr, nodes, num, unresolved)
+	return 0x1f, nil
 }
 
 // buildBlock builds a block expression.
+//
+// A block holds an optional register and an optional constant expression.
+// The register may only be added to the rest of the expression.
+// For example: `[a]`, `[label]` or `[label + 2*4 + a]`.
 func (a *assembler) buildBlock(argv *[]cpu.Word, symbols *[]parser.Node, b *parser.Block) (val cpu.Word, err error) {
-	nodes := b.Children()
-
-	switch len(nodes) {
-	case 1:
-		switch tt := nodes[0].(type) {
-		case *parser.Name:
-			if reg, ok := registers[tt.Data]; ok {
-				return reg + 0x08, nil
-			}
-
-			if addr, ok := a.labels[tt.Data]; ok {
-				*symbols = append(*symbols, tt)
-				*argv = append(*argv, addr)
-				return 0x1e, nil
-			}
+	reg, nodes, err := a.splitBlock(b)
+	if err != nil {
+		return
+	}
 
-			a.refs[cpu.Word(len(a.code)+1+len(*argv))] = tt
-			*symbols = append(*symbols, tt)
-			*argv = append(*argv, 0)
-			return 0x1e, nil
+	if reg == nil {
+		num, unresolved, err := a.eval(nodes)
+		if err != nil {
+			return 0, err
+		}
 
-		case parser.NumericNode:
-			num, err := tt.Parse()
-			if err != nil {
-				return 0, err
-			}
+		a.emitValue(argv, symbols, b, nodes, num, unresolved)
+		return 0x1e, nil
+	}
 
-			*symbols = append(*symbols, tt)
-			*argv = append(*argv, num)
-			return 0x1e, nil
+	code := registers[reg.Data]
+	offset := len(nodes) > 0
 
-		default:
-			return 0, NewBuildError(
-				a.ast.Files[tt.File()], tt.Line(), tt.Col(),
-				"Unexpected node %T. Want Name, Number or Block.", tt,
-			)
+	// A zero offset, like `[label + a]` where label is 0, can be left out.
+	if offset && !a.object {
+		num, unresolved, err := a.eval(nodes)
+		if err != nil {
+			return 0, err
 		}
 
-	case 3:
-		var va, vb cpu.Word
+		offset = !a.fits(b, unresolved == nil && num == 0)
+	}
 
-		if va, err = a.buildBlockOperand(argv, symbols, nodes[0]); err != nil {
-			return
+	switch {
+	case code <= 0x7:
+		if !offset {
+			return code + 0x08, nil
 		}
 
-		if vb, err = a.buildBlockOperand(argv, symbols, nodes[2]); err != nil {
-			return
-		}
+		code += 0x10
 
-		if va != 0 {
-			return va, nil
+	case reg.Data == "sp":
+		if !offset {
+			return 0x19, nil
 		}
 
-		return vb, nil
+		code = 0x1a
 
 	default:
-		err = NewBuildError(
-			a.ast.Files[b.File()], b.Line(), b.Col(),
-			"Unexpected node count. Want 1 or 3. Got %d", len(nodes))
+		return 0, a.errorf(reg, "Illegal use of register %q.", reg.Data)
 	}
 
-	return
+	num, unresolved, err := a.eval(nodes)
+	if err != nil {
+		return
+	}
+
+	a.emitValue(argv, symbols, b, nodes, num, unresolved)
+	return code, nil
 }
 
-func (a *assembler) buildBlockOperand(argv *[]cpu.Word, symbols *[]parser.Node, node parser.Node) (cpu.Word, error) {
-	switch tt := node.(type) {
-	case *parser.Name:
-		if reg, ok := registers[tt.Data]; ok {
-			if reg <= 0x7 {
-				return reg + 0x10, nil
-			}
+// splitBlock separates the register from the constant part of the given
+// block expression. The register may only appear as a top level term
+// which is added to the rest of the expression.
+func (a *assembler) splitBlock(b *parser.Block) (reg *parser.Name, nodes []parser.Node, err error) {
+	nodes = parser.StripComments(b.Children())
+	index := -1
 
-			if reg == 0x1b {
-				return 0x1a, nil
-			}
+	for i := range nodes {
+		name, ok := nodes[i].(*parser.Name)
+		if !ok {
+			continue
+		}
 
-			return 0, NewBuildError(
-				a.ast.Files[tt.File()], tt.Line(), tt.Col(),
-				"Illegal use of register %q.", tt.Data,
-			)
+		if _, ok = registers[name.Data]; !ok {
+			continue
 		}
 
-		if addr, ok := a.labels[tt.Data]; ok {
-			*symbols = append(*symbols, tt)
-			*argv = append(*argv, addr)
-			return 0, nil
+		if reg != nil {
+			return nil, nil, a.errorf(name, "Only one register allowed in block expression.")
 		}
 
-		a.refs[cpu.Word(len(a.code)+1+len(*argv))] = tt
-		*symbols = append(*symbols, tt)
-		*argv = append(*argv, 0)
-		return 0, nil
+		reg, index = name, i
+	}
 
-	case parser.NumericNode:
-		num, err := tt.Parse()
-		if err != nil {
-			return 0, err
+	if reg == nil {
+		return
+	}
+
+	start, end := index, index+1
+
+	if index > 0 {
+		if !isOperator(nodes[index-1], "+") {
+			return nil, nil, a.errorf(reg, "Register %q can only be added to an expression.", reg.Data)
 		}
 
-		*symbols = append(*symbols, tt)
-		*argv = append(*argv, num)
-		return 0, nil
+		start--
 	}
 
-	return 0, NewBuildError(
-		a.ast.Files[node.File()], node.Line(), node.Col(),
-		"Unexpected node %T. Want Name or Number.", node,
-	)
+	if end < len(nodes) {
+		if !isOperator(nodes[end], "+") && !isOperator(nodes[end], "-") {
+			return nil, nil, a.errorf(reg, "Register %q can only be added to an expression.", reg.Data)
+		}
+
+		// A '-' following a leading register becomes the unary
+		// minus of the remaining expression.
+		if index == 0 && isOperator(nodes[end], "+") {
+			end++
+		}
+	}
+
+	out := make([]parser.Node, 0, len(nodes)-(end-start))
+	out = append(out, nodes[:start]...)
+	out = append(out, nodes[end:]...)
+	return reg, out, nil
+}
+
+// emitValue appends the given value as the next instruction word.
+// If the value could not be resolved yet, we register a fixup for it.
+//
+// When building an object, all label references get a fixup. They
+// may need relocation, even when they can be resolved right away.
+func (a *assembler) emitValue(argv *[]cpu.Word, symbols *[]parser.Node, n parser.Node, nodes []parser.Node, val cpu.Word, unresolved *parser.Name) {
+	if unresolved != nil || (a.object && hasLabelRefs(nodes)) {
+		a.refs = append(a.refs, &fixup{
+			addr:  cpu.Word(len(a.code) + 1 + len(*argv)),
+			nodes: nodes,
+		})
+	}
+
+	*symbols = append(*symbols, n)
+	*argv = append(*argv, val)
 }
 
 // buildData compiles the given data section
@@ -368,23 +469,128 @@ func (a *assembler) buildData(nodes []parser.Node) (err error) {
 			continue
 		}
 
-		switch tt := expr.Children()[0].(type) {
-		case *parser.String:
-			for _, r = range tt.Data {
-				a.debug.Emit(tt)
-				a.code = append(a.code, cpu.Word(r))
+		list := parser.StripComments(expr.Children())
+		if len(list) == 1 {
+			if str, ok := list[0].(*parser.String); ok {
+				for _, r = range str.Data {
+					a.debug.Emit(str)
+					a.code = append(a.code, cpu.Word(r))
+				}
+				continue
 			}
+		}
 
-		case parser.NumericNode:
-			num, err := tt.Parse()
-			if err != nil {
-				return err
-			}
+		num, unresolved, err := a.eval(list)
+		if err != nil {
+			return err
+		}
+
+		if unresolved != nil || (a.object && hasLabelRefs(list)) {
+			a.refs = append(a.refs, &fixup{
+				addr:  cpu.Word(len(a.code)),
+				nodes: list,
+			})
+		}
+
+		a.debug.Emit(expr)
+		a.code = append(a.code, num)
+	}
+
+	return
+}
+
+// buildDirective compiles the org, reserve, fill and align
+// pseudo-instructions. They all emit a number of words with the
+// same value. Their size must be known right away, so it can not
+// depend on labels which are defined further down.
+func (a *assembler) buildDirective(name *parser.Name, args []parser.Node) (err error) {
+	list := parser.StripComments(args[0].(*parser.Expression).Children())
+
+	num, unresolved, err := a.evaluate(list, nil)
+	if err != nil {
+		return
+	}
+
+	if unresolved != nil {
+		return a.errorf(unresolved, "%s can not refer to label %q, "+
+			"which is defined further down.", name.Data, unresolved.Data)
+	}
+
+	pos := len(a.code)
+	count := int(num)
+
+	switch name.Data {
+	case "org":
+		if a.object {
+			return a.errorf(name, "org can not be used in relocatable objects.")
+		}
+
+		if count < pos {
+			return a.errorf(name, "org %04x lies before the current address %04x.", num, pos)
+		}
+
+		count -= pos
+
+	case "align":
+		if a.object {
+			return a.errorf(name, "align can not be used in relocatable objects.")
+		}
 
-			a.debug.Emit(tt)
-			a.code = append(a.code, num)
+		if count == 0 {
+			return a.errorf(name, "Invalid alignment of 0.")
 		}
+
+		count = (count - pos%count) % count
+	}
+
+	if pos+count > cpu.MemSize {
+		return a.errorf(name, "%s exceeds available memory.", name.Data)
+	}
+
+	if name.Data != "fill" {
+		a.pad(name, count, 0)
+		return
+	}
+
+	expr := args[1].(*parser.Expression)
+	list = parser.StripComments(expr.Children())
+
+	val, unresolved, err := a.eval(list)
+	if err != nil {
+		return
+	}
+
+	for i := 0; i < count; i++ {
+		if unresolved != nil || (a.object && hasLabelRefs(list)) {
+			a.refs = append(a.refs, &fixup{
+				addr:  cpu.Word(len(a.code)),
+				nodes: list,
+			})
+		}
+
+		a.debug.Emit(expr)
+		a.code = append(a.code, val)
 	}
 
 	return
 }
+
+// pad appends count words with the given value. They are mapped
+// to the given node in the debug symbols.
+func (a *assembler) pad(n parser.Node, count int, val cpu.Word) {
+	for i := 0; i < count; i++ {
+		a.debug.Emit(n)
+		a.code = append(a.code, val)
+	}
+}
+
+// errorf creates a new build error for the given node.
+func (a *assembler) errorf(n parser.Node, f string, argv ...interface{}) error {
+	return NewBuildError(a.ast.Files[n.File()], n.Line(), n.Col(), f, argv...)
+}
+
+// isOperator returns true if the given node is the specified operator.
+func isOperator(n parser.Node, op string) bool {
+	tt, ok := n.(*parser.Operator)
+	return ok && tt.Data == op
+}
@@ -106,14 +106,23 @@ func TestJsr(t *testing.T) {
 		   set pc, pop
 		`,
 		cpu.Encode(cpu.SET, 0, 0x20),
-		cpu.Encode(cpu.EXT, cpu.JSR, 0x1f),
-		0x4,
+		cpu.Encode(cpu.EXT, cpu.JSR, 0x21+3),
 		cpu.Encode(cpu.EXT, cpu.EXIT, 0),
 		cpu.Encode(cpu.ADD, 0, 0x22),
 		cpu.Encode(cpu.SET, 0x1c, 0x18),
 	)
 }
 
+func TestTrailingComment(t *testing.T) {
+	doTest(t,
+		`  rfi 0 ; return
+		   exit ; done
+		`,
+		cpu.Encode(cpu.EXT, cpu.RFI, 0x21),
+		cpu.Encode(cpu.EXT, cpu.EXIT, 0),
+	)
+}
+
 func TestIntRfi(t *testing.T) {
 	doTest(t,
 		` ias my_handler
@@ -125,8 +134,7 @@ func TestIntRfi(t *testing.T) {
 		  add b, 1
 		  rfi a
 		`,
-		cpu.Encode(cpu.EXT, cpu.IAS, 0x1f),
-		0x6,
+		cpu.Encode(cpu.EXT, cpu.IAS, 0x21+5),
 		cpu.Encode(cpu.EXT, cpu.INT, 0x1f),
 		0xbeef,
 		cpu.Encode(cpu.SET, 0, 1),
@@ -193,13 +201,350 @@ func TestFunc(t *testing.T) {
 		`,
 		cpu.Encode(cpu.SET, 0x18, 6), // set push, i
 
-		cpu.Encode(cpu.SET, 6, 0x21),    // set i, 0
-		cpu.Encode(cpu.IFE, 6, 0x31),    // ife i, 16
-		cpu.Encode(cpu.SET, 0x1c, 0x1f), // set pc, $__main_epilog
-		0x6,
-		cpu.Encode(cpu.ADD, 6, 0x22), // add i, 1
+		cpu.Encode(cpu.SET, 6, 0x21),      // set i, 0
+		cpu.Encode(cpu.IFE, 6, 0x31),      // ife i, 16
+		cpu.Encode(cpu.SET, 0x1c, 0x21+5), // set pc, $__main_epilog
+		cpu.Encode(cpu.ADD, 6, 0x22),      // add i, 1
 
 		cpu.Encode(cpu.SET, 6, 0x18),    // $__main_epilog: set i, pop
 		cpu.Encode(cpu.SET, 0x1c, 0x18), // set pc, pop
 	)
 }
+
+func TestExpression(t *testing.T) {
+	doTest(t,
+		`set a, 2 + 3 * 4
+		 set b, (2 + 3) * 4
+		 set c, 1 << 8 | 0xf
+		 set x, -1
+		 set y, 10 / 3 + 10 % 3 - 'A' + 'A'`,
+		cpu.Encode(cpu.SET, 0, 0x21+14),
+		cpu.Encode(cpu.SET, 1, 0x21+20),
+		cpu.Encode(cpu.SET, 2, 0x1f),
+		0x10f,
+		cpu.Encode(cpu.SET, 3, 0x20),
+		cpu.Encode(cpu.SET, 4, 0x21+4),
+	)
+}
+
+func TestBlockExpression(t *testing.T) {
+	doTest(t,
+		`set a, [label + 2*4 + b]
+		 set [c - 1], [sp + 2]
+		 set [label + 1], [sp]
+		:label
+		 set pc, label - 1`,
+		cpu.Encode(cpu.SET, 0, 0x11),
+		0xf,
+		cpu.Encode(cpu.SET, 0x12, 0x1a),
+		0xffff,
+		2,
+		cpu.Encode(cpu.SET, 0x1e, 0x19),
+		0x8,
+		cpu.Encode(cpu.SET, 0x1c, 0x21+6),
+	)
+}
+
+func TestConstExpression(t *testing.T) {
+	doTest(t,
+		`equ Width, 32
+		 equ Height, 12
+		 equ Size, Width * Height
+		 set a, Size + 1
+		 set b, Height - Width / 4`,
+		cpu.Encode(cpu.SET, 0, 0x1f),
+		385,
+		cpu.Encode(cpu.SET, 1, 0x21+4),
+	)
+}
+
+func TestDatExpression(t *testing.T) {
+	doTest(t,
+		`:start
+		 dat end - start, end + 1, "ab", 'c' + 1
+		:end`,
+		5, 6, 'a', 'b', 'd',
+	)
+}
+
+func TestExpressionErrors(t *testing.T) {
+	list := []string{
+		`set a, 1 / 0`,
+		`set a, 0xffff + 1`,
+		`set a, [b + c]`,
+		`set a, [2 * b]`,
+		`set a, b + 1`,
+		`set a, missing + 1`,
+	}
+
+	for _, src := range list {
+		var ast parser.AST
+
+		if err := ast.Parse(bytes.NewBufferString(src), ""); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, _, err := Assemble(&ast); err == nil {
+			t.Fatalf("Expected error for %q", src)
+		} else if _, ok := err.(*BuildError); !ok {
+			t.Fatalf("Expected BuildError for %q, got %T", src, err)
+		}
+	}
+}
+
+func TestOrg(t *testing.T) {
+	doTest(t,
+		`set pc, main
+		 org 4
+		:main
+		 exit`,
+		cpu.Encode(cpu.SET, 0x1c, 0x21+4),
+		0, 0, 0,
+		cpu.Encode(cpu.EXT, cpu.EXIT, 0),
+	)
+}
+
+func TestReserveFill(t *testing.T) {
+	doTest(t,
+		`reserve 2
+		 fill 3, end - 1
+		:end`,
+		0, 0, 4, 4, 4,
+	)
+}
+
+func TestAlign(t *testing.T) {
+	doTest(t,
+		`dat 1
+		 align 4
+		 dat 2
+		 align 4
+		 align 2
+		 dat 3`,
+		1, 0, 0, 0, 2, 0, 0, 0, 3,
+	)
+}
+
+func TestDirectiveErrors(t *testing.T) {
+	for _, src := range []string{
+		"dat 1, 2\norg 1",
+		"align 0",
+		"reserve end\n:end",
+		"org 0xffff\nreserve 2",
+	} {
+		var ast parser.AST
+
+		if err := ast.Parse(bytes.NewBufferString(src), ""); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, _, err := Assemble(&ast); err == nil {
+			t.Fatalf("Want error for %q", src)
+		}
+	}
+}
+
+func TestMacro(t *testing.T) {
+	doTest(t,
+		`macro push2 p, q
+		    set push, p
+		    set push, q
+		 endm
+		 macro wait reg
+		 :loop
+		    sub reg, 1
+		    ifn reg, 0
+		       set pc, loop
+		 endm
+		 push2 1, 2 * 3
+		 wait a
+		 wait b`,
+		0x8b01, 0x9f01,
+		0x8803, 0x8413, 0x8f81,
+		0x8823, 0x8433, 0x9b81,
+	)
+}
+
+func TestLocalLabels(t *testing.T) {
+	var ast parser.AST
+
+	src := `:main
+		:.loop
+		    set pc, .loop
+		 def func
+		 :.loop
+		    set pc, .loop
+		 end
+		 :-
+		    set pc, -`
+
+	if err := ast.Parse(bytes.NewBufferString(src), ""); err != nil {
+		t.Fatal(err)
+	}
+
+	code, dbg, err := Assemble(&ast)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []cpu.Word{0x8781, 0x8b81, 0x6381, 0x9381}
+	for i := range want {
+		if code[i] != want[i] {
+			t.Fatalf("Code mismatch at %d. Want %04x, got %04x", i, want[i], code[i])
+		}
+	}
+
+	for _, name := range []string{"main.loop", "func.loop"} {
+		var found bool
+
+		for _, l := range dbg.Labels {
+			found = found || l.Name == name
+		}
+
+		if !found {
+			t.Fatalf("Label %q missing from debug info.", name)
+		}
+	}
+}
+
+func TestMultipleErrors(t *testing.T) {
+	var ast parser.AST
+
+	src := `set a, 1
+	foo a, b
+	set a, missing
+	set b
+	def f
+		jsr
+		set pc, other
+	end
+	set c, 2`
+
+	if err := ast.Parse(bytes.NewBufferString(src), ""); err != nil {
+		t.Fatal(err)
+	}
+
+	_, _, err := Assemble(&ast)
+
+	list, ok := err.(parser.ErrorList)
+	if !ok {
+		t.Fatalf("Expected ErrorList, got %T: %v", err, err)
+	}
+
+	// Fixups are resolved last.
+	want := []int{2, 4, 6, 3, 7}
+
+	if len(list) != len(want) {
+		t.Fatalf("Want %d errors, have %d:\n%v", len(want), len(list), err)
+	}
+
+	for i, e := range list {
+		be, ok := e.(*BuildError)
+		if !ok {
+			t.Fatalf("Expected BuildError, got %T: %v", e, e)
+		}
+
+		if be.Line != want[i] {
+			t.Fatalf("Error %d: Want line %d, have %d:\n%v", i, want[i], be.Line, err)
+		}
+	}
+}
+
+func TestRelaxation(t *testing.T) {
+	// Each forward reference fits in a short literal once the one
+	// after it has been shortened.
+	doTest(t,
+		`:zero
+		   set a, l1
+		   set b, l2
+		   set c, [zero + a]
+		   set pc, end
+		:l1
+		   dat 1
+		:l2
+		   org 0x1d
+		:end
+		   set x, 0x20 - end`,
+		cpu.Encode(cpu.SET, 0, 0x21+4),
+		cpu.Encode(cpu.SET, 1, 0x21+5),
+		cpu.Encode(cpu.SET, 2, 0x08),
+		cpu.Encode(cpu.SET, 0x1c, 0x21+0x1d),
+		1,
+		0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+		cpu.Encode(cpu.SET, 3, 0x21+3),
+	)
+}
+
+func TestRelaxationDebugInfo(t *testing.T) {
+	var ast parser.AST
+
+	src := `  set pc, main
+		   dat "abc"
+		:main
+		   jsr sub
+		   exit
+		:sub
+		   set pc, pop`
+
+	if err := ast.Parse(bytes.NewBufferString(src), ""); err != nil {
+		t.Fatal(err)
+	}
+
+	code, dbg, err := Assemble(&ast)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(dbg.SourceMapping) != len(code) {
+		t.Fatalf("Want %d source mappings, got %d.", len(code), len(dbg.SourceMapping))
+	}
+
+	want := map[string]cpu.Word{"main": 4, "sub": 6}
+
+	for _, l := range dbg.Labels {
+		if l.Addr != want[l.Name] {
+			t.Fatalf("Label %q: want address %d, got %d.", l.Name, want[l.Name], l.Addr)
+		}
+	}
+
+	if line := dbg.SourceMapping[4].Line; line != 4 {
+		t.Fatalf("Want jsr at line 4, got %d.", line)
+	}
+}
+
+func TestRelativeJumps(t *testing.T) {
+	var ast parser.AST
+
+	src := `  org 0x30
+		:back
+		   set pc, fwd
+		   set pc, back
+		   set pc, far
+		   set pc, 5
+		:fwd
+		   org 0x60
+		:far`
+
+	if err := ast.Parse(bytes.NewBufferString(src), ""); err != nil {
+		t.Fatal(err)
+	}
+
+	code, _, err := AssembleWith(&ast, Options{RelativeJumps: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []cpu.Word{
+		cpu.Encode(cpu.ADD, 0x1c, 0x21+4), // set pc, fwd
+		cpu.Encode(cpu.SUB, 0x1c, 0x21+2), // set pc, back
+		cpu.Encode(cpu.SET, 0x1c, 0x1f),   // set pc, far
+		0x60,
+		cpu.Encode(cpu.SET, 0x1c, 0x21+5), // set pc, 5
+	}
+
+	for i := range want {
+		if code[0x30+i] != want[i] {
+			t.Fatalf("Code mismatch at %d. Want %04x, got %04x", 0x30+i, want[i], code[0x30+i])
+		}
+	}
+}
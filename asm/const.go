@@ -44,6 +44,15 @@ func parseConstants(ast *parser.AST, list []parser.Node) (out []parser.Node, err
 		i--
 	}
 
+	// Constants may refer to other constants.
+	for k, v := range consts {
+		for k2 := range consts {
+			if k2 != k {
+				consts[k2] = replaceConstantRef(consts[k2], k, v)
+			}
+		}
+	}
+
 	for k, v := range consts {
 		list = replaceConstantRef(list, k, v)
 	}
@@ -74,11 +83,12 @@ func replaceConstantRef(in []parser.Node, key string, value []parser.Node) []par
 				in = in[:len(in)-1]
 
 			default:
-				tmp := make([]parser.Node, (len(in)-1)+vsize)
-				copy(tmp, in[:i])
-				copy(tmp[i:], value)
-				copy(tmp[i+vsize:], in[i+1:])
-				in = tmp
+				// Wrap multi-node values in their own expression.
+				// This ensures operator precedence is preserved
+				// when the constant is used inside a larger expression.
+				expr := parser.NewExpression(tt.File(), tt.Line(), tt.Col())
+				expr.SetChildren(append([]parser.Node(nil), value...))
+				in[i] = expr
 			}
 		}
 	}
@@ -6,6 +6,7 @@ package asm
 import (
 	"github.com/jteeuwen/dcpu/cpu"
 	"github.com/jteeuwen/dcpu/parser"
+	"sort"
 )
 
 type FileInfo struct {
@@ -21,6 +22,12 @@ type FuncInfo struct {
 	EndLine   int
 }
 
+// LabelInfo defines the address of a single label.
+type LabelInfo struct {
+	Name string
+	Addr cpu.Word
+}
+
 // SourceInfo defines file/line/col locations in original source.
 type SourceInfo struct {
 	File int
@@ -32,6 +39,7 @@ type SourceInfo struct {
 type DebugInfo struct {
 	Files         []FileInfo   // List of files used to build the original source.
 	Functions     []FuncInfo   // List of function descriptors.
+	Labels        []LabelInfo  // List of labels, sorted by address.
 	SourceMapping []SourceInfo // Binary <-> Source mappings. One entry per instruction.
 }
 
@@ -60,6 +68,22 @@ func (d *DebugInfo) SetFileDefs(files []string) {
 	}
 }
 
+// SetLabels stores the given label addresses.
+func (d *DebugInfo) SetLabels(labels map[string]cpu.Word) {
+	d.Labels = make([]LabelInfo, 0, len(labels))
+
+	for k, v := range labels {
+		d.Labels = append(d.Labels, LabelInfo{k, v})
+	}
+
+	d.SortLabels()
+}
+
+// SortLabels sorts the label list by address and then by name.
+func (d *DebugInfo) SortLabels() {
+	sort.Sort(labelsByAddr(d.Labels))
+}
+
 func (d *DebugInfo) SetFunctionStart(addr cpu.Word, line int, name string) {
 	d.Functions = append(d.Functions, FuncInfo{
 		Name:      name,
@@ -79,3 +103,15 @@ func (d *DebugInfo) Emit(n ...parser.Node) {
 		d.SourceMapping = append(d.SourceMapping, SourceInfo{n[i].File(), n[i].Line(), n[i].Col()})
 	}
 }
+
+// labelsByAddr sorts labels by address and then by name.
+type labelsByAddr []LabelInfo
+
+func (s labelsByAddr) Len() int      { return len(s) }
+func (s labelsByAddr) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s labelsByAddr) Less(i, j int) bool {
+	if s[i].Addr == s[j].Addr {
+		return s[i].Name < s[j].Name
+	}
+	return s[i].Addr < s[j].Addr
+}
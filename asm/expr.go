@@ -0,0 +1,83 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package asm
+
+import (
+	"github.com/jteeuwen/dcpu/cpu"
+	"github.com/jteeuwen/dcpu/parser"
+)
+
+// fixup denotes an expression which could not be evaluated during the
+// first assembly pass, because it references labels which were not yet
+// defined at that point. It is evaluated again once all labels are known.
+type fixup struct {
+	addr  cpu.Word      // Index into `code` which receives the value.
+	nodes []parser.Node // Expression to evaluate.
+}
+
+// eval evaluates the given constant expression.
+// See parser.Evaluator for the rules.
+//
+// References to labels which have not been defined yet, evaluate to zero.
+// The first of these is returned as `unresolved`. In that case, the value
+// is meaningless and the caller should register a fixup for it.
+//
+// While relaxing the layout, labels which are defined further down
+// evaluate to their address in the previous pass.
+func (a *assembler) eval(nodes []parser.Node) (val cpu.Word, unresolved *parser.Name, err error) {
+	return a.evaluate(nodes, a.layout)
+}
+
+// evaluate evaluates the given constant expression, using the given
+// addresses for labels which have not been defined yet.
+func (a *assembler) evaluate(nodes []parser.Node, layout map[string]cpu.Word) (val cpu.Word, unresolved *parser.Name, err error) {
+	return a.evaluator(layout).Eval(nodes)
+}
+
+// evaluator returns an expression evaluator which knows about
+// label addresses and imports.
+func (a *assembler) evaluator(layout map[string]cpu.Word) *parser.Evaluator {
+	return &parser.Evaluator{
+		Errorf: a.errorf,
+		Value: func(n *parser.Name) (int64, bool, error) {
+			if _, ok := registers[n.Data]; ok {
+				return 0, false, a.errorf(n, "Illegal use of register %q.", n.Data)
+			}
+
+			if addr, ok := a.labels[n.Data]; ok {
+				return int64(addr) + a.shift, true, nil
+			}
+
+			if addr, ok := layout[n.Data]; ok {
+				return int64(addr) + a.shift, true, nil
+			}
+
+			if a.imports != nil {
+				return a.imports[n.Data], true, nil
+			}
+
+			return 0, false, nil
+		},
+	}
+}
+
+// hasLabelRefs returns true if the given expression
+// references any labels.
+func hasLabelRefs(nodes []parser.Node) bool {
+	for i := range nodes {
+		switch tt := nodes[i].(type) {
+		case *parser.Name:
+			if _, ok := registers[tt.Data]; !ok {
+				return true
+			}
+
+		case parser.NodeCollection:
+			if hasLabelRefs(tt.Children()) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
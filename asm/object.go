@@ -0,0 +1,269 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package asm
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/jteeuwen/dcpu/cpu"
+	"github.com/jteeuwen/dcpu/parser"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Version of the object file format.
+const ObjectVersion = 1
+
+// Values used to find out how an expression depends on label addresses.
+// Relocatable expressions change by exactly the same amount as the
+// labels they reference.
+var relocProbes = [...]int64{1, 0x1234}
+
+// Relocation describes a word in an object which depends on the
+// address at which code ends up in the final image.
+type Relocation struct {
+	Addr   cpu.Word // Address of the word, relative to the start of the object.
+	Symbol string   // Imported symbol whose address is added. Empty for the object's own base address.
+}
+
+// Object is a relocatable object. It holds code assembled as if
+// it starts at address 0, along with the information needed to
+// move it elsewhere and link it against other objects.
+type Object struct {
+	Version     int
+	Code        []cpu.Word
+	Exports     []LabelInfo  // Labels visible to other objects, sorted by address.
+	Imports     []string     // Labels referenced, but not defined in this object.
+	Relocations []Relocation // Words to patch, sorted by address.
+	Debug       *DebugInfo
+}
+
+// AssembleObject assembles the given AST into a relocatable object.
+//
+// Unlike Assemble, references to undefined labels are not an error.
+// They become imports, to be resolved by the linker. Expressions which
+// reference labels, must be of the form `label + constant`. Differences
+// between two local labels are allowed as well.
+//
+// Only labels named after one of the source files are exported, like
+// `memchr` in memchr.dasm. This is the same convention the source reader
+// uses to find library code. All other labels are private to the object,
+// so helpers like `loop` do not clash with those in other objects.
+func AssembleObject(ast *parser.AST) (obj *Object, err error) {
+	var asm assembler
+	asm.object = true
+
+	if err = asm.assemble(ast); err != nil {
+		return
+	}
+
+	if err = asm.errs.Err(ast.MaxErrors); err != nil {
+		return
+	}
+
+	obj = new(Object)
+	obj.Version = ObjectVersion
+	obj.Debug = asm.debug
+
+	// All labels are known now. Anything else is an import.
+	asm.imports = make(map[string]int64)
+	imports := make(map[string]bool)
+
+	for _, v := range asm.refs {
+		var relocs []Relocation
+
+		if relocs, err = asm.relocate(v, imports); err != nil {
+			return nil, err
+		}
+
+		obj.Relocations = append(obj.Relocations, relocs...)
+	}
+
+	for name := range imports {
+		obj.Imports = append(obj.Imports, name)
+	}
+
+	sort.Strings(obj.Imports)
+	sort.Sort(relocsByAddr(obj.Relocations))
+
+	obj.Code = asm.code
+	obj.Exports = exports(ast.Files, asm.debug.Labels)
+	return
+}
+
+// exports returns the labels which are named after one of the given files.
+func exports(files []string, labels []LabelInfo) []LabelInfo {
+	names := make(map[string]bool)
+
+	for _, file := range files {
+		name := filepath.Base(file)
+		names[strings.TrimSuffix(name, filepath.Ext(name))] = true
+	}
+
+	var list []LabelInfo
+
+	for _, label := range labels {
+		if names[label.Name] {
+			list = append(list, label)
+		}
+	}
+
+	return list
+}
+
+// relocate evaluates the given fixup with imports at address zero,
+// and finds the relocations it needs. Referenced imports are added
+// to the given set.
+func (a *assembler) relocate(f *fixup, imports map[string]bool) (list []Relocation, err error) {
+	val, _, err := a.eval(f.nodes)
+	if err != nil {
+		return
+	}
+
+	a.code[f.addr] = val
+
+	local, names := a.findRefs(f.nodes, nil)
+
+	if local {
+		ok, err := a.probe(f, val, func(v int64) { a.shift = v })
+		if err != nil {
+			return nil, err
+		}
+
+		if ok {
+			list = append(list, Relocation{Addr: f.addr})
+		}
+	}
+
+	for _, name := range names {
+		imports[name] = true
+
+		ok, err := a.probe(f, val, func(v int64) { a.imports[name] = v })
+		if err != nil {
+			return nil, err
+		}
+
+		if ok {
+			list = append(list, Relocation{Addr: f.addr, Symbol: name})
+		}
+	}
+
+	return
+}
+
+// probe evaluates the fixup with labels moved by a number of probe
+// values. set is used to move them. Returns true if the value moves
+// along with the labels, false if it does not move at all.
+// Anything else can not be relocated.
+func (a *assembler) probe(f *fixup, val cpu.Word, set func(int64)) (moves bool, err error) {
+	defer set(0)
+
+	for i, p := range relocProbes {
+		set(p)
+
+		var v int64
+		if v, _, err = a.evaluator(nil).EvalInt(f.nodes); err != nil {
+			return
+		}
+
+		delta := cpu.Word(v) - val
+
+		switch {
+		case delta == cpu.Word(p) && (i == 0 || moves):
+			moves = true
+		case delta == 0 && (i == 0 || !moves):
+			moves = false
+		default:
+			return false, a.errorf(f.nodes[0],
+				"Expression can not be relocated. Use `label + constant`.")
+		}
+	}
+
+	return
+}
+
+// findRefs finds label references in the given expression. It returns
+// true if any local labels are referenced, along with the names of
+// referenced imports.
+func (a *assembler) findRefs(nodes []parser.Node, names []string) (local bool, out []string) {
+	out = names
+
+	for i := range nodes {
+		switch tt := nodes[i].(type) {
+		case *parser.Name:
+			if _, ok := registers[tt.Data]; ok {
+				break
+			}
+
+			if _, ok := a.labels[tt.Data]; ok {
+				local = true
+			} else if !containsString(out, tt.Data) {
+				out = append(out, tt.Data)
+			}
+
+		case parser.NodeCollection:
+			var l bool
+			l, out = a.findRefs(tt.Children(), out)
+			local = local || l
+		}
+	}
+
+	return
+}
+
+// WriteObject writes the given object.
+func WriteObject(w io.Writer, obj *Object) (err error) {
+	data, err := json.MarshalIndent(obj, "", " ")
+	if err != nil {
+		return
+	}
+
+	_, err = w.Write(data)
+	return
+}
+
+// ReadObject reads an object, as written by WriteObject.
+func ReadObject(r io.Reader) (obj *Object, err error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return
+	}
+
+	obj = new(Object)
+	if err = json.Unmarshal(data, obj); err != nil {
+		return nil, err
+	}
+
+	if obj.Version != ObjectVersion {
+		return nil, errors.New(fmt.Sprintf(
+			"Unsupported object version %d. Want %d.", obj.Version, ObjectVersion))
+	}
+
+	return
+}
+
+// relocsByAddr sorts relocations by address and then by symbol.
+type relocsByAddr []Relocation
+
+func (s relocsByAddr) Len() int      { return len(s) }
+func (s relocsByAddr) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s relocsByAddr) Less(i, j int) bool {
+	if s[i].Addr == s[j].Addr {
+		return s[i].Symbol < s[j].Symbol
+	}
+	return s[i].Addr < s[j].Addr
+}
+
+func containsString(list []string, s string) bool {
+	for i := range list {
+		if list[i] == s {
+			return true
+		}
+	}
+	return false
+}
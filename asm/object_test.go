@@ -0,0 +1,151 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package asm
+
+import (
+	"bytes"
+	"github.com/jteeuwen/dcpu/cpu"
+	"github.com/jteeuwen/dcpu/parser"
+	"testing"
+)
+
+func assembleObject(t *testing.T, src string) *Object {
+	var ast parser.AST
+
+	if err := ast.Parse(bytes.NewBufferString(src), "test.dasm"); err != nil {
+		t.Fatal(err)
+	}
+
+	obj, err := AssembleObject(&ast)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return obj
+}
+
+func TestObject(t *testing.T) {
+	obj := assembleObject(t,
+		`:start
+		 set pc, start
+		 jsr memchr
+		 set a, [data + 1]
+		 set b, end - start
+		 set c, memchr + 2
+		:data
+		 dat data, 5
+		:end`)
+
+	code := []cpu.Word{
+		cpu.Encode(cpu.SET, 0x1c, 0x1f), 0x0000,
+		cpu.Encode(cpu.EXT, cpu.JSR, 0x1f), 0x0000,
+		cpu.Encode(cpu.SET, 0x00, 0x1e), 0x000b,
+		cpu.Encode(cpu.SET, 0x01, 0x1f), 0x000c,
+		cpu.Encode(cpu.SET, 0x02, 0x1f), 0x0002,
+		0x000a, 0x0005,
+	}
+
+	if len(obj.Code) != len(code) {
+		t.Fatalf("Want %04x, got %04x", code, obj.Code)
+	}
+
+	for i := range code {
+		if obj.Code[i] != code[i] {
+			t.Fatalf("Want %04x, got %04x", code, obj.Code)
+		}
+	}
+
+	relocs := []Relocation{
+		{0x01, ""},
+		{0x03, "memchr"},
+		{0x05, ""},
+		{0x09, "memchr"},
+		{0x0a, ""},
+	}
+
+	if len(obj.Relocations) != len(relocs) {
+		t.Fatalf("Want %v, got %v", relocs, obj.Relocations)
+	}
+
+	for i := range relocs {
+		if obj.Relocations[i] != relocs[i] {
+			t.Fatalf("Want %v, got %v", relocs, obj.Relocations)
+		}
+	}
+
+	if len(obj.Imports) != 1 || obj.Imports[0] != "memchr" {
+		t.Fatalf("Want imports [memchr], got %v", obj.Imports)
+	}
+
+	// None of the labels is named after the file.
+	if len(obj.Exports) != 0 {
+		t.Fatalf("Want no exports, got %v", obj.Exports)
+	}
+}
+
+func TestObjectExports(t *testing.T) {
+	var ast parser.AST
+
+	src := `:memchr
+	        ifn [a], b
+	           set pc, skip
+	        set pc, pop
+	       :skip
+	        add a, 1
+	        set pc, memchr`
+
+	if err := ast.Parse(bytes.NewBufferString(src), "lib/memchr.dasm"); err != nil {
+		t.Fatal(err)
+	}
+
+	obj, err := AssembleObject(&ast)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(obj.Exports) != 1 || obj.Exports[0].Name != "memchr" {
+		t.Fatalf("Want exports [memchr], got %v", obj.Exports)
+	}
+
+	// Private labels are still relocated.
+	if len(obj.Relocations) != 2 || len(obj.Imports) != 0 {
+		t.Fatalf("Want 2 local relocations, got %v, imports %v",
+			obj.Relocations, obj.Imports)
+	}
+}
+
+func TestObjectInvalidRelocation(t *testing.T) {
+	var ast parser.AST
+
+	src := `:start
+	        set a, start * 2`
+
+	if err := ast.Parse(bytes.NewBufferString(src), "test.dasm"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := AssembleObject(&ast); err == nil {
+		t.Fatalf("Want relocation error")
+	}
+}
+
+func TestObjectReadWrite(t *testing.T) {
+	var buf bytes.Buffer
+
+	obj := assembleObject(t, `jsr memchr`)
+
+	if err := WriteObject(&buf, obj); err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := ReadObject(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(out.Code) != 2 || len(out.Relocations) != 1 ||
+		out.Relocations[0].Symbol != "memchr" {
+		t.Fatalf("Object differs after reading it back")
+	}
+}
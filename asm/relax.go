@@ -0,0 +1,150 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package asm
+
+import (
+	"github.com/jteeuwen/dcpu/cpu"
+	"github.com/jteeuwen/dcpu/parser"
+)
+
+// Maximum number of passes made to relax the program layout.
+const maxPasses = 100
+
+// pass compiles the given nodes from scratch. Labels which are not
+// defined yet, are looked up in the given layout.
+func (a *assembler) pass(nodes []parser.Node, layout map[string]cpu.Word) {
+	a.layout = layout
+	a.compact, a.next = a.next, make(map[parser.Node]bool)
+
+	a.code = a.code[:0]
+	a.refs = a.refs[:0]
+	a.errs = a.errs[:0]
+	a.labels = make(map[string]cpu.Word)
+	a.debug = new(DebugInfo)
+
+	a.buildNodes(nodes)
+}
+
+// relax rebuilds the program until its layout no longer changes.
+//
+// The first pass encodes forward references in their long form, since
+// their values are unknown. Each following pass uses the label addresses
+// of the pass before it, so operands which turn out to be small enough,
+// get a compact encoding. This moves labels, which can change the value
+// of other operands.
+//
+// To guarantee this ends, an operand which loses its compact encoding is
+// fixed to its long form. Every operand changes size at most twice, so
+// eventually a pass produces the same layout as the one before it. All
+// values were then computed with the final label addresses.
+func (a *assembler) relax(nodes []parser.Node) {
+	a.long = make(map[parser.Node]bool)
+
+	for i := 0; i < maxPasses; i++ {
+		layout := a.labels
+		a.pass(nodes, layout)
+
+		if sameLayout(layout, a.labels) {
+			return
+		}
+	}
+
+	// This should not happen. Fall back to the layout of the first pass.
+	a.long, a.next = nil, nil
+	a.pass(nodes, nil)
+}
+
+// fits determines if the given operand gets a compact encoding. The
+// encoding must be possible with the operand's current value.
+func (a *assembler) fits(n parser.Node, compact bool) bool {
+	if a.long[n] {
+		return false
+	}
+
+	if !compact {
+		if a.compact[n] {
+			a.long[n] = true
+		}
+		return false
+	}
+
+	a.next[n] = true
+	return true
+}
+
+// buildJump compiles `set pc, label` as `add pc, n` or `sub pc, n`, if
+// the target is close enough and this saves a word. It returns false if
+// the instruction should be built as usual.
+func (a *assembler) buildJump(nodes []parser.Node) (bool, error) {
+	if len(nodes) != 3 || !isRegister(nodes[1], "pc") || a.object {
+		return false, nil
+	}
+
+	expr := nodes[2].(*parser.Expression)
+	list := parser.StripComments(expr.Children())
+
+	for _, n := range list {
+		switch tt := n.(type) {
+		case *parser.Block, *parser.String:
+			return false, nil
+		case *parser.Name:
+			if _, ok := registers[tt.Data]; ok {
+				return false, nil
+			}
+		}
+	}
+
+	num, unresolved, err := a.eval(list)
+	if err != nil || (unresolved == nil && isShort(num)) {
+		return false, err
+	}
+
+	// The PC points past the instruction when it executes.
+	pc := cpu.Word(len(a.code) + 1)
+	op, dist := cpu.Word(cpu.ADD), num-pc
+
+	if num < pc {
+		op, dist = cpu.SUB, pc-num
+	}
+
+	if !a.fits(expr, unresolved == nil && dist <= 0x1e) {
+		return false, nil
+	}
+
+	a.debug.Emit(nodes[0])
+	a.code = append(a.code, cpu.Encode(op, registers["pc"], dist+0x21))
+	return true, nil
+}
+
+// sameLayout returns true if both sets of labels have the same addresses.
+func sameLayout(a, b map[string]cpu.Word) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for k, v := range a {
+		if w, ok := b[k]; !ok || w != v {
+			return false
+		}
+	}
+
+	return true
+}
+
+// isShort returns true if the given value can be encoded as a
+// short literal.
+func isShort(v cpu.Word) bool {
+	return v == 0xffff || v <= 0x1e
+}
+
+// isRegister returns true if the given operand is the named register.
+func isRegister(n parser.Node, name string) bool {
+	list := parser.StripComments(n.(*parser.Expression).Children())
+	if len(list) != 1 {
+		return false
+	}
+
+	reg, ok := list[0].(*parser.Name)
+	return ok && reg.Data == name
+}
@@ -11,9 +11,11 @@ import (
 
 // A CPU can run a single program.
 type CPU struct {
-	Store    *Storage  // Memory and registers
-	devices  []Device  // List of hardware devices.
-	intQueue chan Word // Interrupt queue.
+	Store    *Storage        // Memory and registers
+	devices  []Device        // List of hardware devices.
+	clocked  []ClockedDevice // Devices which are advanced in virtual time.
+	intQueue chan Word       // Interrupt queue.
+	history  *History        // Undo log. Nil when disabled.
 
 	// When set, allows tracing of instructions as they are executed.
 	Trace TraceFunc
@@ -28,6 +30,11 @@ type CPU struct {
 	// value can make a lot of difference in total cycle costs.
 	NotifyBranchSkip BranchSkipFunc
 
+	// This handler is fired whenever a HWI instruction takes additional
+	// cycles, because the device's interrupt handler takes a while.
+	// It yields the additional cost along with the address of the HWI.
+	NotifyDeviceCost DeviceCostFunc
+
 	// This one is called whenever a new instruction is about to be executed.
 	// It gives the host an insight into the execution context.
 	//
@@ -35,43 +42,70 @@ type CPU struct {
 	// separate for clarity.
 	InstructionHandler InstructionFunc
 
-	ClockSpeed      time.Duration // Speed of CPU clock.
-	size            Word          // Size of last instruction (in words).
-	queueInterrupts bool          // Use interrupt queueing or not.
+	// Duration of a single clock cycle. Run uses this to pace execution.
+	// Zero runs the CPU as fast as possible.
+	ClockSpeed time.Duration
+
+	size            Word      // Size of last instruction (in words).
+	queueInterrupts bool      // Use interrupt queueing or not.
+	cycles          uint64    // Number of cycles executed.
+	paceStart       time.Time // Time at which pacing started.
+	paceCycles      uint64    // Cycle count at which pacing started.
+	paceNext        uint64    // Cycle count at which to sync with the clock.
 }
 
 // New creates and initializes a new CPU instance.
 func New() *CPU {
 	c := new(CPU)
-	c.ClockSpeed = 1000 // 100khz
+	c.ClockSpeed = DefaultClockSpeed
 	c.Store = new(Storage)
 	c.Reset()
 	return c
 }
 
+// Cycles returns the number of cycles executed since the last reset.
+func (c *CPU) Cycles() uint64 { return c.cycles }
+
 // Devices returns the current list of registered devices.
 func (c *CPU) Devices() []Device { return c.devices }
 
 // CountDevices returns the number of currently registered devices.
 func (c *CPU) CountDevices() int { return len(c.devices) }
 
+// QueueInterrupts returns true if interrupts are being queued.
+func (c *CPU) QueueInterrupts() bool { return c.queueInterrupts }
+
 // Register adds a new device. If capacity has been reached,
 // this is silently ignored. We can have a maximum of MaxUint16 number
 // of devices at any given time.
 func (c *CPU) RegisterDevice(db DeviceBuilder) {
 	if len(c.devices) < 1<<16-1 {
-		c.devices = append(c.devices, db(func(w Word) { c.interrupt(w) }))
+		dev := db(func(w Word) { c.interrupt(w) })
+		c.devices = append(c.devices, dev)
+
+		if cd, ok := dev.(ClockedDevice); ok {
+			c.clocked = append(c.clocked, cd)
+		}
 	}
 }
 
 // ClearDevices removes all registered devices.
-func (c *CPU) ClearDevices() { c.devices = nil }
+func (c *CPU) ClearDevices() {
+	c.devices = nil
+	c.clocked = nil
+}
 
 // Clears CPU state.
 func (c *CPU) Reset() {
 	c.Store.Clear()
 	c.intQueue = make(chan Word, MaxIntQueue)
 	c.queueInterrupts = false
+	c.cycles = 0
+	c.paceStart = time.Time{}
+
+	if c.history != nil {
+		c.history.Clear()
+	}
 }
 
 // interrupt either queues or triggers an interrupt with the given message.
@@ -98,37 +132,83 @@ func (c *CPU) interrupt(msg Word) {
 func (c *CPU) triggerInterrupt(msg Word) {
 	s := c.Store
 	c.queueInterrupts = true
-	s.Mem[s.SP], s.SP = s.PC, s.SP-1 // PUSH PC
-	s.Mem[s.SP], s.SP = s.A, s.SP-1  // PUSH A
+	*c.mem(s.SP), s.SP = s.PC, s.SP-1 // PUSH PC
+	*c.mem(s.SP), s.SP = s.A, s.SP-1  // PUSH A
 	s.PC = s.IA
 	s.A = msg
 }
 
 // Run runs code, starting at the given entrypoint.
 // This repeatedly calls cpu.Step for as long as the program is valid.
+// Execution is paced so that each cycle takes cpu.ClockSpeed.
 //
 // In order to step through code for debugging, call cpu.Step
 // manually.
 func (c *CPU) Run(entrypoint Word) (err error) {
-	clock := time.Tick(c.ClockSpeed)
-	s := c.Store
-	s.PC = entrypoint
+	c.Store.PC = entrypoint
 
 	for {
-		select {
-		case <-clock:
-			if err = c.Step(); err != nil {
-				if err == io.EOF {
-					err = nil // No need to propagate this one.
-				}
-				return
+		if err = c.RunCycles(1 << 16); err != nil {
+			if err == io.EOF {
+				err = nil // No need to propagate this one.
 			}
+			return
+		}
+	}
+
+	return
+}
+
+// RunCycles executes instructions from the current PC, until at least
+// the given number of cycles have passed. Execution is paced so that
+// each cycle takes cpu.ClockSpeed.
+//
+// This returns io.EOF when the program executes an EXIT instruction.
+func (c *CPU) RunCycles(n uint64) (err error) {
+	end := c.cycles + n
+
+	for c.cycles < end {
+		if err = c.Step(); err != nil {
+			return
 		}
+
+		c.pace()
 	}
 
 	return
 }
 
+// pace sleeps for as long as the CPU runs ahead of its clock speed.
+// Sleeping happens in intervals of roughly a millisecond, as
+// sleeping for every single instruction is not accurate.
+func (c *CPU) pace() {
+	if c.ClockSpeed <= 0 {
+		return
+	}
+
+	if c.paceStart.IsZero() {
+		c.paceStart = time.Now()
+		c.paceCycles = c.cycles
+		c.paceNext = c.cycles
+	}
+
+	if c.cycles < c.paceNext {
+		return
+	}
+
+	interval := uint64(time.Millisecond / c.ClockSpeed)
+	if interval == 0 {
+		interval = 1
+	}
+
+	c.paceNext = c.cycles + interval
+
+	ahead := time.Duration(c.cycles-c.paceCycles)*c.ClockSpeed - time.Since(c.paceStart)
+	if ahead > 0 {
+		time.Sleep(ahead)
+	}
+}
+
 // nextInstruction decodes the next instruction in the
 // supplied word pointers.
 func (c *CPU) nextInstruction() (op, a, b Word) {
@@ -173,20 +253,44 @@ func (c *CPU) skipBranch(pc Word) {
 		}
 	}
 
+	// A failed test costs one extra cycle. Every skipped conditional
+	// instruction costs one more. This amounts to one cycle per
+	// skipped instruction.
+	c.cycles += uint64(cost)
+
 	// The skipcount denotes how many instructions we skipped.
 	// The spec notes that for every skipped branch, the cycle cost
 	// increments by one. We should notify somebody about this.
 	//
-	// Cycle counts are generally not our problem in this emulator,
-	// but external tools which do care, can not get to this information
-	// on their own.
+	// External tools which keep track of cycle costs per instruction,
+	// can not get to this information on their own.
 	if c.NotifyBranchSkip != nil {
 		c.NotifyBranchSkip(pc, cost)
 	}
 }
 
-// Step performs a single cycle.
+// Step executes a single instruction. Afterwards, clocked devices
+// are advanced by the number of cycles it took.
 func (c *CPU) Step() (err error) {
+	start := c.cycles
+
+	if c.history != nil {
+		c.history.begin(c)
+	}
+
+	err = c.step()
+
+	if n := c.cycles - start; n > 0 {
+		for _, cd := range c.clocked {
+			cd.Tick(Word(n))
+		}
+	}
+
+	return
+}
+
+// step executes a single instruction.
+func (c *CPU) step() (err error) {
 	var va, vb *Word
 
 	// Handle any queued interrupts.
@@ -211,6 +315,8 @@ func (c *CPU) Step() (err error) {
 
 	vb = c.decodeOperand(b, false)
 
+	c.cycles += uint64(Cost(op, a, b))
+
 	// Notify host of instruction context?
 	if c.InstructionHandler != nil {
 		c.InstructionHandler(s.PC-c.size, s)
@@ -364,7 +470,7 @@ func (c *CPU) Step() (err error) {
 	case EXT:
 		switch a {
 		case JSR:
-			s.Mem[s.SP] = s.PC
+			*c.mem(s.SP) = s.PC
 			s.SP--
 			s.PC = *vb
 
@@ -410,7 +516,21 @@ func (c *CPU) Step() (err error) {
 
 		case HWI:
 			if *vb < Word(len(c.devices)) {
-				c.devices[*vb].Handler(s)
+				dev := c.devices[*vb]
+
+				// Devices may write anywhere in memory. Find out
+				// what they changed, so it can be undone.
+				if c.history != nil {
+					c.history.saveMemory(s)
+					dev.Handler(s)
+					c.history.diffMemory(s)
+				} else {
+					dev.Handler(s)
+				}
+
+				if td, ok := dev.(TimedDevice); ok {
+					c.deviceCost(s.PC-c.size, td.HandlerCost())
+				}
 			}
 
 		case PANIC:
@@ -428,6 +548,30 @@ func (c *CPU) Step() (err error) {
 	return
 }
 
+// deviceCost accounts for additional cycles taken by a device's
+// interrupt handler.
+func (c *CPU) deviceCost(pc, cost Word) {
+	if cost == 0 {
+		return
+	}
+
+	c.cycles += uint64(cost)
+
+	if c.NotifyDeviceCost != nil {
+		c.NotifyDeviceCost(pc, cost)
+	}
+}
+
+// mem returns a pointer to the given memory word. When history is
+// enabled, its current value is recorded, as it may be changed.
+func (c *CPU) mem(addr Word) *Word {
+	if c.history != nil {
+		c.history.record(addr, c.Store.Mem[addr])
+	}
+
+	return &c.Store.Mem[addr]
+}
+
 // decodeOperand interprets the given instruction operand and returns a pointer
 // to the appropriate storage bit along with its address. 
 //
@@ -464,66 +608,66 @@ func (c *CPU) decodeOperand(w Word, isTarget bool) *Word {
 
 	// [register]
 	case 0x8:
-		return &s.Mem[s.A]
+		return c.mem(s.A)
 	case 0x9:
-		return &s.Mem[s.B]
+		return c.mem(s.B)
 	case 0xa:
-		return &s.Mem[s.C]
+		return c.mem(s.C)
 	case 0xb:
-		return &s.Mem[s.X]
+		return c.mem(s.X)
 	case 0xc:
-		return &s.Mem[s.Y]
+		return c.mem(s.Y)
 	case 0xd:
-		return &s.Mem[s.Z]
+		return c.mem(s.Z)
 	case 0xe:
-		return &s.Mem[s.I]
+		return c.mem(s.I)
 	case 0xf:
-		return &s.Mem[s.J]
+		return c.mem(s.J)
 
 	// [next word + register]
 	case 0x10:
 		a, s.PC = s.Mem[s.PC]+s.A, s.PC+1
-		return &s.Mem[a]
+		return c.mem(a)
 	case 0x11:
 		a, s.PC = s.Mem[s.PC]+s.B, s.PC+1
-		return &s.Mem[a]
+		return c.mem(a)
 	case 0x12:
 		a, s.PC = s.Mem[s.PC]+s.C, s.PC+1
-		return &s.Mem[a]
+		return c.mem(a)
 	case 0x13:
 		a, s.PC = s.Mem[s.PC]+s.X, s.PC+1
-		return &s.Mem[a]
+		return c.mem(a)
 	case 0x14:
 		a, s.PC = s.Mem[s.PC]+s.Y, s.PC+1
-		return &s.Mem[a]
+		return c.mem(a)
 	case 0x15:
 		a, s.PC = s.Mem[s.PC]+s.Z, s.PC+1
-		return &s.Mem[a]
+		return c.mem(a)
 	case 0x16:
 		a, s.PC = s.Mem[s.PC]+s.I, s.PC+1
-		return &s.Mem[a]
+		return c.mem(a)
 	case 0x17:
 		a, s.PC = s.Mem[s.PC]+s.J, s.PC+1
-		return &s.Mem[a]
+		return c.mem(a)
 
 	// isTarget ? (PUSH / [--SP]) : (POP / [SP++])
 	case 0x18:
 		if isTarget {
 			s.SP--
-			return &s.Mem[s.SP+1]
+			return c.mem(s.SP+1)
 		}
 
 		s.SP++
-		return &s.Mem[s.SP]
+		return c.mem(s.SP)
 
 	// [SP] / PEEK
 	case 0x19:
-		return &s.Mem[s.SP]
+		return c.mem(s.SP)
 
 	// [SP + next word] / PICK n
 	case 0x1a:
 		a, s.PC = s.Mem[s.PC], s.PC+1
-		return &s.Mem[a+s.SP]
+		return c.mem(a+s.SP)
 
 	case 0x1b:
 		return &s.SP
@@ -537,12 +681,12 @@ func (c *CPU) decodeOperand(w Word, isTarget bool) *Word {
 	// [next word]
 	case 0x1e:
 		a, s.PC = s.Mem[s.PC], s.PC+1
-		return &s.Mem[a]
+		return c.mem(a)
 
 	// Next word (literal)
 	case 0x1f:
 		s.PC++
-		return &s.Mem[s.PC-1]
+		return c.mem(s.PC-1)
 	}
 
 	return &w
@@ -552,11 +696,11 @@ func (c *CPU) decodeOperand(w Word, isTarget bool) *Word {
 func Sizeof(op, a, b Word) (count Word) {
 	count = 1
 
-	if op != EXT && (a == 0x1e || a == 0x1f || (a >= 0x10 && a <= 0x17)) {
+	if op != EXT && hasNextWord(a) {
 		count++
 	}
 
-	if b == 0x1e || b == 0x1f || (b >= 0x10 && b <= 0x17) {
+	if hasNextWord(b) {
 		count++
 	}
 
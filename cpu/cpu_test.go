@@ -360,3 +360,113 @@ func TestHwi(t *testing.T) {
 	s.Mem[6] = _exit
 	doTest(t, c, 0xbeef, 0)
 }
+
+type TimedTestDevice struct {
+	TestDevice
+}
+
+func NewTimedTestDevice(f IntFunc) Device {
+	return &TimedTestDevice{TestDevice{f}}
+}
+
+func (d *TimedTestDevice) HandlerCost() Word { return 10 }
+
+func TestCycles(t *testing.T) {
+	c := New()
+	s := c.Store
+	s.Mem[0] = Encode(SET, 0, 0x1f) // SET A, 0x30  ; 2 cycles
+	s.Mem[1] = 0x30
+	s.Mem[2] = Encode(IFN, 0, 0x1f) // IFN A, 0x30  ; 3 cycles, +1 for failing
+	s.Mem[3] = 0x30
+	s.Mem[4] = Encode(IFE, 0, 0x21) // IFE A, 0x0   ; skipped, +1
+	s.Mem[5] = Encode(SET, 0, 0x21) // SET A, 0x0   ; skipped
+	s.Mem[6] = Encode(ADD, 0, 0x22) // ADD A, 0x1   ; 2 cycles
+	s.Mem[7] = _exit                // EXIT         ; 1 cycle
+	doTest(t, c, 0x31, 0)
+
+	if c.Cycles() != 10 {
+		t.Fatalf("Want 10 cycles, got %d", c.Cycles())
+	}
+}
+
+func TestHwiCycles(t *testing.T) {
+	var notified Word
+
+	c := New()
+	s := c.Store
+	c.RegisterDevice(NewTimedTestDevice)
+	c.NotifyDeviceCost = func(pc, cost Word) { notified = cost }
+	s.Mem[0] = Encode(EXT, HWI, 0x21) // HWI 0  ; 4 cycles, +10 for the device
+	s.Mem[1] = _exit                  // EXIT   ; 1 cycle
+	doTest(t, c, 0, 0)
+
+	if c.Cycles() != 15 {
+		t.Fatalf("Want 15 cycles, got %d", c.Cycles())
+	}
+
+	if notified != 10 {
+		t.Fatalf("Want device cost notification of 10, got %d", notified)
+	}
+}
+
+func TestRunCycles(t *testing.T) {
+	c := New()
+	c.ClockSpeed = 0
+	s := c.Store
+	s.Mem[0] = Encode(ADD, 0, 0x22)    // ADD A, 0x1   ; 2 cycles
+	s.Mem[1] = Encode(SET, 0x1c, 0x21) // SET PC, 0  ; 1 cycle
+
+	if err := c.RunCycles(30); err != nil {
+		t.Fatal(err)
+	}
+
+	if c.Cycles() != 30 || s.A != 10 {
+		t.Fatalf("Want 30 cycles and A=10, got %d and %d", c.Cycles(), s.A)
+	}
+}
+
+// AlarmDevice sends an interrupt once a given number of cycles has passed.
+type AlarmDevice struct {
+	TestDevice
+	left Word
+}
+
+func NewAlarmDevice(f IntFunc) Device {
+	return &AlarmDevice{TestDevice{f}, 10}
+}
+
+func (d *AlarmDevice) Tick(cycles Word) {
+	if d.left == 0 {
+		return
+	}
+
+	if cycles < d.left {
+		d.left -= cycles
+		return
+	}
+
+	d.left = 0
+	d.f(0x42)
+}
+
+func TestClockedDevice(t *testing.T) {
+	c := New()
+	c.ClockSpeed = 0
+	s := c.Store
+	c.RegisterDevice(NewAlarmDevice)
+	s.Mem[0] = Encode(EXT, IAS, 0x25)  // IAS 4
+	s.Mem[1] = Encode(ADD, 1, 0x22)    // ADD B, 1      ; 2 cycles
+	s.Mem[2] = Encode(SET, 0x1c, 0x22) // SET PC, 1     ; 1 cycle
+	s.Mem[3] = _exit                   // EXIT
+	s.Mem[4] = Encode(SET, 0x1c, 0x24) // SET PC, 3     ; interrupt handler
+
+	if err := c.Run(0); err != nil {
+		t.Fatal(err)
+	}
+
+	// The alarm fires after 10 cycles. IAS takes 1, each loop takes 3.
+	// It fires during the third loop, after ADD B, 1.
+	if s.A != 0x42 || s.B != 3 {
+		t.Fatalf("Want A=0042 and B=0003, got %04x and %04x", s.A, s.B)
+	}
+}
@@ -0,0 +1,87 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package cpu
+
+// Cycle costs for basic opcodes, as defined in the DCPU-16 1.7 spec.
+// Entries for invalid opcodes are zero.
+var basicCost = [...]Word{
+	SET: 1,
+	ADD: 2,
+	SUB: 2,
+	MUL: 2,
+	MLI: 2,
+	DIV: 3,
+	DVI: 3,
+	MOD: 3,
+	MDI: 3,
+	AND: 1,
+	BOR: 1,
+	XOR: 1,
+	SHR: 1,
+	ASR: 1,
+	SHL: 1,
+	IFB: 2,
+	IFC: 2,
+	IFE: 2,
+	IFN: 2,
+	IFG: 2,
+	IFA: 2,
+	IFL: 2,
+	IFU: 2,
+	ADX: 3,
+	SBX: 3,
+	STI: 2,
+	STD: 2,
+}
+
+// Cycle costs for extended opcodes. PANIC and EXIT are not part of the
+// spec. They are given the cost of the cheapest instruction.
+var extCost = [...]Word{
+	JSR:   3,
+	INT:   4,
+	IAG:   1,
+	IAS:   1,
+	RFI:   3,
+	IAQ:   2,
+	HWN:   2,
+	HWQ:   4,
+	HWI:   4,
+	PANIC: 1,
+	EXIT:  1,
+}
+
+// Cost returns the base cycle cost of the given instruction.
+// This includes one cycle for every operand which reads the next word.
+//
+// It does not include costs incurred at runtime. These are failed
+// branches and interrupts sent to hardware which take additional time.
+// Invalid instructions cost one cycle.
+func Cost(op, a, b Word) (cost Word) {
+	if op == EXT {
+		if int(a) < len(extCost) {
+			cost = extCost[a]
+		}
+	} else {
+		cost = basicCost[op]
+
+		if hasNextWord(a) {
+			cost++
+		}
+	}
+
+	if cost == 0 {
+		return 1
+	}
+
+	if hasNextWord(b) {
+		cost++
+	}
+
+	return
+}
+
+// hasNextWord returns true if the given operand reads the next word.
+func hasNextWord(w Word) bool {
+	return w == 0x1a || w == 0x1e || w == 0x1f || (w >= 0x10 && w <= 0x17)
+}
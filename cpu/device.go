@@ -34,3 +34,27 @@ type Device interface {
 	// CPU registers and memory.
 	Handler(*Storage)
 }
+
+// A TimedDevice is a device whose interrupt handler can take
+// more time than the standard cost of a HWI instruction.
+type TimedDevice interface {
+	Device
+
+	// HandlerCost returns the number of additional cycles taken
+	// by the last call to Handler.
+	HandlerCost() Word
+}
+
+// A ClockedDevice is a device which is advanced in virtual time.
+//
+// The CPU calls Tick after every instruction, with the number of
+// cycles it took. Devices should use this instead of wall-clock timers
+// or goroutines to schedule their work. This ensures a program behaves
+// the same way every time it is run.
+type ClockedDevice interface {
+	Device
+
+	// Tick advances the device by the given number of cycles.
+	// Any interrupts it sends, are handled before the next instruction.
+	Tick(cycles Word)
+}
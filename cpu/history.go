@@ -0,0 +1,208 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package cpu
+
+import "time"
+
+// memWrite records the value of a memory word before it was changed.
+type memWrite struct {
+	addr Word
+	old  Word
+}
+
+// undoEntry holds everything needed to undo a single Step.
+type undoEntry struct {
+	regs            [12]Word   // A, B, C, X, Y, Z, I, J, PC, SP, EX, IA.
+	cycles          uint64     // Cycle count.
+	queueInterrupts bool       // Interrupt queueing state.
+	queue           []Word     // Contents of the interrupt queue.
+	mem             []memWrite // Memory words which may have changed.
+}
+
+// History is a bounded undo log of executed instructions.
+// Once it is full, the oldest entries are discarded.
+//
+// It records changes made to registers and memory by the instructions
+// themselves, as well as memory written by device interrupt handlers.
+// The internal state of devices is not recorded. Neither is memory
+// which devices change outside of HWI, from their Tick method.
+type History struct {
+	entries []undoEntry
+	start   int    // Index of the oldest entry.
+	count   int    // Number of entries in use.
+	memory  []Word // Copy of memory, used to find changes made by devices.
+}
+
+// newHistory creates a history which holds up to size entries.
+func newHistory(size int) *History {
+	h := new(History)
+	h.entries = make([]undoEntry, size)
+	return h
+}
+
+// Len returns the number of instructions which can be undone.
+func (h *History) Len() int { return h.count }
+
+// Clear discards all entries.
+func (h *History) Clear() {
+	h.start = 0
+	h.count = 0
+}
+
+// begin starts a new entry with the current CPU state.
+func (h *History) begin(c *CPU) {
+	var e *undoEntry
+
+	if h.count < len(h.entries) {
+		e = &h.entries[(h.start+h.count)%len(h.entries)]
+		h.count++
+	} else {
+		e = &h.entries[h.start]
+		h.start = (h.start + 1) % len(h.entries)
+	}
+
+	s := c.Store
+	e.regs = [12]Word{s.A, s.B, s.C, s.X, s.Y, s.Z, s.I, s.J, s.PC, s.SP, s.EX, s.IA}
+	e.cycles = c.cycles
+	e.queueInterrupts = c.queueInterrupts
+	e.queue = e.queue[:0]
+	e.mem = e.mem[:0]
+
+	if len(c.intQueue) > 0 {
+		e.queue = append(e.queue, c.InterruptQueue()...)
+	}
+}
+
+// record remembers the current value of the given memory word.
+func (h *History) record(addr, old Word) {
+	if h.count == 0 {
+		return
+	}
+
+	e := h.last()
+	e.mem = append(e.mem, memWrite{addr, old})
+}
+
+// saveMemory copies the current memory contents. This is called
+// before a device handler runs.
+func (h *History) saveMemory(s *Storage) {
+	if h.memory == nil {
+		h.memory = make([]Word, MemSize)
+	}
+
+	copy(h.memory, s.Mem[:])
+}
+
+// diffMemory records all words which differ from the copy
+// made by saveMemory.
+func (h *History) diffMemory(s *Storage) {
+	for i, old := range h.memory {
+		if s.Mem[i] != old {
+			h.record(Word(i), old)
+		}
+	}
+}
+
+// last returns the most recent entry.
+func (h *History) last() *undoEntry {
+	return &h.entries[(h.start+h.count-1)%len(h.entries)]
+}
+
+// undo reverts the most recent entry and removes it.
+// Returns false if there is nothing to undo.
+func (h *History) undo(c *CPU) bool {
+	if h.count == 0 {
+		return false
+	}
+
+	e := h.last()
+	h.count--
+
+	s := c.Store
+
+	// Memory writes are undone in reverse order. A word can be
+	// recorded more than once, in which case the oldest value wins.
+	for i := len(e.mem) - 1; i >= 0; i-- {
+		s.Mem[e.mem[i].addr] = e.mem[i].old
+	}
+
+	r := e.regs
+	s.A, s.B, s.C, s.X, s.Y, s.Z, s.I, s.J = r[0], r[1], r[2], r[3], r[4], r[5], r[6], r[7]
+	s.PC, s.SP, s.EX, s.IA = r[8], r[9], r[10], r[11]
+
+	c.cycles = e.cycles
+	c.queueInterrupts = e.queueInterrupts
+	c.intQueue = make(chan Word, MaxIntQueue)
+
+	for _, w := range e.queue {
+		c.intQueue <- w
+	}
+
+	return true
+}
+
+// EnableHistory starts recording an undo log of up to size instructions.
+// This allows execution to be reversed with StepBack and StepBackUntil.
+// Any existing history is discarded.
+func (c *CPU) EnableHistory(size int) {
+	if size <= 0 {
+		c.history = nil
+		return
+	}
+
+	c.history = newHistory(size)
+}
+
+// DisableHistory stops recording and discards the undo log.
+func (c *CPU) DisableHistory() { c.history = nil }
+
+// History returns the undo log. This is nil when history is disabled.
+func (c *CPU) History() *History { return c.history }
+
+// StepBack undoes up to n instructions. It returns the number of
+// instructions actually undone. This is less than n when the
+// history runs out.
+//
+// Devices are not rewound. They keep their current state and are
+// ticked again when the undone instructions are executed again.
+func (c *CPU) StepBack(n int) int {
+	if c.history == nil {
+		return 0
+	}
+
+	var i int
+
+	for ; i < n && c.history.undo(c); i++ {
+	}
+
+	c.paceStart = time.Time{}
+	return i
+}
+
+// StepBackUntil undoes instructions until the given function returns
+// true, or the history runs out. The function is called after every
+// undone instruction. It returns false when the history runs out
+// before the condition is met.
+//
+// For example, to find the instruction which last changed
+// memory word 0x1000:
+//
+//     v := c.Store.Mem[0x1000]
+//     c.StepBackUntil(func(s *Storage) bool { return s.Mem[0x1000] != v })
+//
+func (c *CPU) StepBackUntil(f func(s *Storage) bool) bool {
+	if c.history == nil {
+		return false
+	}
+
+	c.paceStart = time.Time{}
+
+	for c.history.undo(c) {
+		if f(c.Store) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,104 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package cpu
+
+import "testing"
+
+func historyProgram(c *CPU) {
+	s := c.Store
+	s.Mem[0] = Encode(EXT, IAS, 0x2a)  // IAS 9
+	s.Mem[1] = Encode(SET, 0x18, 0x25) // SET PUSH, 4
+	s.Mem[2] = Encode(SET, 0x1e, 0x18) // SET [0x1000], POP
+	s.Mem[3] = 0x1000                  //
+	s.Mem[4] = Encode(EXT, JSR, 0x29)  // JSR 8
+	s.Mem[5] = Encode(EXT, INT, 0x22)  // INT 1
+	s.Mem[6] = Encode(ADD, 0x1e, 0x22) // ADD [0x1000], 1
+	s.Mem[7] = 0x1000                  //
+	s.Mem[8] = Encode(SET, 0x1c, 0x18) // SET PC, POP
+	s.Mem[9] = Encode(EXT, RFI, 0x21)  // RFI 0
+}
+
+func TestStepBack(t *testing.T) {
+	c := New()
+	c.EnableHistory(100)
+	historyProgram(c)
+
+	var states []Storage
+	var cycles []uint64
+
+	for i := 0; i < 8; i++ {
+		states = append(states, *c.Store)
+		cycles = append(cycles, c.Cycles())
+
+		if err := c.Step(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if c.History().Len() != 8 {
+		t.Fatalf("Want 8 history entries, got %d", c.History().Len())
+	}
+
+	for i := len(states) - 1; i >= 0; i-- {
+		if c.StepBack(1) != 1 {
+			t.Fatalf("Step %d: StepBack failed", i)
+		}
+
+		if *c.Store != states[i] || c.Cycles() != cycles[i] {
+			t.Fatalf("Step %d: state differs from original", i)
+		}
+	}
+
+	if c.StepBack(1) != 0 {
+		t.Fatalf("Want empty history")
+	}
+}
+
+func TestStepBackLimit(t *testing.T) {
+	c := New()
+	c.EnableHistory(3)
+	historyProgram(c)
+
+	for i := 0; i < 5; i++ {
+		if err := c.Step(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if n := c.StepBack(10); n != 3 {
+		t.Fatalf("Want 3 undone instructions, got %d", n)
+	}
+
+	// Two instructions remain: IAS 9 and SET PUSH, 4.
+	if c.Store.PC != 2 || c.Store.IA != 9 || c.Store.SP != 0xfffe {
+		t.Fatalf("Want PC=0002 IA=0009 SP=fffe, got %04x %04x %04x",
+			c.Store.PC, c.Store.IA, c.Store.SP)
+	}
+}
+
+func TestStepBackUntil(t *testing.T) {
+	c := New()
+	c.EnableHistory(100)
+	historyProgram(c)
+
+	for i := 0; i < 8; i++ {
+		if err := c.Step(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	v := c.Store.Mem[0x1000]
+
+	if !c.StepBackUntil(func(s *Storage) bool { return s.Mem[0x1000] != v }) {
+		t.Fatalf("Want memory change to be found")
+	}
+
+	if c.Store.PC != 6 {
+		t.Fatalf("Want PC=0006, got %04x", c.Store.PC)
+	}
+
+	if c.StepBackUntil(func(s *Storage) bool { return s.Z != 0 }) {
+		t.Fatalf("Want history to run out")
+	}
+}
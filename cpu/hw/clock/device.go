@@ -5,7 +5,6 @@ package clock
 
 import (
 	"github.com/jteeuwen/dcpu/cpu"
-	"time"
 )
 
 // Known interrupt messages.
@@ -16,19 +15,23 @@ const (
 )
 
 // Clock - Generic hardware clock.
+//
+// The clock is advanced in virtual time by the CPU. It assumes the
+// CPU runs at its nominal frequency. This makes it tick at the same
+// instructions every time a program is run, regardless of how fast
+// the CPU is actually running.
 type Clock struct {
-	int    cpu.IntFunc // Interrupt function we can call on the CPU.
-	ticker *time.Ticker
-	ticks  cpu.Word
-	id     cpu.Word
+	int      cpu.IntFunc // Interrupt function we can call on the CPU.
+	interval cpu.Word    // Clock ticks 60/interval times per second. Zero is off.
+	elapsed  uint64      // Elapsed cycles since the last tick, times 60.
+	ticks    cpu.Word
+	id       cpu.Word
 }
 
 // New creates and initializes a new device instance.
 func New(f cpu.IntFunc) cpu.Device {
 	c := new(Clock)
 	c.int = f
-	c.ticker = time.NewTicker(time.Duration(1e9) / 60)
-	go c.poll()
 	return c
 }
 
@@ -39,14 +42,10 @@ func (c *Clock) Revision() uint16     { return 0x1 }
 func (c *Clock) Handler(s *cpu.Storage) {
 	switch s.A {
 	case SetInterval:
-		c.ticker.Stop()
+		c.interval = s.B
+		c.elapsed = 0
 		c.ticks = 0
 
-		if s.B > 0 {
-			c.ticker = time.NewTicker(
-				time.Duration(1e9) / (60 / time.Duration(s.B)))
-		}
-
 	case GetTicks:
 		s.C = c.ticks
 
@@ -55,16 +54,24 @@ func (c *Clock) Handler(s *cpu.Storage) {
 	}
 }
 
-// poll waits for ticks and optionally sends interrupt messages.
-func (c *Clock) poll() {
-	for {
-		select {
-		case <-c.ticker.C:
-			c.ticks++
+// Tick advances the clock by the given number of cycles and
+// optionally sends interrupt messages.
+func (c *Clock) Tick(cycles cpu.Word) {
+	if c.interval == 0 {
+		return
+	}
+
+	// A tick takes interval/60 seconds. Scale everything by 60
+	// to keep the arithmetic in integers.
+	period := uint64(c.interval) * cpu.Frequency
+	c.elapsed += uint64(cycles) * 60
+
+	for c.elapsed >= period {
+		c.elapsed -= period
+		c.ticks++
 
-			if c.id > 0 {
-				c.int(c.id)
-			}
+		if c.id > 0 {
+			c.int(c.id)
 		}
 	}
 }
@@ -0,0 +1,34 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package clock
+
+import (
+	"github.com/jteeuwen/dcpu/cpu"
+	"testing"
+)
+
+func TestTick(t *testing.T) {
+	var ints int
+	var s cpu.Storage
+
+	c := New(func(cpu.Word) { ints++ }).(*Clock)
+
+	s.A, s.B = SetInterruptId, 1
+	c.Handler(&s)
+
+	s.A, s.B = SetInterval, 1
+	c.Handler(&s)
+
+	// One second at 60 ticks per second.
+	for i := 0; i < cpu.Frequency; i++ {
+		c.Tick(1)
+	}
+
+	s.A = GetTicks
+	c.Handler(&s)
+
+	if s.C != 60 || ints != 60 {
+		t.Fatalf("Want 60 ticks and interrupts, got %d and %d", s.C, ints)
+	}
+}
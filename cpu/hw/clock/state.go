@@ -0,0 +1,40 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package clock
+
+import (
+	"encoding/binary"
+	"github.com/jteeuwen/dcpu/cpu"
+	"io"
+)
+
+// state holds the device state as it is stored in a snapshot.
+type state struct {
+	Interval cpu.Word
+	Elapsed  uint64
+	Ticks    cpu.Word
+	Id       cpu.Word
+}
+
+// SaveState writes the device state.
+func (c *Clock) SaveState(w io.Writer) error {
+	return binary.Write(w, binary.BigEndian, &state{
+		c.interval, c.elapsed, c.ticks, c.id,
+	})
+}
+
+// LoadState reads the device state.
+func (c *Clock) LoadState(r io.Reader, s *cpu.Storage) (err error) {
+	var st state
+
+	if err = binary.Read(r, binary.BigEndian, &st); err != nil {
+		return
+	}
+
+	c.interval = st.Interval
+	c.elapsed = st.Elapsed
+	c.ticks = st.Ticks
+	c.id = st.Id
+	return
+}
@@ -3,6 +3,8 @@
 
 package hmd2043
 
+import "github.com/jteeuwen/dcpu/cpu"
+
 // Known interrupt messages.
 const (
 	QueryMediaPresent = iota
@@ -16,6 +18,10 @@ const (
 	QueryMediaQuality = 0xffff
 )
 
+// Number of cycles it takes to read or write a single sector in
+// non-blocking mode. This is 11 ms at the nominal CPU frequency.
+const SectorCycles = 11 * cpu.Frequency / 1000
+
 // Known error codes
 const (
 	ErrorNone = iota
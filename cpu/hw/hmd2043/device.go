@@ -23,6 +23,16 @@ type HMD2043 struct {
 	flags   cpu.Word    // Device flags.
 	lastint cpu.Word    // Last interrupt type we raised.
 	busy    bool        // Are we in non-blocking operation?
+	pending *operation  // Non-blocking operation in progress.
+}
+
+// operation describes a non-blocking read or write.
+type operation struct {
+	write  bool       // Is this a write operation?
+	sector cpu.Word   // First sector.
+	addr   cpu.Word   // Memory address of buffer.
+	buffer []cpu.Word // Memory to read into, or write from.
+	cycles uint64     // Remaining cycles until the operation completes.
 }
 
 // New creates and initializes a new device instance.
@@ -143,13 +153,7 @@ func (h *HMD2043) Handler(s *cpu.Storage) {
 		}
 
 		s.A = ErrorNone
-
-		go func() {
-			h.media.Read(s.B, s.Mem[s.X:s.X+s.C])
-			h.busy = false
-			h.lastint = TypeReadComplete
-			h.int(h.id)
-		}()
+		h.start(false, s.B, s.X, s.Mem[s.X:s.X+s.C])
 
 	case WriteSectors:
 		if h.media == nil {
@@ -173,13 +177,7 @@ func (h *HMD2043) Handler(s *cpu.Storage) {
 		}
 
 		s.A = ErrorNone
-
-		go func() {
-			h.media.Write(s.B, s.Mem[s.X:s.X+s.C])
-			h.busy = false
-			h.lastint = TypeWriteComplete
-			h.int(h.id)
-		}()
+		h.start(true, s.B, s.X, s.Mem[s.X:s.X+s.C])
 
 	case QueryMediaQuality:
 		if h.media == nil {
@@ -198,6 +196,54 @@ func (h *HMD2043) Handler(s *cpu.Storage) {
 	}
 }
 
+// start schedules a non-blocking operation. It completes once enough
+// cycles have passed to transfer all sectors.
+func (h *HMD2043) start(write bool, sector, addr cpu.Word, buffer []cpu.Word) {
+	sectors := uint64(len(buffer)) / uint64(h.media.SectorSize())
+	if sectors == 0 {
+		sectors = 1
+	}
+
+	h.pending = &operation{
+		write:  write,
+		sector: sector,
+		addr:   addr,
+		buffer: buffer,
+		cycles: sectors * SectorCycles,
+	}
+}
+
+// Tick advances a pending non-blocking operation by the given number
+// of cycles. The data is transferred all at once, when it completes.
+func (h *HMD2043) Tick(cycles cpu.Word) {
+	op := h.pending
+	if op == nil {
+		return
+	}
+
+	if op.cycles > uint64(cycles) {
+		op.cycles -= uint64(cycles)
+		return
+	}
+
+	h.pending = nil
+	h.busy = false
+
+	if h.media == nil {
+		return
+	}
+
+	if op.write {
+		h.media.Write(op.sector, op.buffer)
+		h.lastint = TypeWriteComplete
+	} else {
+		h.media.Read(op.sector, op.buffer)
+		h.lastint = TypeReadComplete
+	}
+
+	h.int(h.id)
+}
+
 // Returns true if the given media is supported by our drive.
 //
 // TODO: Find some metric to determine if the media is OK or not.
@@ -0,0 +1,89 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package hmd2043
+
+import (
+	"bytes"
+	"encoding/binary"
+	"github.com/jteeuwen/dcpu/cpu"
+	"testing"
+)
+
+// testMedia is an in-memory disk with sectors of a single word.
+type testMedia []cpu.Word
+
+func (m testMedia) SectorSize() cpu.Word  { return 1 }
+func (m testMedia) SectorCount() cpu.Word { return cpu.Word(len(m)) }
+func (m testMedia) WriteLocked() bool     { return false }
+
+func (m testMedia) Read(sector cpu.Word, buf []cpu.Word) error {
+	copy(buf, m[sector:])
+	return nil
+}
+
+func (m testMedia) Write(sector cpu.Word, buf []cpu.Word) error {
+	copy(m[sector:], buf)
+	return nil
+}
+
+func TestNonBlockingRead(t *testing.T) {
+	var ints []cpu.Word
+	var s cpu.Storage
+
+	h := New(func(w cpu.Word) { ints = append(ints, w) }).(*HMD2043)
+	h.Insert(testMedia{1, 2, 3, 4})
+
+	s.A, s.B = SetInterruptId, 0x55
+	h.Handler(&s)
+
+	s.A, s.B = UpdateDeviceFlags, NonBlocking
+	h.Handler(&s)
+
+	s.A, s.B, s.C, s.X = ReadSectors, 1, 2, 0x100
+	h.Handler(&s)
+
+	if s.A != ErrorNone || !h.Busy() {
+		t.Fatalf("Want pending operation, got error %d", s.A)
+	}
+
+	h.Tick(2*SectorCycles - 1)
+
+	if len(ints) != 0 || s.Mem[0x100] != 0 {
+		t.Fatalf("Operation completed too early.")
+	}
+
+	h.Tick(1)
+
+	if len(ints) != 1 || ints[0] != 0x55 || h.Busy() {
+		t.Fatalf("Want a single completion interrupt, got %v", ints)
+	}
+
+	if s.Mem[0x100] != 2 || s.Mem[0x101] != 3 {
+		t.Fatalf("Want data 0002 0003, got %04x %04x", s.Mem[0x100], s.Mem[0x101])
+	}
+
+	s.A = QueryInterruptType
+	h.Handler(&s)
+
+	if s.B != TypeReadComplete {
+		t.Fatalf("Want interrupt type %d, got %d", TypeReadComplete, s.B)
+	}
+}
+
+// A snapshot with a buffer past the end of memory must be rejected.
+func TestLoadStateOutOfRange(t *testing.T) {
+	var buf bytes.Buffer
+	var s cpu.Storage
+
+	st := state{Pending: true, Addr: 0xff00, Size: 0x200}
+	if err := binary.Write(&buf, binary.BigEndian, &st); err != nil {
+		t.Fatal(err)
+	}
+
+	h := New(func(cpu.Word) {}).(*HMD2043)
+
+	if err := h.LoadState(&buf, &s); err == nil {
+		t.Fatal("Expected an error for a buffer past the end of memory.")
+	}
+}
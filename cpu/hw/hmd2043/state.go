@@ -0,0 +1,82 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package hmd2043
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/jteeuwen/dcpu/cpu"
+	"io"
+)
+
+// state holds the device state as it is stored in a snapshot.
+// Inserted media is not part of it; the host is expected to
+// insert the same media again after a restore.
+type state struct {
+	Id      cpu.Word
+	Flags   cpu.Word
+	LastInt cpu.Word
+	Busy    bool
+	Pending bool
+	Write   bool
+	Sector  cpu.Word
+	Addr    cpu.Word
+	Size    cpu.Word
+	Cycles  uint64
+}
+
+// SaveState writes the device state.
+func (h *HMD2043) SaveState(w io.Writer) error {
+	st := state{
+		Id:      h.id,
+		Flags:   h.flags,
+		LastInt: h.lastint,
+		Busy:    h.busy,
+	}
+
+	if op := h.pending; op != nil {
+		st.Pending = true
+		st.Write = op.write
+		st.Sector = op.sector
+		st.Addr = op.addr
+		st.Size = cpu.Word(len(op.buffer))
+		st.Cycles = op.cycles
+	}
+
+	return binary.Write(w, binary.BigEndian, &st)
+}
+
+// LoadState reads the device state. A pending operation is
+// mapped back onto the given memory.
+func (h *HMD2043) LoadState(r io.Reader, s *cpu.Storage) (err error) {
+	var st state
+
+	if err = binary.Read(r, binary.BigEndian, &st); err != nil {
+		return
+	}
+
+	h.id = st.Id
+	h.flags = st.Flags
+	h.lastint = st.LastInt
+	h.busy = st.Busy
+	h.pending = nil
+
+	if st.Pending {
+		if int(st.Addr)+int(st.Size) > len(s.Mem) {
+			return errors.New(fmt.Sprintf(
+				"hmd2043: Buffer of %d words at %04x exceeds memory.", st.Size, st.Addr))
+		}
+
+		h.pending = &operation{
+			write:  st.Write,
+			sector: st.Sector,
+			addr:   st.Addr,
+			buffer: s.Mem[st.Addr : int(st.Addr)+int(st.Size)],
+			cycles: st.Cycles,
+		}
+	}
+
+	return
+}
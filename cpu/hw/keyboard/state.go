@@ -0,0 +1,60 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package keyboard
+
+import (
+	"encoding/binary"
+	"github.com/jteeuwen/dcpu/cpu"
+	"io"
+)
+
+// state holds the fixed size part of the device state, as it is stored
+// in a snapshot. It is followed by the key buffer and key states.
+type state struct {
+	Id      cpu.Word
+	BufSize uint16
+	KeySize uint16
+}
+
+// SaveState writes the device state.
+func (k *Keyboard) SaveState(w io.Writer) (err error) {
+	be := binary.BigEndian
+
+	err = binary.Write(w, be, &state{k.id, uint16(len(k.buf)), uint16(len(k.keys))})
+	if err != nil {
+		return
+	}
+
+	if err = binary.Write(w, be, k.buf); err != nil {
+		return
+	}
+
+	return binary.Write(w, be, k.keys)
+}
+
+// LoadState reads the device state.
+func (k *Keyboard) LoadState(r io.Reader, s *cpu.Storage) (err error) {
+	var st state
+
+	be := binary.BigEndian
+
+	if err = binary.Read(r, be, &st); err != nil {
+		return
+	}
+
+	buf := make([]cpu.Word, st.BufSize)
+	if err = binary.Read(r, be, buf); err != nil {
+		return
+	}
+
+	keys := make([]uint8, st.KeySize)
+	if err = binary.Read(r, be, keys); err != nil {
+		return
+	}
+
+	k.id = st.Id
+	k.buf = buf
+	k.keys = keys
+	return
+}
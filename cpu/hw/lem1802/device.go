@@ -31,6 +31,8 @@ type Lem1802 struct {
 	font    []cpu.Word
 	palette []cpu.Word
 	border  cpu.Word
+	cost    cpu.Word // Additional cycles taken by the last interrupt.
+	mapping state    // Memory addresses of the mapped buffers.
 }
 
 // New creates and initializes a new device instance.
@@ -47,9 +49,17 @@ func (d *Lem1802) Manufacturer() uint32 { return 0x1c6c8b36 }
 func (d *Lem1802) Id() uint32           { return 0x7349f615 }
 func (d *Lem1802) Revision() uint16     { return 0x1802 }
 
+// HandlerCost returns the number of cycles the last interrupt
+// halted the CPU for.
+func (d *Lem1802) HandlerCost() cpu.Word { return d.cost }
+
 func (d *Lem1802) Handler(s *cpu.Storage) {
+	d.cost = 0
+
 	switch s.A {
 	case MemMapScreen:
+		d.mapping.Screen = s.B
+
 		if s.B == 0 {
 			d.buffer = nil
 		} else {
@@ -57,6 +67,8 @@ func (d *Lem1802) Handler(s *cpu.Storage) {
 		}
 
 	case MemMapFont:
+		d.mapping.Font = s.B
+
 		if s.B == 0 {
 			d.font = DefaultFont()
 		} else {
@@ -64,6 +76,8 @@ func (d *Lem1802) Handler(s *cpu.Storage) {
 		}
 
 	case MemMapPalette:
+		d.mapping.Palette = s.B
+
 		if s.B == 0 {
 			d.palette = DefaultPalette
 		} else {
@@ -75,9 +89,11 @@ func (d *Lem1802) Handler(s *cpu.Storage) {
 
 	case MemDumpFont:
 		copy(s.Mem[s.B:], DefaultFont())
+		d.cost = 256
 
 	case MemDumpPalette:
 		copy(s.Mem[s.B:], DefaultPalette)
+		d.cost = 16
 
 	}
 }
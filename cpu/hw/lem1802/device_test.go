@@ -4,8 +4,33 @@
 package lem1802
 
 import (
+	"bytes"
+	"encoding/binary"
+	"github.com/jteeuwen/dcpu/cpu"
 	"testing"
 )
 
 func Test(t *testing.T) {
 }
+
+// Snapshots with mappings past the end of memory must be rejected.
+func TestLoadStateOutOfRange(t *testing.T) {
+	for _, st := range []state{
+		{Screen: 0xff00},
+		{Font: 0xffff},
+		{Palette: 0xfff8},
+	} {
+		var buf bytes.Buffer
+		var s cpu.Storage
+
+		if err := binary.Write(&buf, binary.BigEndian, &st); err != nil {
+			t.Fatal(err)
+		}
+
+		d := New(func(cpu.Word) {}).(*Lem1802)
+
+		if err := d.LoadState(&buf, &s); err == nil {
+			t.Fatalf("Expected an error for mapping %+v.", st)
+		}
+	}
+}
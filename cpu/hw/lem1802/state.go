@@ -0,0 +1,75 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package lem1802
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/jteeuwen/dcpu/cpu"
+	"io"
+)
+
+// state holds the device state as it is stored in a snapshot.
+// Memory mappings are stored by address. Zero means the default
+// font or palette is used, or that the screen is disconnected.
+type state struct {
+	Screen  cpu.Word
+	Font    cpu.Word
+	Palette cpu.Word
+	Border  cpu.Word
+}
+
+// SaveState writes the device state.
+func (d *Lem1802) SaveState(w io.Writer) error {
+	st := d.mapping
+	st.Border = d.border
+	return binary.Write(w, binary.BigEndian, &st)
+}
+
+// LoadState reads the device state and restores the memory mappings.
+func (d *Lem1802) LoadState(r io.Reader, s *cpu.Storage) (err error) {
+	var st state
+
+	if err = binary.Read(r, binary.BigEndian, &st); err != nil {
+		return
+	}
+
+	d.mapping = st
+	d.border = st.Border
+	d.buffer = nil
+	d.font = DefaultFont()
+	d.palette = DefaultPalette
+
+	if st.Screen != 0 {
+		if d.buffer, err = mapMemory(s, st.Screen, ScreenSize); err != nil {
+			return
+		}
+	}
+
+	if st.Font != 0 {
+		if d.font, err = mapMemory(s, st.Font, FontSize); err != nil {
+			return
+		}
+	}
+
+	if st.Palette != 0 {
+		if d.palette, err = mapMemory(s, st.Palette, PaletteSize); err != nil {
+			return
+		}
+	}
+
+	return
+}
+
+// mapMemory returns the given range of memory. It fails if the range
+// runs past the end of memory, which means the snapshot is corrupt.
+func mapMemory(s *cpu.Storage, addr cpu.Word, size int) ([]cpu.Word, error) {
+	if int(addr)+size > len(s.Mem) {
+		return nil, errors.New(fmt.Sprintf(
+			"lem1802: Mapping of %d words at %04x exceeds memory.", size, addr))
+	}
+
+	return s.Mem[addr : int(addr)+size], nil
+}
@@ -44,7 +44,7 @@ func (spc *SPC2000) Handler(s *cpu.Storage) {
 		// It then notes that TRIGGER_DEVICE should actually trigger
 		// the device when C is 0.
 		if s.C == 1 {
-			go spc.trigger()
+			spc.trigger()
 		}
 
 	case SetSkipUnit:
@@ -0,0 +1,34 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package spc2000
+
+import (
+	"encoding/binary"
+	"github.com/jteeuwen/dcpu/cpu"
+	"io"
+)
+
+// state holds the device state as it is stored in a snapshot.
+type state struct {
+	Time uint64
+	Unit cpu.Word
+}
+
+// SaveState writes the device state.
+func (spc *SPC2000) SaveState(w io.Writer) error {
+	return binary.Write(w, binary.BigEndian, &state{spc.time, spc.unit})
+}
+
+// LoadState reads the device state.
+func (spc *SPC2000) LoadState(r io.Reader, s *cpu.Storage) (err error) {
+	var st state
+
+	if err = binary.Read(r, binary.BigEndian, &st); err != nil {
+		return
+	}
+
+	spc.time = st.Time
+	spc.unit = st.Unit
+	return
+}
@@ -4,15 +4,26 @@
 // DCPU emulator package.
 package cpu
 
+import "time"
+
 // Maximum interrupt queue size.
 const MaxIntQueue = 0xff
 
+// Nominal clock frequency in Hz. Devices use this to convert between
+// cycles and time.
+const Frequency = 100000
+
+// Default duration of a clock cycle. This amounts to 100 kHz.
+const DefaultClockSpeed = time.Second / Frequency
+
 type TraceFunc func(pc, op, a, b Word, store *Storage)
 
 type InstructionFunc func(pc Word, store *Storage)
 
 type BranchSkipFunc func(pc, cost Word)
 
+type DeviceCostFunc func(pc, cost Word)
+
 // Encode encodes the given opcode and operands into an instruction.
 func Encode(a, b, c Word) Word {
 	return a | (b << 5) | (c << 10)
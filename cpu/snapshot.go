@@ -0,0 +1,281 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package cpu
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// Version of the snapshot format. This is incremented whenever
+// the format changes in an incompatible way.
+const SnapshotVersion = 1
+
+// Every snapshot starts with these bytes.
+var snapshotMagic = [4]byte{'D', 'S', 'N', 'P'}
+
+// ErrSnapshotFormat is returned when restoring from data which
+// is not a snapshot.
+var ErrSnapshotFormat = errors.New("Invalid snapshot data.")
+
+// A StatefulDevice is a device whose internal state can be saved
+// in a snapshot. Devices which do not implement this interface, are
+// restored in their current state.
+type StatefulDevice interface {
+	Device
+
+	// SaveState writes the device state.
+	SaveState(w io.Writer) error
+
+	// LoadState reads the device state as written by SaveState.
+	// It receives the CPU storage, which has already been restored.
+	// This allows devices to restore references to memory.
+	LoadState(r io.Reader, s *Storage) error
+}
+
+// snapshotHeader starts every snapshot.
+type snapshotHeader struct {
+	Magic   [4]byte
+	Version uint16
+}
+
+// snapshotState holds CPU state, besides its storage.
+type snapshotState struct {
+	Cycles          uint64
+	QueueInterrupts bool
+	QueueSize       uint16
+}
+
+// snapshotDevice precedes the state of each device.
+type snapshotDevice struct {
+	Manufacturer uint32
+	Id           uint32
+	Revision     uint16
+	Size         uint32 // Size of the device state in bytes.
+}
+
+// Snapshot writes the complete machine state. This includes memory,
+// registers, the interrupt queue and the state of all devices which
+// implement StatefulDevice.
+//
+// This should only be called in between instructions.
+func (c *CPU) Snapshot(w io.Writer) (err error) {
+	be := binary.BigEndian
+
+	hdr := snapshotHeader{snapshotMagic, SnapshotVersion}
+	if err = binary.Write(w, be, &hdr); err != nil {
+		return
+	}
+
+	if err = binary.Write(w, be, c.Store); err != nil {
+		return
+	}
+
+	queue := c.InterruptQueue()
+
+	state := snapshotState{
+		Cycles:          c.cycles,
+		QueueInterrupts: c.queueInterrupts,
+		QueueSize:       uint16(len(queue)),
+	}
+
+	if err = binary.Write(w, be, &state); err != nil {
+		return
+	}
+
+	if err = binary.Write(w, be, queue); err != nil {
+		return
+	}
+
+	if err = binary.Write(w, be, uint16(len(c.devices))); err != nil {
+		return
+	}
+
+	devices, err := c.saveDevices()
+	if err != nil {
+		return
+	}
+
+	for i, dev := range c.devices {
+		dh := snapshotDevice{
+			Manufacturer: dev.Manufacturer(),
+			Id:           dev.Id(),
+			Revision:     dev.Revision(),
+			Size:         uint32(len(devices[i])),
+		}
+
+		if err = binary.Write(w, be, &dh); err != nil {
+			return
+		}
+
+		if _, err = w.Write(devices[i]); err != nil {
+			return
+		}
+	}
+
+	return
+}
+
+// Restore reads the machine state as written by Snapshot.
+//
+// The CPU should have the same devices registered, in the same order,
+// as the CPU the snapshot was taken from.
+//
+// All of the snapshot is read and checked before anything changes. If
+// an error is returned, the CPU and its devices are left as they were.
+func (c *CPU) Restore(r io.Reader) (err error) {
+	var hdr snapshotHeader
+	var state snapshotState
+	var count uint16
+
+	be := binary.BigEndian
+
+	if err = binary.Read(r, be, &hdr); err != nil {
+		return
+	}
+
+	if hdr.Magic != snapshotMagic {
+		return ErrSnapshotFormat
+	}
+
+	if hdr.Version != SnapshotVersion {
+		return errors.New(fmt.Sprintf(
+			"Unsupported snapshot version %d. Want %d.", hdr.Version, SnapshotVersion))
+	}
+
+	store := new(Storage)
+	if err = binary.Read(r, be, store); err != nil {
+		return
+	}
+
+	if err = binary.Read(r, be, &state); err != nil {
+		return
+	}
+
+	if state.QueueSize > MaxIntQueue {
+		return ErrSnapshotFormat
+	}
+
+	queue := make([]Word, state.QueueSize)
+	if err = binary.Read(r, be, queue); err != nil {
+		return
+	}
+
+	if err = binary.Read(r, be, &count); err != nil {
+		return
+	}
+
+	if int(count) != len(c.devices) {
+		return errors.New(fmt.Sprintf(
+			"Snapshot has %d devices. Have %d.", count, len(c.devices)))
+	}
+
+	devices := make([][]byte, len(c.devices))
+
+	for i, dev := range c.devices {
+		var dh snapshotDevice
+
+		if err = binary.Read(r, be, &dh); err != nil {
+			return
+		}
+
+		if dh.Manufacturer != dev.Manufacturer() || dh.Id != dev.Id() ||
+			dh.Revision != dev.Revision() {
+			return errors.New(fmt.Sprintf(
+				"Snapshot device %d is %08x:%08x:%04x. Have %08x:%08x:%04x.",
+				i, dh.Manufacturer, dh.Id, dh.Revision,
+				dev.Manufacturer(), dev.Id(), dev.Revision()))
+		}
+
+		devices[i] = make([]byte, dh.Size)
+		if _, err = io.ReadFull(r, devices[i]); err != nil {
+			return
+		}
+	}
+
+	// Device state can only be checked by loading it. Keep the current
+	// state, so it can be put back if any of them fails.
+	current, err := c.saveDevices()
+	if err != nil {
+		return
+	}
+
+	if err = c.loadDevices(devices); err != nil {
+		c.loadDevices(current)
+		return
+	}
+
+	*c.Store = *store
+	c.cycles = state.Cycles
+	c.queueInterrupts = state.QueueInterrupts
+	c.paceStart = time.Time{}
+	c.intQueue = make(chan Word, MaxIntQueue)
+
+	if c.history != nil {
+		c.history.Clear()
+	}
+
+	for _, w := range queue {
+		c.intQueue <- w
+	}
+
+	return
+}
+
+// saveDevices returns the state of each device. It is empty for
+// devices which do not implement StatefulDevice.
+func (c *CPU) saveDevices() ([][]byte, error) {
+	list := make([][]byte, len(c.devices))
+
+	for i, dev := range c.devices {
+		var buf bytes.Buffer
+
+		if sd, ok := dev.(StatefulDevice); ok {
+			if err := sd.SaveState(&buf); err != nil {
+				return nil, err
+			}
+		}
+
+		list[i] = buf.Bytes()
+	}
+
+	return list, nil
+}
+
+// loadDevices loads the given state into each device.
+// Empty states are skipped.
+func (c *CPU) loadDevices(list [][]byte) error {
+	for i, dev := range c.devices {
+		sd, ok := dev.(StatefulDevice)
+		if !ok || len(list[i]) == 0 {
+			continue
+		}
+
+		if err := sd.LoadState(bytes.NewReader(list[i]), c.Store); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// InterruptQueue returns the contents of the interrupt queue,
+// leaving the queue itself intact.
+func (c *CPU) InterruptQueue() []Word {
+	queue := make([]Word, 0, len(c.intQueue))
+
+	for len(c.intQueue) > 0 {
+		queue = append(queue, <-c.intQueue)
+	}
+
+	for _, w := range queue {
+		c.intQueue <- w
+	}
+
+	return queue
+}
@@ -0,0 +1,164 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package cpu
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"testing"
+)
+
+// StatefulAlarmDevice is an AlarmDevice which saves its countdown.
+type StatefulAlarmDevice struct {
+	AlarmDevice
+}
+
+func NewStatefulAlarmDevice(f IntFunc) Device {
+	return &StatefulAlarmDevice{AlarmDevice{TestDevice{f}, 10}}
+}
+
+func (d *StatefulAlarmDevice) SaveState(w io.Writer) error {
+	return binary.Write(w, binary.BigEndian, d.left)
+}
+
+func (d *StatefulAlarmDevice) LoadState(r io.Reader, s *Storage) error {
+	return binary.Read(r, binary.BigEndian, &d.left)
+}
+
+// BrokenStateDevice saves state which it can not load again.
+type BrokenStateDevice struct {
+	TestDevice
+}
+
+func NewBrokenStateDevice(f IntFunc) Device {
+	return &BrokenStateDevice{TestDevice{f}}
+}
+
+func (d *BrokenStateDevice) SaveState(w io.Writer) error {
+	_, err := w.Write([]byte{0xff})
+	return err
+}
+
+func (d *BrokenStateDevice) LoadState(r io.Reader, s *Storage) error {
+	return ErrSnapshotFormat
+}
+
+func snapshotProgram(c *CPU) {
+	s := c.Store
+	s.Mem[0] = Encode(EXT, IAS, 0x25)  // IAS 4
+	s.Mem[1] = Encode(ADD, 1, 0x22)    // ADD B, 1
+	s.Mem[2] = Encode(SET, 0x1c, 0x22) // SET PC, 1
+	s.Mem[3] = _exit                   // EXIT
+	s.Mem[4] = Encode(SET, 0x1c, 0x24) // SET PC, 3
+}
+
+func TestSnapshot(t *testing.T) {
+	var buf bytes.Buffer
+
+	a := New()
+	a.ClockSpeed = 0
+	a.RegisterDevice(NewStatefulAlarmDevice)
+	snapshotProgram(a)
+
+	// Stop halfway to the alarm.
+	if err := a.RunCycles(4); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := a.Snapshot(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	b := New()
+	b.ClockSpeed = 0
+	b.RegisterDevice(NewStatefulAlarmDevice)
+
+	if err := b.Restore(bytes.NewReader(buf.Bytes())); err != nil {
+		t.Fatal(err)
+	}
+
+	if b.Store.PC != a.Store.PC || b.Store.B != a.Store.B ||
+		b.Store.IA != a.Store.IA || b.Cycles() != a.Cycles() {
+		t.Fatalf("Restored state differs from original")
+	}
+
+	if err := a.Run(0); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := b.Run(0); err != nil {
+		t.Fatal(err)
+	}
+
+	if *a.Store != *b.Store || a.Cycles() != b.Cycles() {
+		t.Fatalf("Want identical runs, got B=%04x and B=%04x",
+			a.Store.B, b.Store.B)
+	}
+}
+
+func TestSnapshotDevices(t *testing.T) {
+	var buf bytes.Buffer
+
+	a := New()
+	a.RegisterDevice(NewStatefulAlarmDevice)
+
+	if err := a.Snapshot(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	b := New()
+
+	if err := b.Restore(bytes.NewReader(buf.Bytes())); err == nil {
+		t.Fatalf("Want error for missing device")
+	}
+
+	if err := b.Restore(bytes.NewReader([]byte("nope, nope"))); err != ErrSnapshotFormat {
+		t.Fatalf("Want ErrSnapshotFormat, got %v", err)
+	}
+}
+
+func TestSnapshotRestoreFailed(t *testing.T) {
+	var buf bytes.Buffer
+
+	a := New()
+	a.ClockSpeed = 0
+	a.RegisterDevice(NewStatefulAlarmDevice)
+	a.RegisterDevice(NewBrokenStateDevice)
+	snapshotProgram(a)
+
+	if err := a.RunCycles(4); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := a.Snapshot(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	b := New()
+	b.ClockSpeed = 0
+	b.RegisterDevice(NewStatefulAlarmDevice)
+	b.RegisterDevice(NewBrokenStateDevice)
+	b.Store.B = 0x1234
+
+	store := *b.Store
+	cycles := b.Cycles()
+	left := b.devices[0].(*StatefulAlarmDevice).left
+
+	if a.devices[0].(*StatefulAlarmDevice).left == left {
+		t.Fatalf("Want different device states")
+	}
+
+	if err := b.Restore(bytes.NewReader(buf.Bytes())); err == nil {
+		t.Fatalf("Want error for broken device state")
+	}
+
+	if *b.Store != store || b.Cycles() != cycles {
+		t.Fatalf("CPU state changed by failed restore")
+	}
+
+	if b.devices[0].(*StatefulAlarmDevice).left != left {
+		t.Fatalf("Device state changed by failed restore")
+	}
+}
@@ -0,0 +1,40 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package main
+
+import (
+	"github.com/jteeuwen/dcpu/parser"
+	"strings"
+)
+
+// defineList holds constants given with the -D flag.
+// The flag can be specified more than once.
+type defineList []string
+
+func (d *defineList) String() string { return strings.Join(*d, " ") }
+
+func (d *defineList) Set(v string) error {
+	*d = append(*d, v)
+	return nil
+}
+
+// apply adds the defines to the given AST. They are of the
+// form `NAME` or `NAME=value`.
+func (d defineList) apply(ast *parser.AST) (err error) {
+	for _, v := range d {
+		var name, value string
+
+		if i := strings.Index(v, "="); i > -1 {
+			name, value = v[:i], v[i+1:]
+		} else {
+			name = v
+		}
+
+		if err = ast.Define(strings.TrimSpace(name), value); err != nil {
+			return
+		}
+	}
+
+	return
+}
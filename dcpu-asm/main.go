@@ -26,6 +26,10 @@ var (
 	debugfile    = flag.String("d", "", "")
 	littleendian = flag.Bool("l", false, "")
 	optimize     = flag.Bool("p", false, "")
+	object       = flag.Bool("c", false, "")
+	relative     = flag.Bool("r", false, "")
+	maxerrors    = flag.Int("maxerrors", parser.DefaultMaxErrors, "")
+	defines      defineList
 )
 
 func main() {
@@ -35,10 +39,26 @@ func main() {
 	// This takes care of resolving includes and identifying
 	// unresolved label references.
 	var ast parser.AST
+	var err error
+
+	ast.MaxErrors = *maxerrors
+
+	if err = defines.apply(&ast); err != nil {
+		fmt.Fprintf(os.Stderr, "Defines: %v\n", err)
+		os.Exit(1)
+	}
+
+	// Objects only contain the input file. References to
+	// other files are resolved by the linker.
+	if *object {
+		err = util.ReadSourceFile(&ast, infile)
+	} else {
+		err = util.ReadSource(&ast, infile, includes)
+	}
 
-	err := util.ReadSource(&ast, infile, includes)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Source reader: %v\n", err)
+		printErrors("Source reader", err)
+		checkSource(&ast, err)
 		os.Exit(1)
 	}
 
@@ -59,10 +79,20 @@ func main() {
 		os.Exit(0)
 	}
 
+	if *object {
+		if err = writeObject(&ast, *outfile); err != nil {
+			printErrors("Assembler", err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
 	// Assemble program.
-	program, dbg, err := asm.Assemble(&ast)
+	program, dbg, err := asm.AssembleWith(&ast, asm.Options{
+		RelativeJumps: *relative,
+	})
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Assembler: %v\n", err)
+		printErrors("Assembler", err)
 		os.Exit(1)
 	}
 
@@ -86,9 +116,95 @@ func main() {
 	}
 }
 
+// printErrors writes the given error to stderr. An error list
+// is written with one error per line.
+func printErrors(prefix string, err error) {
+	list, ok := err.(parser.ErrorList)
+	if !ok {
+		list = parser.ErrorList{err}
+	}
+
+	for _, e := range list {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", prefix, e)
+	}
+}
+
+// checkSource assembles a program which the source reader failed to
+// read completely. The assembler finds errors of its own, which are
+// reported along with the source reader's, so they can all be fixed in
+// one go. The output itself is discarded.
+//
+// Errors found at the same position as one of the given ones, are
+// assumed to be duplicates and left out.
+func checkSource(ast *parser.AST, err error) {
+	list, ok := err.(parser.ErrorList)
+	if !ok {
+		list = parser.ErrorList{err}
+	}
+
+	max := ast.MaxErrors
+	if max <= 0 {
+		max = parser.DefaultMaxErrors
+	}
+
+	if ast.Root == nil || len(list) >= max {
+		return
+	}
+
+	seen := make(map[string]bool)
+	for _, e := range list {
+		if pos, ok := errorPos(e); ok {
+			seen[pos] = true
+		}
+	}
+
+	ast.MaxErrors = max - len(list)
+
+	if *object {
+		_, err = asm.AssembleObject(ast)
+	} else {
+		_, _, err = asm.AssembleWith(ast, asm.Options{
+			RelativeJumps: *relative,
+		})
+	}
+
+	if err == nil {
+		return
+	}
+
+	list, ok = err.(parser.ErrorList)
+	if !ok {
+		list = parser.ErrorList{err}
+	}
+
+	var out parser.ErrorList
+	for _, e := range list {
+		if pos, ok := errorPos(e); !ok || !seen[pos] {
+			out = append(out, e)
+		}
+	}
+
+	if len(out) > 0 {
+		printErrors("Assembler", out)
+	}
+}
+
+// errorPos returns the source position of the given error,
+// if it has one.
+func errorPos(err error) (string, bool) {
+	switch tt := err.(type) {
+	case *parser.ParseError:
+		return fmt.Sprintf("%s:%d:%d", tt.File, tt.Line, tt.Col), true
+	case *asm.BuildError:
+		return fmt.Sprintf("%s:%d:%d", tt.File, tt.Line, tt.Col), true
+	}
+	return "", false
+}
+
 func parseArgs() {
 	include := flag.String("i", "", "Colon separated list of additional include paths.")
 	version := flag.Bool("v", false, "Display version information.")
+	flag.Var(&defines, "D", "")
 
 	CreatePreProcessorFlags()
 	CreatePostProcessorFlags()
@@ -170,16 +286,25 @@ func usage() {
 	fmt.Fprintf(os.Stdout, "[Misc options]\n")
 	fmt.Fprintf(os.Stdout, " -o <file> : Path to output. Defaults to stdout.\n")
 	fmt.Fprintf(os.Stdout, " -d <file> : Path to debug symbol file.\n")
+	fmt.Fprintf(os.Stdout, " -D <def>  : Define a constant as NAME or NAME=value, for use in\n"+
+		"             conditional assembly. The value defaults to 1.\n"+
+		"             Can be specified more than once.\n")
+	fmt.Fprintf(os.Stdout, " -maxerrors <n> : Maximum number of errors to report. Defaults to %d.\n",
+		parser.DefaultMaxErrors)
 	fmt.Fprintf(os.Stdout, "        -a : Dump pre-processed AST to the output.\n")
 	fmt.Fprintf(os.Stdout, "        -s : Dump pre-processed source code to the output.\n")
 	fmt.Fprintf(os.Stdout, "        -l : Generate Little Endian binary output. Defaults to Big Endian.\n")
+	fmt.Fprintf(os.Stdout, "        -c : Generate a relocatable object file, to be linked with dcpu-ld.\n")
+	fmt.Fprintf(os.Stdout, "        -r : Encode jumps to nearby labels as relative jumps, where\n"+
+		"             this saves a word. These cost an extra cycle and clear EX.\n")
 	fmt.Fprintf(os.Stdout, "        -p : Force all pre- and post-processors which are marked\n"+
 		"             as optimizations to run. No need to manually specify them.\n")
 	fmt.Fprintf(os.Stdout, "        -h : Display this help.\n")
 	fmt.Fprintf(os.Stdout, "        -v : Display version information.\n")
 
 	fmt.Fprintf(os.Stdout, "\n  The -a and -s options are mutually exclusive.\n")
-	fmt.Fprintf(os.Stdout, "  -d and -l have no effect in combination with -a or -s.\n")
+	fmt.Fprintf(os.Stdout, "  -d, -l and -r have no effect in combination with -a, -s or -c.\n")
+	fmt.Fprintf(os.Stdout, "  Post-processors do not run on object files.\n")
 
 	if len(preprocessors) > 0 {
 		fmt.Fprintf(os.Stdout, "\nPre-processors operate on the generated AST.\n")
@@ -0,0 +1,36 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package main
+
+import (
+	"github.com/jteeuwen/dcpu/asm"
+	"github.com/jteeuwen/dcpu/parser"
+	"io"
+	"os"
+)
+
+// writeObject assembles the given AST into a relocatable object
+// and writes it to the given file, or stdout.
+func writeObject(ast *parser.AST, file string) (err error) {
+	obj, err := asm.AssembleObject(ast)
+	if err != nil {
+		return
+	}
+
+	var w io.Writer
+
+	if len(file) == 0 {
+		w = os.Stdout
+	} else {
+		fd, err := os.Create(file)
+		if err != nil {
+			return err
+		}
+
+		defer fd.Close()
+		w = fd
+	}
+
+	return asm.WriteObject(w, obj)
+}
@@ -0,0 +1,265 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package main
+
+import (
+	"github.com/jteeuwen/dcpu/cpu"
+	"github.com/jteeuwen/dcpu/parser"
+)
+
+// This file holds the plumbing shared by the peephole optimizers.
+// Each of them rewrites short sequences of instructions into cheaper
+// ones, without changing what the program does.
+
+// Instructions which always overwrite EX.
+var exWriters = map[string]bool{
+	"add": true, "sub": true, "mul": true, "mli": true, "div": true,
+	"dvi": true, "shr": true, "asr": true, "shl": true,
+}
+
+// peephole applies the given rule to the top level code in the AST
+// and to the body of each function.
+//
+// The rules change the size of the code they touch. Code which jumps
+// relative to PC depends on the exact size of the instructions it
+// jumps over, so lists holding such code are left alone.
+func peephole(ast *parser.AST, rule func([]parser.Node) []parser.Node) {
+	list := ast.Root.Children()
+
+	if !hasRelativePC(list) {
+		list = rule(list)
+	}
+
+	for _, n := range list {
+		if f, ok := n.(*parser.Function); ok {
+			nodes := f.Children()
+
+			if !hasRelativePC(nodes[1:]) {
+				f.SetChildren(append(nodes[:1:1], rule(nodes[1:])...))
+			}
+		}
+	}
+
+	ast.Root.SetChildren(list)
+}
+
+// hasRelativePC returns true if any instruction in the given list
+// uses the value of PC. For example: `ADD PC, 2` or `SET A, PC`.
+// An absolute jump like `SET PC, label` does not count.
+func hasRelativePC(list []parser.Node) bool {
+	for _, n := range list {
+		instr, name := instruction(n)
+		if instr == nil || !references(instr, "pc") {
+			continue
+		}
+
+		args := operands(instr)
+		if name == "set" && len(args) == 2 && operandName(args[0]) == "pc" &&
+			!references(args[1], "pc") {
+			continue
+		}
+
+		return true
+	}
+
+	return false
+}
+
+// instruction returns the given node as an instruction, along with
+// its name. It returns nil if the node is not an instruction.
+func instruction(n parser.Node) (*parser.Instruction, string) {
+	instr, ok := n.(*parser.Instruction)
+	if !ok {
+		return nil, ""
+	}
+
+	return instr, instr.Children()[0].(*parser.Name).Data
+}
+
+// operands returns the operands of the given instruction. A trailing
+// comment which ended up as an expression of its own is left out.
+func operands(instr *parser.Instruction) []*parser.Expression {
+	var list []*parser.Expression
+
+	for _, n := range instr.Children()[1:] {
+		expr := n.(*parser.Expression)
+
+		if len(parser.StripComments(expr.Children())) > 0 {
+			list = append(list, expr)
+		}
+	}
+
+	return list
+}
+
+// operandName returns the name making up the given operand. It returns
+// an empty string if the operand is anything other than a single name.
+func operandName(expr *parser.Expression) string {
+	list := parser.StripComments(expr.Children())
+
+	if len(list) == 1 {
+		if name, ok := list[0].(*parser.Name); ok {
+			return name.Data
+		}
+	}
+
+	return ""
+}
+
+// operandValue returns the value of the given operand, if it is a
+// number or a negated number.
+func operandValue(expr *parser.Expression) (cpu.Word, bool) {
+	list := parser.StripComments(expr.Children())
+	neg := false
+
+	if len(list) == 2 {
+		if op, ok := list[0].(*parser.Operator); ok && op.Data == "-" {
+			list, neg = list[1:], true
+		}
+	}
+
+	if len(list) != 1 {
+		return 0, false
+	}
+
+	num, ok := list[0].(parser.NumericNode)
+	if !ok {
+		return 0, false
+	}
+
+	word, err := num.Parse()
+	if err != nil {
+		return 0, false
+	}
+
+	if neg {
+		word = -word
+	}
+
+	return word, true
+}
+
+// setOperand replaces the contents of the given operand with the
+// given nodes. Comments in the operand are kept.
+func setOperand(expr *parser.Expression, nodes ...parser.Node) {
+	for _, n := range expr.Children() {
+		if _, ok := n.(*parser.Comment); ok {
+			nodes = append(nodes, n)
+		}
+	}
+
+	expr.SetChildren(nodes)
+}
+
+// isShort returns true if the given value can be encoded as a short
+// literal, without an extra word.
+func isShort(v cpu.Word) bool {
+	return v == 0xffff || v <= 0x1e
+}
+
+// isRegister returns true if the given operand names a register which
+// can be read and written without side effects.
+func isRegister(name string) bool {
+	switch name {
+	case "a", "b", "c", "x", "y", "z", "i", "j", "sp", "ex":
+		return true
+	}
+	return false
+}
+
+// next returns the index of the first node after i which is not
+// a comment. It returns len(list) if there is none.
+func next(list []parser.Node, i int) int {
+	for i++; i < len(list); i++ {
+		if _, ok := list[i].(*parser.Comment); !ok {
+			break
+		}
+	}
+
+	return i
+}
+
+// isConditional returns true if the instruction at index i is
+// preceded by a branch. Labels do not change this; the branch
+// skips whatever instruction comes next.
+func isConditional(list []parser.Node, i int) bool {
+	for i--; i >= 0; i-- {
+		switch tt := list[i].(type) {
+		case *parser.Comment, *parser.Label:
+			continue
+
+		case *parser.Instruction:
+			_, name := instruction(tt)
+			return parser.IsBranch(name)
+		}
+
+		break
+	}
+
+	return false
+}
+
+// isExUnused returns true if the value of EX, as left by the instruction
+// at index i, is overwritten before anything can read it.
+func isExUnused(list []parser.Node, i int) bool {
+	for i = next(list, i); i < len(list); i = next(list, i) {
+		instr, name := instruction(list[i])
+		if instr == nil {
+			return false
+		}
+
+		args := operands(instr)
+
+		// A plain write to EX.
+		if name == "set" && len(args) == 2 && operandName(args[0]) == "ex" &&
+			!references(args[1], "ex") && !isConditional(list, i) {
+			return true
+		}
+
+		if references(instr, "ex") {
+			return false
+		}
+
+		switch name {
+		case "adx", "sbx", "jsr", "int", "iaq", "hwi", "rfi", "dat",
+			"return", "exit", "panic":
+			return false
+		}
+
+		if len(args) > 0 && operandName(args[0]) == "pc" {
+			return false
+		}
+
+		if exWriters[name] && !isConditional(list, i) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// references returns true if the given node refers to the given name.
+func references(n parser.NodeCollection, name string) bool {
+	for _, v := range n.Children() {
+		switch tt := v.(type) {
+		case *parser.Name:
+			if tt.Data == name {
+				return true
+			}
+
+		case parser.NodeCollection:
+			if references(tt, name) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// removeNode removes the node at index i from the given list.
+func removeNode(list []parser.Node, i int) []parser.Node {
+	copy(list[i:], list[i+1:])
+	return list[:len(list)-1]
+}
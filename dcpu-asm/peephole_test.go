@@ -0,0 +1,299 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"github.com/jteeuwen/dcpu/parser"
+	"github.com/jteeuwen/dcpu/parser/util"
+	"strings"
+	"testing"
+)
+
+// A peepholeTest holds source code before and after optimization.
+type peepholeTest struct {
+	in, out string
+}
+
+// testPeephole runs the given processor on each test and compares the
+// resulting source code. Whitespace is ignored, since the source writer
+// does not always reproduce it.
+func testPeephole(t *testing.T, p PreProcessor, tests []peepholeTest) {
+	for i, tt := range tests {
+		var ast parser.AST
+		var buf bytes.Buffer
+
+		if err := ast.Parse(bytes.NewBufferString(tt.in), ""); err != nil {
+			t.Fatalf("%d: %v", i, err)
+		}
+
+		if err := p.Process(&ast); err != nil {
+			t.Fatalf("%d: %v", i, err)
+		}
+
+		util.NewSourceWriter(&buf, &ast).Write()
+
+		want, have := normalize(tt.out), normalize(buf.String())
+		if want != have {
+			t.Errorf("%d: Output mismatch.\nWant:\n%s\nHave:\n%s", i, want, have)
+		}
+	}
+}
+
+// normalize removes all whitespace and empty lines.
+func normalize(src string) string {
+	var lines []string
+
+	for _, line := range strings.Split(src, "\n") {
+		if fields := strings.Fields(line); len(fields) > 0 {
+			lines = append(lines, strings.Join(fields, ""))
+		}
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+func TestOptNop(t *testing.T) {
+	testPeephole(t, NewOptNop(), []peepholeTest{
+		{"set a, a\nset b, 1", "set b, 1"},
+		{"bor a, 0\nxor b, 0\nand c, 0xffff", ""},
+		{"add a, 0\nset ex, 0", "set ex, 0"},
+		{"mul a, 1\nadd b, 2", "add b, 2"},
+		{"div a, 1\nset b, 1\nsub c, 1", "set b, 1\nsub c, 1"},
+		{"def foo\nset a, a\nset b, 1\nend", "def foo\nset b, 1\nend"},
+
+		// Not a nop.
+		{"set a, b", "set a, b"},
+		{"add a, 1", "add a, 1"},
+		{"and a, 0", "and a, 0"},
+
+		// Memory and stack operands.
+		{"set [a], [a]", "set [a], [a]"},
+		{"set pop, pop", "set pop, pop"},
+		{"set peek, peek", "set peek, peek"},
+		{"bor push, 0", "bor push, 0"},
+
+		// After a branch.
+		{"ife b, 1\nset a, a", "ife b, 1\nset a, a"},
+		{"ife b, 1\n:l\nset a, a", "ife b, 1\n:l\nset a, a"},
+
+		// EX is still live.
+		{"add a, 0", "add a, 0"},
+		{"add a, 0\nadx b, 1", "add a, 0\nadx b, 1"},
+		{"add a, 0\nset b, ex", "add a, 0\nset b, ex"},
+		{"shl a, 0\nxor ex, 1", "shl a, 0\nxor ex, 1"},
+		{"add a, 0\nife b, 1\nset ex, 0", "add a, 0\nife b, 1\nset ex, 0"},
+		{"add a, 0\njsr foo\nset ex, 0", "add a, 0\njsr foo\nset ex, 0"},
+
+		// A label in between.
+		{"add a, 0\n:l\nset ex, 0", "add a, 0\n:l\nset ex, 0"},
+
+		// PC-relative code.
+		{"set a, a\nadd pc, 1\nset b, 1", "set a, a\nadd pc, 1\nset b, 1"},
+		{"set a, pc\nset b, b", "set a, pc\nset b, b"},
+	})
+}
+
+func TestOptShift(t *testing.T) {
+	testPeephole(t, NewOptShift(), []peepholeTest{
+		{"mul a, 8", "shl a, 3"},
+		{"div b, 64", "shr b, 6"},
+		{"mul [a], 0x8000", "shl [a], 15"},
+		{"ife c, 1\nmul a, 2", "ife c, 1\nshl a, 1"},
+		{"def foo\nmul a, 4\nend", "def foo\nshl a, 2\nend"},
+
+		// Not a power of two.
+		{"mul a, 6", "mul a, 6"},
+		{"mul a, 0", "mul a, 0"},
+		{"mul a, 1", "mul a, 1"},
+		{"mul a, b", "mul a, b"},
+
+		// Signed.
+		{"mli a, 8", "mli a, 8"},
+		{"dvi a, 8", "dvi a, 8"},
+
+		// PC-relative code.
+		{"mul a, 32\nsub pc, 4", "mul a, 32\nsub pc, 4"},
+	})
+}
+
+func TestOptLiteral(t *testing.T) {
+	testPeephole(t, NewOptLiteral(), []peepholeTest{
+		{"ifg 5, a\nset b, 1", "ifl a, 5\nset b, 1"},
+		{"ifl 0, a\nset b, 1", "ifg a, 0\nset b, 1"},
+		{"ifa -1, a\nset b, 1", "ifu a, -1\nset b, 1"},
+		{"ifu 30, a\nset b, 1", "ifa a, 30\nset b, 1"},
+		{"ifb 3, c\nset b, 1", "ifb c, 3\nset b, 1"},
+		{"ifc 3, 0x1000\nset b, 1", "ifc 0x1000, 3\nset b, 1"},
+		{"set [a+0], 1", "set [a], 1"},
+		{"set b, [0+b]", "set b, [b]"},
+		{"add a, -4\nset ex, 0", "sub a, 4\nset ex, 0"},
+		{"sub a, -30\nadd b, 1", "add a, 30\nadd b, 1"},
+		{"add a, 0xfffe\nset ex, 0", "sub a, 2\nset ex, 0"},
+
+		// Already short, or both long.
+		{"ifg a, 5\nset b, 1", "ifg a, 5\nset b, 1"},
+		{"ifg 5, 1\nset b, 1", "ifg 5, 1\nset b, 1"},
+		{"ifg 31, a\nset b, 1", "ifg 31, a\nset b, 1"},
+		{"ife 5, a\nset b, 1", "ife 5, a\nset b, 1"},
+		{"set [a+1], 1", "set [a+1], 1"},
+		{"add a, -1\nset ex, 0", "add a, -1\nset ex, 0"},
+		{"add a, -31\nset ex, 0", "add a, -31\nset ex, 0"},
+
+		// Stack operands.
+		{"ifg 5, pop\nset b, 1", "ifg 5, pop\nset b, 1"},
+		{"ifg 5, peek\nset b, 1", "ifg 5, peek\nset b, 1"},
+		{"ifa 5, pick 1\nset b, 1", "ifa 5, pick 1\nset b, 1"},
+		{"ifb 5, [sp]\nset b, 1", "ifb 5, [sp]\nset b, 1"},
+
+		// EX is still live.
+		{"add a, -4", "add a, -4"},
+		{"add a, -4\nadx b, 1", "add a, -4\nadx b, 1"},
+		{"sub a, -4\nife b, 1\nset ex, 0", "sub a, -4\nife b, 1\nset ex, 0"},
+
+		// A label in between.
+		{"add a, -4\n:l\nset ex, 0", "add a, -4\n:l\nset ex, 0"},
+
+		// PC-relative code.
+		{"add pc, -4\nset ex, 0", "add pc, -4\nset ex, 0"},
+		{"sub pc, -2\nset ex, 0", "sub pc, -2\nset ex, 0"},
+		{"ifg 5, a\nadd pc, 1\nset b, [a+0]", "ifg 5, a\nadd pc, 1\nset b, [a+0]"},
+		{"def foo\nset a, pc\nadd a, -4\nset ex, 0\nend",
+			"def foo\nset a, pc\nadd a, -4\nset ex, 0\nend"},
+	})
+}
+
+func TestOptPushPop(t *testing.T) {
+	testPeephole(t, NewOptPushPop(), []peepholeTest{
+		{"set push, a\nset a, pop\nset b, 1", "set b, 1"},
+		{"set push, a ; save\n; nothing\nset a, pop", "; nothing"},
+		{"def foo\nset push, x\nset x, 1\nset x, pop\nend", "def foo\nset x, 1\nend"},
+		{"def foo\nset push, x\nset push, y\nset x, y\nset y, pop\nset x, pop\nend",
+			"def foo\nset x, y\nend"},
+		{"def foo\nset push, x\nife a, 0\nset pc, l\nset x, 1\n:l\nset x, pop\nend",
+			"def foo\nife a, 0\nset pc, l\nset x, 1\n:l\nend"},
+
+		// Different registers.
+		{"set push, a\nset b, pop", "set push, a\nset b, pop"},
+		{"set push, sp\nset sp, pop", "set push, sp\nset sp, pop"},
+
+		// After a branch.
+		{"ife b, 1\nset push, a\nset a, pop", "ife b, 1\nset push, a\nset a, pop"},
+
+		// A label in between.
+		{"set push, a\n:l\nset a, pop", "set push, a\n:l\nset a, pop"},
+
+		// Not a protected register.
+		{"def foo\nset push, a\nset a, 1\nset a, pop\nend",
+			"def foo\nset push, a\nset a, 1\nset a, pop\nend"},
+
+		// Not popped at every exit.
+		{"def foo\nset push, x\nife a, 0\nreturn\nset x, pop\nend",
+			"def foo\nset push, x\nife a, 0\nreturn\nset x, pop\nend"},
+
+		// Pushed elsewhere.
+		{"def foo\nset push, x\nset push, x\nset a, 1\nset x, pop\nset x, pop\nend",
+			"def foo\nset push, x\nset push, x\nset a, 1\nset x, pop\nset x, pop\nend"},
+
+		// Stack operands.
+		{"def foo\nset push, x\nset a, peek\nset x, pop\nend",
+			"def foo\nset push, x\nset a, peek\nset x, pop\nend"},
+		{"def foo\nset push, x\nset a, pick 2\nset x, pop\nend",
+			"def foo\nset push, x\nset a, pick 2\nset x, pop\nend"},
+		{"def foo\nset push, x\nset a, [sp+1]\nset x, pop\nend",
+			"def foo\nset push, x\nset a, [sp+1]\nset x, pop\nend"},
+
+		// Leaves the function.
+		{"def foo\nset push, x\nset pc, bar\nset x, pop\nend",
+			"def foo\nset push, x\nset pc, bar\nset x, pop\nend"},
+
+		// PC-relative code.
+		{"set push, a\nset a, pop\nsub pc, 3", "set push, a\nset a, pop\nsub pc, 3"},
+		{"def foo\nset push, x\nadd pc, 1\nset x, 1\nset x, pop\nend",
+			"def foo\nset push, x\nadd pc, 1\nset x, 1\nset x, pop\nend"},
+	})
+}
+
+func TestOptTailCall(t *testing.T) {
+	testPeephole(t, NewOptTailCall(), []peepholeTest{
+		{"jsr foo\nset pc, pop", "set pc, foo"},
+		{"jsr foo\n; done\nset pc, pop", "set pc, foo\n; done"},
+		{"jsr [a]\nset pc, pop", "set pc, [a]"},
+		{"def bar\njsr foo\nset pc, pop\nend", "def bar\nset pc, foo\nend"},
+
+		// Not a return.
+		{"jsr foo\nset a, pop", "jsr foo\nset a, pop"},
+		{"jsr foo\nreturn", "jsr foo\nreturn"},
+		{"def bar\njsr foo\nreturn\nend", "def bar\njsr foo\nreturn\nend"},
+
+		// After a branch.
+		{"ife a, 0\njsr foo\nset pc, pop", "ife a, 0\njsr foo\nset pc, pop"},
+
+		// A label in between.
+		{"jsr foo\n:l\nset pc, pop", "jsr foo\n:l\nset pc, pop"},
+
+		// Stack operands.
+		{"jsr pop\nset pc, pop", "jsr pop\nset pc, pop"},
+		{"jsr peek\nset pc, pop", "jsr peek\nset pc, pop"},
+		{"jsr [sp+1]\nset pc, pop", "jsr [sp+1]\nset pc, pop"},
+
+		// PC-relative code.
+		{"jsr foo\nset pc, pop\nsub pc, 3", "jsr foo\nset pc, pop\nsub pc, 3"},
+	})
+}
+
+func TestHasRelativePC(t *testing.T) {
+	tests := []struct {
+		src  string
+		want bool
+	}{
+		{"set pc, foo", false},
+		{"set pc, pop", false},
+		{"ife a, 1\nset pc, [a]", false},
+		{"add pc, 1", true},
+		{"sub pc, 2", true},
+		{"set a, pc", true},
+		{"set pc, pc", true},
+		{"set pc, [pc+1]", true},
+		{"ife pc, a", true},
+	}
+
+	for i, tt := range tests {
+		var ast parser.AST
+
+		if err := ast.Parse(bytes.NewBufferString(tt.src), ""); err != nil {
+			t.Fatalf("%d: %v", i, err)
+		}
+
+		if have := hasRelativePC(ast.Root.Children()); have != tt.want {
+			t.Errorf("%d: Mismatch for %q. Want %v, have %v", i, tt.src, tt.want, have)
+		}
+	}
+}
+
+func TestPreProcessOrder(t *testing.T) {
+	// The nop rule exposes a push/pop pair. This only works if the
+	// optimizations always run in the same order.
+	src := ":main\nset push, b\nset b, b\nset b, pop\nexit"
+
+	for i := 0; i < 20; i++ {
+		var ast parser.AST
+		var buf bytes.Buffer
+
+		if err := ast.Parse(bytes.NewBufferString(src), ""); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := PreProcess(&ast, true); err != nil {
+			t.Fatal(err)
+		}
+
+		util.NewSourceWriter(&buf, &ast).Write()
+
+		want, have := normalize(":main\nexit"), normalize(buf.String())
+		if want != have {
+			t.Fatalf("%d: Output mismatch.\nWant:\n%s\nHave:\n%s", i, want, have)
+		}
+	}
+}
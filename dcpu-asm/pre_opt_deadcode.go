@@ -0,0 +1,288 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"github.com/jteeuwen/dcpu/asm"
+	"github.com/jteeuwen/dcpu/parser"
+	"github.com/jteeuwen/dcpu/parser/util"
+	"os"
+)
+
+func init() {
+	RegisterPreProcessor("deadcode",
+		"Removes code and data which can never be reached from the entry point.",
+		NewOptDeadCode, true)
+}
+
+// OptDeadCode removes code and data which is not reachable from the
+// entry point of the program. The source reader includes entire library
+// files to resolve a single label, so programs tend to carry a lot of
+// code they never use.
+//
+// The program is cut into blocks. Each label and each function starts
+// a new block. A block is reachable if it holds the entry point, if it is
+// referenced by a reachable block, or if a reachable block falls through
+// into it. Any reference to a label counts. Not just jumps and calls,
+// but also data addresses, interrupt handlers set with IAS and so on.
+//
+// EQU, ORG and ALIGN instructions are always kept, along with whatever
+// they reference.
+//
+// The number of words saved is written to stderr. This processor does
+// nothing when building an object file. The linker decides what is used.
+type OptDeadCode struct{}
+
+func NewOptDeadCode() PreProcessor { return new(OptDeadCode) }
+
+// codeBlock is a range of top level nodes which starts with a label
+// or function.
+type codeBlock struct {
+	start, end int      // Range of nodes in the AST root.
+	refs       []string // Names referenced by the block.
+	falls      bool     // Does execution continue into the next block?
+	code       bool     // Does the block start with code?
+	data       bool     // Does the block end with data?
+	live       bool     // Is this block reachable?
+}
+
+func (*OptDeadCode) Process(ast *parser.AST) (err error) {
+	if *object {
+		return
+	}
+
+	before, ok := programSize(ast)
+
+	list := ast.Root.Children()
+	blocks, labels, roots := splitBlocks(list)
+
+	if len(blocks) == 0 {
+		return
+	}
+
+	// Mark reachable blocks, starting at the entry point.
+	stack := []int{0}
+
+	for _, name := range roots {
+		if i, ok := labels[name]; ok {
+			stack = append(stack, i)
+		}
+	}
+
+	for len(stack) > 0 {
+		i := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+
+		if blocks[i].live {
+			continue
+		}
+
+		blocks[i].live = true
+
+		// Data does not run into code. It can run into more data.
+		if blocks[i].falls && i+1 < len(blocks) &&
+			!(blocks[i].data && blocks[i+1].code) {
+			stack = append(stack, i+1)
+		}
+
+		for _, name := range blocks[i].refs {
+			if j, ok := labels[name]; ok {
+				stack = append(stack, j)
+			}
+		}
+	}
+
+	out := make([]parser.Node, 0, len(list))
+	count := 0
+
+	for _, b := range blocks {
+		if b.live {
+			out = append(out, list[b.start:b.end]...)
+			continue
+		}
+
+		removed := false
+
+		for _, n := range list[b.start:b.end] {
+			if isPinned(n) {
+				out = append(out, n)
+			} else if _, ok := n.(*parser.Comment); !ok {
+				removed = true
+			}
+		}
+
+		if removed {
+			count++
+		}
+	}
+
+	ast.Root.SetChildren(out)
+
+	if count == 0 || !ok {
+		return
+	}
+
+	if after, ok := programSize(ast); ok {
+		fmt.Fprintf(os.Stderr, "Dead code: Removed %d block(s), saving %d word(s).\n",
+			count, before-after)
+	}
+
+	return
+}
+
+// splitBlocks cuts the given nodes into blocks. It returns the blocks,
+// the block index for each label and the names referenced by nodes
+// which are always kept.
+func splitBlocks(list []parser.Node) ([]*codeBlock, map[string]int, []string) {
+	var blocks []*codeBlock
+	var roots []string
+
+	labels := make(map[string]int)
+	cur := &codeBlock{falls: true}
+
+	// begin starts a new block at index i. Comments directly preceding
+	// the label or function move along with it.
+	begin := func(i int) {
+		start := i
+		for start > cur.start {
+			if _, ok := list[start-1].(*parser.Comment); !ok {
+				break
+			}
+			start--
+		}
+
+		cur.end = start
+		blocks = append(blocks, cur)
+		cur = &codeBlock{start: start, falls: true, data: cur.data}
+	}
+
+	for i, n := range list {
+		switch tt := n.(type) {
+		case *parser.Label:
+			if hasCode(list[cur.start:i]) {
+				begin(i)
+			}
+
+			labels[tt.Data] = len(blocks)
+
+		case *parser.Function:
+			if i > 0 {
+				begin(i)
+			}
+
+			var local []*parser.Label
+			util.FindLabels(tt.Children(), &local)
+
+			labels[tt.Children()[0].(*parser.Name).Data] = len(blocks)
+
+			for _, l := range local {
+				labels[l.Data] = len(blocks)
+			}
+
+			cur.refs = append(cur.refs, findRefs(tt)...)
+			cur.falls = false
+			cur.code = !hasCode(list[cur.start:i])
+			cur.data = false
+
+			// Anything after the function starts a new block.
+			begin(i + 1)
+
+		case *parser.Instruction:
+			if isPinned(tt) {
+				roots = append(roots, findRefs(tt)...)
+				continue
+			}
+
+			if !hasCode(list[cur.start:i]) {
+				cur.code = !isData(tt)
+			}
+
+			cur.refs = append(cur.refs, findRefs(tt)...)
+			cur.falls = !isTerminator(list, i)
+			cur.data = isData(tt)
+		}
+	}
+
+	cur.end = len(list)
+	blocks = append(blocks, cur)
+	return blocks, labels, roots
+}
+
+// hasCode returns true if the given nodes hold anything other than
+// comments and labels.
+func hasCode(list []parser.Node) bool {
+	for _, n := range list {
+		switch n.(type) {
+		case *parser.Comment, *parser.Label:
+		default:
+			return true
+		}
+	}
+	return false
+}
+
+// isData returns true if the given node produces data.
+func isData(n parser.Node) bool {
+	switch _, name := instruction(n); name {
+	case "dat", "reserve", "fill":
+		return true
+	}
+	return false
+}
+
+// isPinned returns true if the given node is always kept.
+func isPinned(n parser.Node) bool {
+	switch _, name := instruction(n); name {
+	case "equ", "org", "align":
+		return true
+	}
+	return false
+}
+
+// isTerminator returns true if execution never continues past the
+// instruction at index i.
+func isTerminator(list []parser.Node, i int) bool {
+	if isConditional(list, i) {
+		return false
+	}
+
+	instr, name := instruction(list[i])
+
+	switch name {
+	case "rfi", "exit", "panic":
+		return true
+	case "set":
+		args := operands(instr)
+		return len(args) == 2 && operandName(args[0]) == "pc"
+	}
+
+	return false
+}
+
+// findRefs returns the names referenced by the given node.
+func findRefs(n parser.NodeCollection) []string {
+	var refs []*parser.Name
+	util.FindReferences(n.Children(), &refs)
+
+	out := make([]string, len(refs))
+	for i := range refs {
+		out[i] = refs[i].Data
+	}
+
+	return out
+}
+
+// programSize returns the size of the program in the given AST, in words.
+// It assembles a copy of the AST, as the assembler modifies it.
+func programSize(ast *parser.AST) (int, bool) {
+	tmp := parser.AST{
+		Files:     ast.Files,
+		Root:      ast.Root.Copy(0, 0, 0).(*parser.Block),
+		MaxErrors: ast.MaxErrors,
+	}
+
+	code, _, err := asm.Assemble(&tmp)
+	return len(code), err == nil
+}
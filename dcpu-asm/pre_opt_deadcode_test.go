@@ -0,0 +1,52 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package main
+
+import "testing"
+
+func TestOptDeadCode(t *testing.T) {
+	testPeephole(t, NewOptDeadCode(), []peepholeTest{
+		// Falls through into the next block.
+		{"set a, 1\n:two\nset b, 2\nexit\n:dead\nset c, 3\nexit",
+			"set a, 1\n:two\nset b, 2\nexit"},
+		{"ife a, 0\nset pc, end\n:next\nset b, 1\n:end\nexit\n:dead\nexit",
+			"ife a, 0\nset pc, end\n:next\nset b, 1\n:end\nexit"},
+		{"set pc, end\n:dead\nset b, 1\n:end\nexit",
+			"set pc, end\n:end\nexit"},
+		{"ife a, 0\nexit\n:next\nset b, 1\nrfi\n:dead\npanic",
+			"ife a, 0\nexit\n:next\nset b, 1\nrfi"},
+
+		// Data.
+		{"set a, data\nexit\n:unused\ndat 1\n:data\ndat 2",
+			"set a, data\nexit\n:data\ndat 2"},
+		{"set a, data\nexit\n:data\ndat 1\n:more\ndat 2",
+			"set a, data\nexit\n:data\ndat 1\n:more\ndat 2"},
+		{"set a, data\nexit\n:data\ndat 1\n:code\nset b, 1",
+			"set a, data\nexit\n:data\ndat 1"},
+		{"exit\n:data\ndat 1\n:table\ndat data",
+			"exit"},
+
+		// Interrupt handlers.
+		{"ias handler\nsub pc, 1\n:handler\nrfi\n:dead\nrfi",
+			"ias handler\nsub pc, 1\n:handler\nrfi"},
+
+		// EQU, ORG and ALIGN are always kept, along with what they refer to.
+		{"exit\n:dead\nequ size, 3\nset a, 1\n:used\ndat 1\n:more\nequ ptr, used",
+			"exit\nequ size, 3\n:used\ndat 1\n:more\nequ ptr, used"},
+		{"exit\n:dead\nset a, 1\norg 0x100\nset b, 1",
+			"exit\norg 0x100"},
+
+		// Functions.
+		{"jsr foo\nexit\ndef foo\njsr baz\nend\ndef bar\nset a, 2\nend\ndef baz\nset a, 3\nend",
+			"jsr foo\nexit\ndef foo\njsr baz\nend\ndef baz\nset a, 3\nend"},
+		{"set a, foo\nexit\ndef foo\nset a, 1\nend",
+			"set a, foo\nexit\ndef foo\nset a, 1\nend"},
+		{"exit\ndef foo\njsr foo\nend",
+			"exit"},
+
+		// Comments move along with the block they precede.
+		{"exit\n; Unused.\n:dead\nset a, 1",
+			"exit"},
+	})
+}
@@ -0,0 +1,139 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"github.com/jteeuwen/dcpu/parser"
+)
+
+func init() {
+	RegisterPreProcessor("literal",
+		"Rewrites operands so literals fit in the instruction word.",
+		NewOptLiteral, true)
+}
+
+// Branches which test the same condition with their operands swapped.
+var swappedBranches = map[string]string{
+	"ifg": "ifl", "ifl": "ifg",
+	"ifa": "ifu", "ifu": "ifa",
+	"ifb": "ifb", "ifc": "ifc",
+}
+
+// OptLiteral finds operands which need an extra word, where an
+// equivalent form does not. It complements the -shorthand processor:
+//
+//   - `IFG 5, A` becomes `IFL A, 5`. Likewise for IFA and IFU.
+//     IFB and IFC just have their operands swapped. Short literals
+//     can only be encoded in the second operand. Branches which use
+//     the stack are left alone.
+//   - `[A+0]` becomes `[A]`.
+//   - `ADD A, -n` becomes `SUB A, n` and vice versa, for n in the
+//     range 2-30. The negative value does not fit in a short literal.
+//     These set EX differently, so the rewrite is only done if EX is
+//     overwritten before it can be read.
+type OptLiteral struct{}
+
+func NewOptLiteral() PreProcessor { return new(OptLiteral) }
+
+func (*OptLiteral) Process(ast *parser.AST) (err error) {
+	peephole(ast, rewriteLiterals)
+	return
+}
+
+func rewriteLiterals(list []parser.Node) []parser.Node {
+	for i := range list {
+		instr, name := instruction(list[i])
+		if instr == nil {
+			continue
+		}
+
+		args := operands(instr)
+		for _, expr := range args {
+			removeZeroOffset(expr)
+		}
+
+		if len(args) != 2 {
+			continue
+		}
+
+		op := instr.Children()[0].(*parser.Name)
+
+		if swapped, ok := swappedBranches[name]; ok {
+			// Stack operands mean something else in the other slot.
+			// PUSH and POP share an encoding, for one.
+			if usesStack(args[0]) || usesStack(args[1]) {
+				continue
+			}
+
+			if v, ok := operandValue(args[0]); ok && isShort(v) {
+				if v, ok = operandValue(args[1]); !ok || !isShort(v) {
+					op.Data = swapped
+					a, b := args[0].Children(), args[1].Children()
+					args[0].SetChildren(b)
+					args[1].SetChildren(a)
+				}
+			}
+			continue
+		}
+
+		if name != "add" && name != "sub" {
+			continue
+		}
+
+		v, ok := operandValue(args[1])
+		if !ok || isShort(v) || !isShort(-v) || !isExUnused(list, i) {
+			continue
+		}
+
+		if name == "add" {
+			op.Data = "sub"
+		} else {
+			op.Data = "add"
+		}
+
+		setOperand(args[1], parser.NewNumber(args[1].File(),
+			args[1].Line(), args[1].Col(), fmt.Sprintf("%d", -v)))
+	}
+
+	return list
+}
+
+// removeZeroOffset turns `[R+0]` and `[0+R]` into `[R]`.
+func removeZeroOffset(expr *parser.Expression) {
+	list := parser.StripComments(expr.Children())
+	if len(list) != 1 {
+		return
+	}
+
+	block, ok := list[0].(*parser.Block)
+	if !ok {
+		return
+	}
+
+	nodes := parser.StripComments(block.Children())
+	if len(nodes) != 3 {
+		return
+	}
+
+	op, ok := nodes[1].(*parser.Operator)
+	if !ok || op.Data != "+" {
+		return
+	}
+
+	for i, j := 0, 2; i <= 2; i, j = i+2, j-2 {
+		reg, ok := nodes[i].(*parser.Name)
+		if !ok || !parser.IsRegister(reg.Data) {
+			continue
+		}
+
+		if num, ok := nodes[j].(parser.NumericNode); ok {
+			if v, err := num.Parse(); err == nil && v == 0 {
+				block.SetChildren([]parser.Node{reg})
+			}
+		}
+
+		return
+	}
+}
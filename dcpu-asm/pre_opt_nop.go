@@ -0,0 +1,86 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package main
+
+import "github.com/jteeuwen/dcpu/parser"
+
+func init() {
+	RegisterPreProcessor("nop",
+		"Removes instructions which do nothing, like 'SET A, A' or 'ADD A, 0'.",
+		NewOptNop, true)
+}
+
+// Instructions which do nothing when given this value, along with
+// a value indicating whether they set EX.
+var nopValues = map[string]struct {
+	value uint16
+	ex    bool
+}{
+	"add": {0, true},
+	"sub": {0, true},
+	"shl": {0, true},
+	"shr": {0, true},
+	"asr": {0, true},
+	"mul": {1, true},
+	"div": {1, true},
+	"bor": {0, false},
+	"xor": {0, false},
+	"and": {0xffff, false},
+}
+
+// OptNop removes instructions which leave their operand unchanged.
+// For example: `SET A, A`, `ADD A, 0` or `MUL A, 1`.
+//
+// Some of these do set EX to 0. They are only removed if EX is
+// overwritten before it can be read.
+//
+// Instructions following a branch are left alone, since removing
+// them changes which instruction the branch skips.
+type OptNop struct{}
+
+func NewOptNop() PreProcessor { return new(OptNop) }
+
+func (*OptNop) Process(ast *parser.AST) (err error) {
+	peephole(ast, removeNops)
+	return
+}
+
+func removeNops(list []parser.Node) []parser.Node {
+	for i := 0; i < len(list); i++ {
+		if isNop(list, i) {
+			list = removeNode(list, i)
+			i--
+		}
+	}
+
+	return list
+}
+
+// isNop returns true if the node at index i can be removed.
+func isNop(list []parser.Node, i int) bool {
+	instr, name := instruction(list[i])
+	if instr == nil || isConditional(list, i) {
+		return false
+	}
+
+	args := operands(instr)
+	if len(args) != 2 || !isRegister(operandName(args[0])) {
+		return false
+	}
+
+	if name == "set" {
+		return operandName(args[0]) == operandName(args[1])
+	}
+
+	nop, ok := nopValues[name]
+	if !ok {
+		return false
+	}
+
+	if v, ok := operandValue(args[1]); !ok || uint16(v) != nop.value {
+		return false
+	}
+
+	return !nop.ex || isExUnused(list, i)
+}
@@ -0,0 +1,287 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package main
+
+import "github.com/jteeuwen/dcpu/parser"
+
+func init() {
+	RegisterPreProcessor("pushpop",
+		"Removes redundant 'SET PUSH, R' and 'SET R, POP' pairs.",
+		NewOptPushPop, true)
+}
+
+// OptPushPop removes stack pushes which are undone right away:
+//
+//	set push, a
+//	set a, pop
+//
+// It also removes explicit saves of protected registers (X, Y, Z, I
+// and J) in functions. The assembler injects a prolog and epilog into
+// each function, which saves every protected register the function
+// uses. Code like the following saves X twice:
+//
+//	def foo
+//	    set push, x
+//	    ...
+//	    set x, pop
+//	end
+//
+// The explicit pushes are removed if they open the function, and each
+// exit from the function is preceded by the matching pops. Functions
+// which access the stack through SP, PEEK or PICK are left alone.
+// If the register is not used anywhere else, the epilog no longer
+// saves it either.
+type OptPushPop struct{}
+
+func NewOptPushPop() PreProcessor { return new(OptPushPop) }
+
+func (*OptPushPop) Process(ast *parser.AST) (err error) {
+	peephole(ast, removePushPops)
+
+	for _, n := range ast.Root.Children() {
+		if f, ok := n.(*parser.Function); ok {
+			removeSavedRegisters(f)
+		}
+	}
+
+	return
+}
+
+// removePushPops removes a push which is immediately followed by a pop
+// into the same register.
+func removePushPops(list []parser.Node) []parser.Node {
+	for i := 0; i < len(list); i++ {
+		reg := pushed(list[i])
+		if len(reg) == 0 || isConditional(list, i) {
+			continue
+		}
+
+		j := next(list, i)
+		if j >= len(list) || popped(list[j]) != reg {
+			continue
+		}
+
+		list = removeNode(list, j)
+		list = removeNode(list, i)
+		i--
+	}
+
+	return list
+}
+
+// removeSavedRegisters removes explicit saves of protected registers
+// from the given function.
+func removeSavedRegisters(f *parser.Function) {
+	body := f.Children()[1:]
+	if hasRelativePC(body) {
+		return
+	}
+
+	// Find the pushes which open the function.
+	var regs []string
+	var start []int
+
+	for i := next(body, -1); i < len(body); i = next(body, i) {
+		reg := pushed(body[i])
+		if !isProtected(reg) || contains(regs, reg) {
+			break
+		}
+
+		regs = append(regs, reg)
+		start = append(start, i)
+	}
+
+	if len(regs) == 0 {
+		return
+	}
+
+	// Find the exits. Each must be preceded by the pops, in reverse order.
+	// The end of the body is an exit, unless it ends with a return.
+	var exits []int
+
+	for i := range body {
+		if _, name := instruction(body[i]); name == "return" {
+			exits = append(exits, i)
+		}
+	}
+
+	last := len(exits) - 1
+	if last < 0 || next(body, exits[last]) < len(body) || isConditional(body, exits[last]) {
+		exits = append(exits, len(body))
+	}
+
+	var pops []int
+
+	for _, exit := range exits {
+		list, ok := findPops(body, exit, regs)
+		if !ok {
+			return
+		}
+
+		pops = append(pops, list...)
+	}
+
+	// The registers may not be pushed or popped anywhere else, and
+	// the function may not be left by any other means. Removing the
+	// pushes changes the stack layout, so it may not be inspected.
+	labels := make(map[string]bool)
+
+	for i := range body {
+		if label, ok := body[i].(*parser.Label); ok {
+			labels[label.Data] = true
+		}
+	}
+
+	count := 0
+
+	for i := range body {
+		if contains(regs, pushed(body[i])) || contains(regs, popped(body[i])) {
+			count++
+		}
+
+		if inspectsStack(body[i]) || leaves(body[i], labels) {
+			return
+		}
+	}
+
+	if count != len(start)+len(pops) {
+		return
+	}
+
+	remove := make(map[int]bool)
+	for _, i := range append(start, pops...) {
+		remove[i] = true
+	}
+
+	out := []parser.Node{f.Children()[0]}
+
+	for i := range body {
+		if !remove[i] {
+			out = append(out, body[i])
+		}
+	}
+
+	f.SetChildren(out)
+}
+
+// findPops returns the indices of the pops of the given registers,
+// which precede the exit at the given index. They must be in reverse
+// order, with nothing in between but comments.
+func findPops(body []parser.Node, exit int, regs []string) ([]int, bool) {
+	var list []int
+
+	i := exit
+	for _, reg := range regs {
+		// Search backwards for the previous non-comment node.
+		for i--; i >= 0; i-- {
+			if _, ok := body[i].(*parser.Comment); !ok {
+				break
+			}
+		}
+
+		if i < 0 || popped(body[i]) != reg {
+			return nil, false
+		}
+
+		list = append(list, i)
+	}
+
+	if isConditional(body, i) {
+		return nil, false
+	}
+
+	return list, true
+}
+
+// pushed returns the register pushed by the given node, if it
+// is a `set push, R` instruction.
+func pushed(n parser.Node) string {
+	instr, name := instruction(n)
+	if name != "set" {
+		return ""
+	}
+
+	args := operands(instr)
+	if len(args) != 2 || operandName(args[0]) != "push" {
+		return ""
+	}
+
+	if reg := operandName(args[1]); isRegister(reg) && reg != "sp" {
+		return reg
+	}
+
+	return ""
+}
+
+// popped returns the register popped by the given node, if it
+// is a `set R, pop` instruction.
+func popped(n parser.Node) string {
+	instr, name := instruction(n)
+	if name != "set" {
+		return ""
+	}
+
+	args := operands(instr)
+	if len(args) != 2 || operandName(args[1]) != "pop" {
+		return ""
+	}
+
+	if reg := operandName(args[0]); isRegister(reg) && reg != "sp" {
+		return reg
+	}
+
+	return ""
+}
+
+// leaves returns true if the given node jumps to anything other
+// than the given labels.
+func leaves(n parser.Node, labels map[string]bool) bool {
+	instr, name := instruction(n)
+	if instr == nil || parser.IsBranch(name) {
+		return false
+	}
+
+	switch name {
+	case "rfi", "exit", "panic":
+		return true
+	}
+
+	args := operands(instr)
+	if len(args) != 2 || operandName(args[0]) != "pc" {
+		return false
+	}
+
+	return !labels[operandName(args[1])]
+}
+
+// inspectsStack returns true if the given node reads from the stack,
+// other than by popping from it.
+func inspectsStack(n parser.Node) bool {
+	instr, _ := instruction(n)
+	if instr == nil {
+		return false
+	}
+
+	return references(instr, "sp") || references(instr, "peek") ||
+		references(instr, "pick")
+}
+
+// isProtected returns true if the given register is saved by
+// function prologs.
+func isProtected(reg string) bool {
+	switch reg {
+	case "x", "y", "z", "i", "j":
+		return true
+	}
+	return false
+}
+
+func contains(list []string, v string) bool {
+	for i := range list {
+		if list[i] == v {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,82 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"github.com/jteeuwen/dcpu/parser"
+)
+
+func init() {
+	RegisterPreProcessor("shift",
+		"Turns 'MUL A, 2^n' into 'SHL A, n' and 'DIV A, 2^n' into 'SHR A, n'.",
+		NewOptShift, true)
+}
+
+// OptShift replaces multiplication and division by a power of two
+// with the equivalent shift. `MUL A, 8` becomes `SHL A, 3` and
+// `DIV A, 8` becomes `SHR A, 3`.
+//
+// The shifts cost one cycle less, and the shift amount always fits in
+// a short literal. Both produce the same value and the same EX.
+// MLI and DVI are left alone. They are signed, so their EX differs.
+type OptShift struct{}
+
+func NewOptShift() PreProcessor { return new(OptShift) }
+
+func (*OptShift) Process(ast *parser.AST) (err error) {
+	peephole(ast, replaceShifts)
+	return
+}
+
+func replaceShifts(list []parser.Node) []parser.Node {
+	for i := range list {
+		instr, name := instruction(list[i])
+		if name != "mul" && name != "div" {
+			continue
+		}
+
+		args := operands(instr)
+		if len(args) != 2 {
+			continue
+		}
+
+		v, ok := operandValue(args[1])
+		if !ok {
+			continue
+		}
+
+		n := log2(uint16(v))
+		if n < 1 {
+			continue
+		}
+
+		op := instr.Children()[0].(*parser.Name)
+		if name == "mul" {
+			op.Data = "shl"
+		} else {
+			op.Data = "shr"
+		}
+
+		setOperand(args[1], parser.NewNumber(args[1].File(),
+			args[1].Line(), args[1].Col(), fmt.Sprintf("%d", n)))
+	}
+
+	return list
+}
+
+// log2 returns n if v is 2^n. It returns -1 if v is not a power of two.
+func log2(v uint16) int {
+	if v == 0 || v&(v-1) != 0 {
+		return -1
+	}
+
+	n := 0
+	for v > 1 {
+		v >>= 1
+		n++
+	}
+
+	return n
+}
@@ -0,0 +1,86 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package main
+
+import "github.com/jteeuwen/dcpu/parser"
+
+func init() {
+	RegisterPreProcessor("tailcall",
+		"Turns 'JSR label' followed by 'SET PC, POP' into 'SET PC, label'.",
+		NewOptTailCall, true)
+}
+
+// OptTailCall replaces a subroutine call which is immediately followed
+// by a return, with a jump:
+//
+//	jsr label           set pc, label
+//	set pc, pop    =>
+//
+// The called routine then returns straight to our own caller. This
+// saves an instruction, a few cycles and a word of stack space.
+//
+// The rewrite is skipped if the call follows a branch, or if there is
+// a label between the two instructions. A function's `return` is not
+// touched, since it runs the function epilog first.
+type OptTailCall struct{}
+
+func NewOptTailCall() PreProcessor { return new(OptTailCall) }
+
+func (*OptTailCall) Process(ast *parser.AST) (err error) {
+	peephole(ast, replaceTailCalls)
+	return
+}
+
+func replaceTailCalls(list []parser.Node) []parser.Node {
+	for i := 0; i < len(list); i++ {
+		instr, name := instruction(list[i])
+		if name != "jsr" || isConditional(list, i) {
+			continue
+		}
+
+		args := operands(instr)
+		if len(args) != 1 || usesStack(args[0]) {
+			continue
+		}
+
+		j := next(list, i)
+		if j >= len(list) || !isReturn(list[j]) {
+			continue
+		}
+
+		// jsr has a single operand. The target becomes the second.
+		file, line, col := instr.File(), instr.Line(), instr.Col()
+		pc := parser.NewExpression(file, line, col)
+		pc.SetChildren([]parser.Node{parser.NewName(file, line, col, "pc")})
+
+		instr.Children()[0].(*parser.Name).Data = "set"
+		instr.SetChildren([]parser.Node{instr.Children()[0], pc, args[0]})
+
+		list = removeNode(list, j)
+	}
+
+	return list
+}
+
+// isReturn returns true if the given node is `set pc, pop`.
+func isReturn(n parser.Node) bool {
+	instr, name := instruction(n)
+	if name != "set" {
+		return false
+	}
+
+	args := operands(instr)
+	return len(args) == 2 && operandName(args[0]) == "pc" &&
+		operandName(args[1]) == "pop"
+}
+
+// usesStack returns true if the given operand refers to the stack.
+func usesStack(expr *parser.Expression) bool {
+	for _, name := range []string{"push", "pop", "peek", "pick", "sp"} {
+		if references(expr, name) {
+			return true
+		}
+	}
+	return false
+}
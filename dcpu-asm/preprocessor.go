@@ -27,6 +27,29 @@ type PreProcessorDef struct {
 // we should use them or not.
 var preprocessors map[string]*PreProcessorDef
 
+// preprocessorOrder lists the pre-processors in the order in which they
+// run. The optimizations affect each other, so a fixed order ensures
+// the same input always gives the same output:
+//
+//   - Comments are stripped first.
+//   - Rewrites of single instructions (nop, shift, literal, shorthand)
+//     come next. They expose patterns for the others.
+//   - Then rules which look at sequences of instructions (pushpop,
+//     tailcall).
+//   - Dead code is removed last, once all jumps are final.
+//   - Labels are scrambled after all optimizations.
+var preprocessorOrder = []string{
+	"strip",
+	"nop",
+	"shift",
+	"literal",
+	"shorthand",
+	"pushpop",
+	"tailcall",
+	"deadcode",
+	"scramble",
+}
+
 // Register registers a new pre-processor with its commandline name,
 // description string.
 func RegisterPreProcessor(name, desc string, pf PreProcessorFunc, isopt bool) {
@@ -38,6 +61,10 @@ func RegisterPreProcessor(name, desc string, pf PreProcessorFunc, isopt bool) {
 		panic("Duplicate PreProcessor: " + name)
 	}
 
+	if !hasName(preprocessorOrder, name) {
+		panic("PreProcessor missing from preprocessorOrder: " + name)
+	}
+
 	preprocessors[name] = &PreProcessorDef{
 		proc:  pf,
 		desc:  desc,
@@ -46,10 +73,15 @@ func RegisterPreProcessor(name, desc string, pf PreProcessorFunc, isopt bool) {
 	}
 }
 
-// PreProcess traverses all registered pre-processor and
-// passes the AST into them for parsing.
+// PreProcess passes the AST into all selected pre-processors,
+// in the order given by preprocessorOrder.
 func PreProcess(ast *dp.AST, force_opt bool) (err error) {
-	for _, v := range preprocessors {
+	for _, name := range preprocessorOrder {
+		v, ok := preprocessors[name]
+		if !ok {
+			continue
+		}
+
 		if force_opt && v.isopt {
 			v.use = true
 		}
@@ -74,3 +106,12 @@ func CreatePreProcessorFlags() {
 		flag.BoolVar(&v.use, k, v.use, v.desc)
 	}
 }
+
+func hasName(list []string, name string) bool {
+	for i := range list {
+		if list[i] == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,461 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/jteeuwen/dcpu/cpu"
+	"strconv"
+	"strings"
+)
+
+const (
+	DefaultListCount = 8      // Default number of instructions for 'list'.
+	DefaultMemCount  = 8      // Default number of words for 'mem'.
+	DefaultHistory   = 100000 // Default number of undoable instructions.
+	WordsPerLine     = 8      // Number of words per line in memory dumps.
+)
+
+// ErrQuit is returned by Handle for the 'quit' command.
+var ErrQuit = errors.New("Quit.")
+
+// Handle executes the given command. Its output is written to the
+// debugger's output. Errors are returned, instead of written.
+func Handle(d *Debugger, str []string) (err error) {
+	switch strings.ToLower(str[0]) {
+	case "help", "h":
+		usage(d.out)
+
+	case "quit", "q":
+		return ErrQuit
+
+	case "reset":
+		d.Reset()
+		where(d)
+
+	case "step", "s":
+		var n uint64 = 1
+
+		if len(str) > 1 {
+			if n, err = strconv.ParseUint(str[1], 0, 32); err != nil {
+				break
+			}
+		}
+
+		for ; n > 0 && d.Step(); n-- {
+		}
+
+		where(d)
+
+	case "back", "bk":
+		var n uint64 = 1
+
+		if len(str) > 1 {
+			if n, err = strconv.ParseUint(str[1], 0, 32); err != nil {
+				break
+			}
+		}
+
+		for ; n > 0 && d.StepBack(); n-- {
+		}
+
+		where(d)
+
+	case "rcontinue", "rc":
+		d.RunBack()
+		where(d)
+
+	case "next", "n":
+		d.StepOver()
+		where(d)
+
+	case "out", "o":
+		d.StepOut()
+		where(d)
+
+	case "continue", "c":
+		d.Run(nil)
+		where(d)
+
+	case "break", "b":
+		err = breakpoint(d, str[1:])
+
+	case "delete", "d":
+		err = deleteBreakpoint(d, str[1:])
+
+	case "watch", "w":
+		err = watchpoint(d, str[1:])
+
+	case "unwatch":
+		err = deleteWatchpoint(d, str[1:])
+
+	case "where":
+		where(d)
+
+	case "list", "l":
+		err = list(d, str[1:])
+
+	case "regs", "r":
+		regs(d)
+
+	case "mem", "m":
+		err = mem(d, str[1:])
+
+	case "set":
+		err = set(d, str[1:])
+
+	case "stack", "bt":
+		stack(d)
+
+	case "save":
+		err = save(d, str[1:])
+
+	case "load":
+		err = load(d, str[1:])
+
+	default:
+		err = errors.New(fmt.Sprintf("Unknown command %q. Type 'help' for help.", str[0]))
+	}
+
+	return
+}
+
+// where prints the instruction at the current PC.
+func where(d *Debugger) {
+	if d.halted {
+		return
+	}
+
+	printInstruction(d, d.cpu.Store.PC)
+}
+
+// printInstruction prints the instruction at the given address
+// along with its original source.
+func printInstruction(d *Debugger, addr cpu.Word) cpu.Word {
+	instr := d.Instruction(addr)
+	mark := " "
+
+	if d.HasBreakpoint(addr) {
+		mark = "*"
+	}
+
+	if addr == d.cpu.Store.PC {
+		mark += ">"
+	} else {
+		mark += " "
+	}
+
+	line := fmt.Sprintf("%s %04x: %-28s", mark, addr, instr.Format(d.sym.Labels()))
+
+	if loc := d.sym.Location(addr); len(loc) > 0 {
+		line += " ; " + loc
+
+		if src := d.sym.Source(addr); len(src) > 0 {
+			line += " | " + src
+		}
+	}
+
+	fmt.Fprintln(d.out, strings.TrimRight(line, " "))
+	return instr.Size
+}
+
+// list prints a number of instructions, starting at the given location.
+func list(d *Debugger, args []string) error {
+	addr := d.cpu.Store.PC
+	count := uint64(DefaultListCount)
+
+	if len(args) > 0 {
+		var err error
+
+		if addr, err = d.sym.Resolve(args[0]); err != nil {
+			return err
+		}
+	}
+
+	if len(args) > 1 {
+		var err error
+
+		if count, err = strconv.ParseUint(args[1], 0, 16); err != nil {
+			return err
+		}
+	}
+
+	for ; count > 0; count-- {
+		size := printInstruction(d, addr)
+
+		if int(addr)+int(size) >= cpu.MemSize {
+			break
+		}
+
+		addr += size
+	}
+
+	return nil
+}
+
+// breakpoint sets a breakpoint at the given location.
+// Without a location, this lists all breakpoints.
+func breakpoint(d *Debugger, args []string) error {
+	if len(args) == 0 {
+		if len(d.breakpoints) == 0 {
+			fmt.Fprintln(d.out, "[*] No breakpoints.")
+		}
+
+		for _, addr := range d.breakpoints {
+			fmt.Fprintf(d.out, "    %s\n", d.describe(addr))
+		}
+
+		return nil
+	}
+
+	addr, err := d.sym.Resolve(args[0])
+	if err != nil {
+		return err
+	}
+
+	d.AddBreakpoint(addr)
+	fmt.Fprintf(d.out, "[*] Breakpoint set at %s\n", d.describe(addr))
+	return nil
+}
+
+// deleteBreakpoint removes the breakpoint at the given location,
+// or all of them.
+func deleteBreakpoint(d *Debugger, args []string) error {
+	if len(args) == 0 {
+		return errors.New("Missing location. Use 'all' to delete all breakpoints.")
+	}
+
+	if args[0] == "all" {
+		d.breakpoints = nil
+		return nil
+	}
+
+	addr, err := d.sym.Resolve(args[0])
+	if err != nil {
+		return err
+	}
+
+	if !d.RemoveBreakpoint(addr) {
+		return errors.New(fmt.Sprintf("No breakpoint at %s.", d.describe(addr)))
+	}
+
+	return nil
+}
+
+// watchpoint watches the memory word at the given location.
+// Without a location, this lists all watchpoints.
+func watchpoint(d *Debugger, args []string) error {
+	if len(args) == 0 {
+		list := d.Watchpoints()
+
+		if len(list) == 0 {
+			fmt.Fprintln(d.out, "[*] No watchpoints.")
+		}
+
+		for _, addr := range list {
+			fmt.Fprintf(d.out, "    %s = %04x\n", d.describe(addr), d.cpu.Store.Mem[addr])
+		}
+
+		return nil
+	}
+
+	addr, err := d.sym.Resolve(args[0])
+	if err != nil {
+		return err
+	}
+
+	d.AddWatchpoint(addr)
+	fmt.Fprintf(d.out, "[*] Watching %s = %04x\n", d.describe(addr), d.cpu.Store.Mem[addr])
+	return nil
+}
+
+// deleteWatchpoint stops watching the given location, or all of them.
+func deleteWatchpoint(d *Debugger, args []string) error {
+	if len(args) == 0 {
+		return errors.New("Missing location. Use 'all' to delete all watchpoints.")
+	}
+
+	if args[0] == "all" {
+		d.watchpoints = make(map[cpu.Word]cpu.Word)
+		return nil
+	}
+
+	addr, err := d.sym.Resolve(args[0])
+	if err != nil {
+		return err
+	}
+
+	if !d.RemoveWatchpoint(addr) {
+		return errors.New(fmt.Sprintf("No watchpoint at %04x.", addr))
+	}
+
+	return nil
+}
+
+// regs prints the register contents.
+func regs(d *Debugger) {
+	s := d.cpu.Store
+
+	fmt.Fprintf(d.out, "    a %04x  b %04x  c %04x  x %04x  y %04x  z %04x  i %04x  j %04x\n",
+		s.A, s.B, s.C, s.X, s.Y, s.Z, s.I, s.J)
+	fmt.Fprintf(d.out, "   pc %04x sp %04x ex %04x ia %04x  cycles %d\n",
+		s.PC, s.SP, s.EX, s.IA, d.cpu.Cycles())
+}
+
+// mem prints a number of memory words, starting at the given location.
+func mem(d *Debugger, args []string) error {
+	if len(args) == 0 {
+		return errors.New("Missing location.")
+	}
+
+	addr, err := d.sym.Resolve(args[0])
+	if err != nil {
+		return err
+	}
+
+	count := uint64(DefaultMemCount)
+
+	if len(args) > 1 {
+		if count, err = strconv.ParseUint(args[1], 0, 16); err != nil {
+			return err
+		}
+	}
+
+	if int(addr)+int(count) > cpu.MemSize {
+		count = uint64(cpu.MemSize - int(addr))
+	}
+
+	for i := 0; i < int(count); i += WordsPerLine {
+		fmt.Fprintf(d.out, "    %04x:", int(addr)+i)
+
+		for j := i; j < i+WordsPerLine && j < int(count); j++ {
+			fmt.Fprintf(d.out, " %04x", d.cpu.Store.Mem[int(addr)+j])
+		}
+
+		fmt.Fprintln(d.out)
+	}
+
+	return nil
+}
+
+// set changes the contents of a register or memory word.
+// Memory locations are written as `[loc]`.
+func set(d *Debugger, args []string) error {
+	if len(args) != 2 {
+		return errors.New("Usage: set <register|[location]> <value>")
+	}
+
+	v, err := value(d, args[1])
+	if err != nil {
+		return err
+	}
+
+	target := strings.ToLower(args[0])
+
+	if strings.HasPrefix(target, "[") && strings.HasSuffix(target, "]") {
+		addr, err := d.sym.Resolve(args[0][1 : len(args[0])-1])
+		if err != nil {
+			return err
+		}
+
+		d.cpu.Store.Mem[addr] = v
+		if _, ok := d.watchpoints[addr]; ok {
+			d.watchpoints[addr] = v
+		}
+
+		return nil
+	}
+
+	reg := register(d.cpu.Store, target)
+	if reg == nil {
+		return errors.New(fmt.Sprintf("Unknown register %q.", args[0]))
+	}
+
+	*reg = v
+	return nil
+}
+
+// value parses the given value. This can be a number, including
+// negative ones, or any location accepted by Symbols.Resolve.
+func value(d *Debugger, str string) (cpu.Word, error) {
+	if n, err := strconv.ParseInt(str, 0, 32); err == nil {
+		if n < -0x8000 || n > 0xffff {
+			return 0, errors.New(fmt.Sprintf("Value %s out of range.", str))
+		}
+
+		return cpu.Word(n), nil
+	}
+
+	return d.sym.Resolve(str)
+}
+
+// register returns a pointer to the named register.
+func register(s *cpu.Storage, name string) *cpu.Word {
+	switch name {
+	case "a":
+		return &s.A
+	case "b":
+		return &s.B
+	case "c":
+		return &s.C
+	case "x":
+		return &s.X
+	case "y":
+		return &s.Y
+	case "z":
+		return &s.Z
+	case "i":
+		return &s.I
+	case "j":
+		return &s.J
+	case "pc":
+		return &s.PC
+	case "sp":
+		return &s.SP
+	case "ex":
+		return &s.EX
+	case "ia":
+		return &s.IA
+	}
+
+	return nil
+}
+
+// stack prints the symbolic call stack, innermost call first.
+func stack(d *Debugger) {
+	fmt.Fprintf(d.out, "    #0 %s\n", d.describe(d.cpu.Store.PC))
+
+	for i := len(d.callstack) - 1; i >= 0; i-- {
+		fmt.Fprintf(d.out, "    #%d %s\n", len(d.callstack)-i, d.describe(d.callstack[i].Caller))
+	}
+}
+
+// save writes a snapshot of the machine state.
+func save(d *Debugger, args []string) error {
+	if len(args) == 0 {
+		return errors.New("Missing file name.")
+	}
+
+	if err := d.Save(args[0]); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(d.out, "[*] State saved to %s\n", args[0])
+	return nil
+}
+
+// load restores the machine state from a snapshot.
+func load(d *Debugger, args []string) error {
+	if len(args) == 0 {
+		return errors.New("Missing file name.")
+	}
+
+	if err := d.Load(args[0]); err != nil {
+		return err
+	}
+
+	where(d)
+	return nil
+}
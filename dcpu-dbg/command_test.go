@@ -0,0 +1,144 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"github.com/jteeuwen/dcpu/asm"
+	"github.com/jteeuwen/dcpu/parser"
+	"strings"
+	"testing"
+)
+
+// Addresses: main 0000, sub 0005, data 0007.
+const testProgram = `:main
+   set a, 1
+   jsr sub
+   set [data], a
+   exit
+:sub
+   add a, 2
+   set pc, pop
+:data
+   dat 0
+`
+
+// newTestDebugger creates a debugger for testProgram, which writes
+// its output to the returned buffer.
+func newTestDebugger(t *testing.T) (*Debugger, *bytes.Buffer) {
+	var ast parser.AST
+	var buf bytes.Buffer
+
+	if err := ast.Parse(bytes.NewBufferString(testProgram), "test.dasm"); err != nil {
+		t.Fatal(err)
+	}
+
+	program, dbg, err := asm.Assemble(&ast)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d := NewDebugger(program, NewSymbols(dbg), DefaultHistory)
+	d.out = &buf
+	return d, &buf
+}
+
+func TestHandle(t *testing.T) {
+	for _, tt := range []struct {
+		cmds []string // Commands to run, in order.
+		want []string // Output of the last command must contain these.
+		err  bool     // Does the last command fail?
+	}{
+		{[]string{"regs"}, []string{"a 0000", "pc 0000"}, false},
+		{[]string{"step", "regs"}, []string{"a 0001", "pc 0001"}, false},
+		{[]string{"step 2", "where"}, []string{"> 0005: add a, 2"}, false},
+		{[]string{"step x"}, nil, true},
+		{[]string{"next", "next", "regs"}, []string{"a 0003", "pc 0002"}, false},
+		{[]string{"step 2", "out", "regs"}, []string{"a 0003", "pc 0002"}, false},
+		{[]string{"step 2", "stack"}, []string{"#0 0005 sub", "#1 0001 main+1"}, false},
+		{[]string{"step 2", "back", "where"}, []string{"> 0001: jsr sub"}, false},
+		{[]string{"back"}, []string{"No more history."}, false},
+
+		{[]string{"break sub"}, []string{"Breakpoint set at 0005 sub"}, false},
+		{[]string{"break 0x10", "break sub", "break"}, []string{"0005 sub", "0010"}, false},
+		{[]string{"break sub", "continue"}, []string{"Breakpoint at 0005 sub"}, false},
+		{[]string{"break sub", "delete sub", "continue"}, []string{"Program exited"}, false},
+		{[]string{"break sub", "delete all", "break"}, []string{"No breakpoints."}, false},
+		{[]string{"delete sub"}, nil, true},
+		{[]string{"break nothere"}, nil, true},
+
+		{[]string{"watch data", "continue"}, []string{"Watchpoint 0007: 0000 -> 0003"}, false},
+		{[]string{"watch data", "unwatch data", "watch"}, []string{"No watchpoints."}, false},
+		{[]string{"unwatch data"}, nil, true},
+
+		{[]string{"mem data 1"}, []string{"0007: 0000"}, false},
+		{[]string{"continue", "mem data 1"}, []string{"0007: 0003"}, false},
+		{[]string{"mem"}, nil, true},
+		{[]string{"list main 2"}, []string{"0000: set a, 1", "0001: jsr sub"}, false},
+
+		{[]string{"set a 0x10", "regs"}, []string{"a 0010"}, false},
+		{[]string{"set [data] -1", "mem data 1"}, []string{"0007: ffff"}, false},
+		{[]string{"set pc sub", "where"}, []string{"> 0005: add a, 2"}, false},
+		{[]string{"set q 1"}, nil, true},
+		{[]string{"set a 0x10000"}, nil, true},
+		{[]string{"set a"}, nil, true},
+
+		{[]string{"continue", "step"}, []string{"Program is not running."}, false},
+		{[]string{"continue", "reset", "regs"}, []string{"a 0000", "pc 0000"}, false},
+		{[]string{"bogus"}, nil, true},
+	} {
+		d, buf := newTestDebugger(t)
+
+		var err error
+		for _, cmd := range tt.cmds {
+			buf.Reset()
+
+			if err = Handle(d, strings.Fields(cmd)); err != nil {
+				break
+			}
+		}
+
+		if (err != nil) != tt.err {
+			t.Fatalf("%q: want error %v, got %v", tt.cmds, tt.err, err)
+		}
+
+		for _, want := range tt.want {
+			if !strings.Contains(buf.String(), want) {
+				t.Fatalf("%q: want %q in output:\n%s", tt.cmds, want, buf.String())
+			}
+		}
+	}
+}
+
+func TestHandleQuit(t *testing.T) {
+	d, _ := newTestDebugger(t)
+
+	if err := Handle(d, []string{"quit"}); err != ErrQuit {
+		t.Fatalf("Want ErrQuit, got %v", err)
+	}
+}
+
+func TestParseCommand(t *testing.T) {
+	last := []string{"step", "2"}
+
+	for _, tt := range []struct {
+		line string
+		last []string
+		want []string
+	}{
+		{"regs", nil, []string{"regs"}},
+		{"  mem  data 4 ", nil, []string{"mem", "data", "4"}},
+		{"", last, last},
+		{"   ", last, last},
+		{"", nil, nil},
+		{"next", last, []string{"next"}},
+	} {
+		have := parseCommand(tt.line, tt.last)
+
+		if strings.Join(have, " ") != strings.Join(tt.want, " ") ||
+			(have == nil) != (tt.want == nil) {
+			t.Fatalf("%q: want %q, got %q", tt.line, tt.want, have)
+		}
+	}
+}
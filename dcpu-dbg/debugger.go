@@ -0,0 +1,380 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"github.com/jteeuwen/dcpu/cpu"
+	"github.com/jteeuwen/dcpu/disasm"
+	"io"
+	"os"
+	"os/signal"
+	"sort"
+)
+
+// Frame describes a single entry in the call stack.
+type Frame struct {
+	Caller cpu.Word // Address of the JSR instruction.
+	Target cpu.Word // Address of the called code.
+	SP     cpu.Word // Stack pointer before the call.
+}
+
+// Debugger runs a program under user control.
+type Debugger struct {
+	cpu         *cpu.CPU
+	sym         *Symbols
+	program     []cpu.Word
+	breakpoints []cpu.Word            // Sorted list of breakpoint addresses.
+	watchpoints map[cpu.Word]cpu.Word // Watched addresses and their last known value.
+	callstack   []Frame               // Active function calls.
+	stacks      [][]Frame             // Call stacks for undoable instructions.
+	history     int                   // Maximum number of undoable instructions.
+	call        *Frame                // JSR being executed in the current step.
+	halted      bool                  // Has the program stopped?
+	out         io.Writer             // Receives all output.
+}
+
+// NewDebugger creates a debugger for the given program.
+// It allows up to history instructions to be undone.
+func NewDebugger(program []cpu.Word, sym *Symbols, history int) *Debugger {
+	d := new(Debugger)
+	d.sym = sym
+	d.program = program
+	d.history = history
+	d.watchpoints = make(map[cpu.Word]cpu.Word)
+	d.out = os.Stdout
+	d.Reset()
+	return d
+}
+
+// Reset reloads the program and clears all CPU state.
+// Breakpoints and watchpoints are retained.
+func (d *Debugger) Reset() {
+	d.cpu = cpu.New()
+	d.cpu.Trace = d.trace
+	d.cpu.EnableHistory(d.history)
+	d.callstack = nil
+	d.stacks = nil
+	d.halted = false
+
+	copy(d.cpu.Store.Mem[:], d.program)
+
+	for addr := range d.watchpoints {
+		d.watchpoints[addr] = d.cpu.Store.Mem[addr]
+	}
+}
+
+// Save writes a snapshot of the machine state to the given file.
+func (d *Debugger) Save(file string) (err error) {
+	fd, err := os.Create(file)
+	if err != nil {
+		return
+	}
+
+	defer fd.Close()
+	return d.cpu.Snapshot(fd)
+}
+
+// Load restores the machine state from the given snapshot file.
+// The call stack is not part of a snapshot and is cleared.
+func (d *Debugger) Load(file string) (err error) {
+	fd, err := os.Open(file)
+	if err != nil {
+		return
+	}
+
+	defer fd.Close()
+
+	if err = d.cpu.Restore(fd); err != nil {
+		return
+	}
+
+	d.callstack = nil
+	d.stacks = nil
+	d.halted = false
+
+	for addr := range d.watchpoints {
+		d.watchpoints[addr] = d.cpu.Store.Mem[addr]
+	}
+
+	return
+}
+
+// trace records function calls as they are executed.
+func (d *Debugger) trace(pc, op, a, b cpu.Word, s *cpu.Storage) {
+	if op == cpu.EXT && a == cpu.JSR {
+		d.call = &Frame{Caller: pc, SP: s.SP}
+	}
+}
+
+// updateCallstack updates the call stack after an instruction
+// has been executed.
+//
+// The call stack is never modified in place. A changed stack is
+// stored in a new slice, so older versions can be kept in the history.
+func (d *Debugger) updateCallstack() {
+	s := d.cpu.Store
+	n := len(d.callstack)
+
+	// A frame is done once its return address has been popped
+	// off the stack.
+	for n > 0 && s.SP >= d.callstack[n-1].SP {
+		n--
+	}
+
+	if n == len(d.callstack) && d.call == nil {
+		return
+	}
+
+	stack := make([]Frame, n, n+1)
+	copy(stack, d.callstack)
+
+	if d.call != nil {
+		d.call.Target = s.PC
+		stack = append(stack, *d.call)
+		d.call = nil
+	}
+
+	d.callstack = stack
+}
+
+// Step executes a single instruction. It returns false if execution
+// should not continue. This happens when the program stops, or a
+// watchpoint fires.
+func (d *Debugger) Step() bool {
+	if d.halted {
+		fmt.Fprintln(d.out, "[*] Program is not running. Use 'reset' to restart it.")
+		return false
+	}
+
+	if d.history > 0 {
+		if len(d.stacks) >= d.history {
+			d.stacks = d.stacks[1:]
+		}
+
+		d.stacks = append(d.stacks, d.callstack)
+	}
+
+	pc := d.cpu.Store.PC
+	err := d.cpu.Step()
+	d.updateCallstack()
+
+	if err != nil {
+		d.halted = true
+
+		switch tt := err.(type) {
+		case *cpu.TestError:
+			fmt.Fprintf(d.out, "[E] Panic at %s: %s\n", d.describe(tt.PC), tt.Msg)
+		default:
+			if err == io.EOF {
+				fmt.Fprintf(d.out, "[*] Program exited at %s.\n", d.describe(pc))
+			} else {
+				fmt.Fprintf(d.out, "[E] %v\n", err)
+			}
+		}
+
+		return false
+	}
+
+	return d.checkWatchpoints(pc)
+}
+
+// StepBack undoes the last instruction. It returns false if reverse
+// execution should not continue. This happens when the history runs
+// out, or a watchpoint fires.
+func (d *Debugger) StepBack() bool {
+	if d.cpu.StepBack(1) == 0 {
+		fmt.Fprintln(d.out, "[*] No more history.")
+		return false
+	}
+
+	n := len(d.stacks) - 1
+	d.callstack = d.stacks[n]
+	d.stacks = d.stacks[:n]
+	d.halted = false
+
+	return d.checkWatchpoints(d.cpu.Store.PC)
+}
+
+// checkWatchpoints reports changes to watched memory.
+// It returns false if any of them changed.
+func (d *Debugger) checkWatchpoints(pc cpu.Word) bool {
+	ok := true
+
+	for _, addr := range d.Watchpoints() {
+		old := d.watchpoints[addr]
+		v := d.cpu.Store.Mem[addr]
+
+		if v != old {
+			fmt.Fprintf(d.out, "[*] Watchpoint %04x: %04x -> %04x, by %s\n",
+				addr, old, v, d.describe(pc))
+			d.watchpoints[addr] = v
+			ok = false
+		}
+	}
+
+	return ok
+}
+
+// Run keeps executing instructions until the program stops, a
+// breakpoint or watchpoint is hit, or the given function returns true.
+// It can be interrupted with ctrl-C.
+func (d *Debugger) Run(done func() bool) {
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt)
+	defer signal.Stop(sig)
+
+	for {
+		if !d.Step() {
+			return
+		}
+
+		if done != nil && done() {
+			return
+		}
+
+		if d.HasBreakpoint(d.cpu.Store.PC) {
+			fmt.Fprintf(d.out, "[*] Breakpoint at %s\n", d.describe(d.cpu.Store.PC))
+			return
+		}
+
+		select {
+		case <-sig:
+			fmt.Fprintln(d.out, "[*] Interrupted.")
+			return
+		default:
+		}
+	}
+}
+
+// RunBack keeps undoing instructions until the history runs out, or
+// a breakpoint or watchpoint is hit. It can be interrupted with ctrl-C.
+func (d *Debugger) RunBack() {
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt)
+	defer signal.Stop(sig)
+
+	for d.StepBack() {
+		if d.HasBreakpoint(d.cpu.Store.PC) {
+			fmt.Fprintf(d.out, "[*] Breakpoint at %s\n", d.describe(d.cpu.Store.PC))
+			return
+		}
+
+		select {
+		case <-sig:
+			fmt.Fprintln(d.out, "[*] Interrupted.")
+			return
+		default:
+		}
+	}
+}
+
+// StepOver executes the current instruction. If it is a function call,
+// this runs until the function returns.
+func (d *Debugger) StepOver() {
+	op, a, _ := cpu.Decode(d.cpu.Store.Mem[d.cpu.Store.PC])
+
+	if op != cpu.EXT || a != cpu.JSR {
+		d.Step()
+		return
+	}
+
+	depth := len(d.callstack)
+	d.Run(func() bool { return len(d.callstack) <= depth })
+}
+
+// StepOut runs until the current function returns.
+func (d *Debugger) StepOut() {
+	depth := len(d.callstack)
+
+	if depth == 0 {
+		fmt.Fprintln(d.out, "[*] Not inside a function call.")
+		return
+	}
+
+	d.Run(func() bool { return len(d.callstack) < depth })
+}
+
+// AddBreakpoint sets a breakpoint at the given address.
+func (d *Debugger) AddBreakpoint(addr cpu.Word) {
+	if !d.HasBreakpoint(addr) {
+		d.breakpoints = append(d.breakpoints, addr)
+		sort.Sort(wordList(d.breakpoints))
+	}
+}
+
+// RemoveBreakpoint removes the breakpoint at the given address.
+func (d *Debugger) RemoveBreakpoint(addr cpu.Word) bool {
+	for i := range d.breakpoints {
+		if d.breakpoints[i] == addr {
+			copy(d.breakpoints[i:], d.breakpoints[i+1:])
+			d.breakpoints = d.breakpoints[:len(d.breakpoints)-1]
+			return true
+		}
+	}
+
+	return false
+}
+
+// HasBreakpoint returns true if there is a breakpoint at the given address.
+func (d *Debugger) HasBreakpoint(addr cpu.Word) bool {
+	for _, bp := range d.breakpoints {
+		if bp == addr {
+			return true
+		}
+	}
+	return false
+}
+
+// AddWatchpoint watches the memory word at the given address.
+func (d *Debugger) AddWatchpoint(addr cpu.Word) {
+	d.watchpoints[addr] = d.cpu.Store.Mem[addr]
+}
+
+// RemoveWatchpoint stops watching the given address.
+func (d *Debugger) RemoveWatchpoint(addr cpu.Word) bool {
+	_, ok := d.watchpoints[addr]
+	delete(d.watchpoints, addr)
+	return ok
+}
+
+// Watchpoints returns the sorted list of watched addresses.
+func (d *Debugger) Watchpoints() []cpu.Word {
+	list := make([]cpu.Word, 0, len(d.watchpoints))
+
+	for addr := range d.watchpoints {
+		list = append(list, addr)
+	}
+
+	sort.Sort(wordList(list))
+	return list
+}
+
+// Instruction returns the disassembled instruction at the given address.
+func (d *Debugger) Instruction(addr cpu.Word) disasm.Instruction {
+	return disasm.Decode(d.cpu.Store.Mem[:], addr)
+}
+
+// describe returns the symbolic name and source location of
+// the given address.
+func (d *Debugger) describe(addr cpu.Word) string {
+	s := fmt.Sprintf("%04x", addr)
+
+	if sym := d.sym.Symbol(addr); len(sym) > 0 {
+		s += " " + sym
+	}
+
+	if loc := d.sym.Location(addr); len(loc) > 0 {
+		s += " (" + loc + ")"
+	}
+
+	return s
+}
+
+// wordList sorts a list of words.
+type wordList []cpu.Word
+
+func (w wordList) Len() int           { return len(w) }
+func (w wordList) Less(i, j int) bool { return w[i] < w[j] }
+func (w wordList) Swap(i, j int)      { w[i], w[j] = w[j], w[i] }
@@ -0,0 +1,58 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"io"
+	"strings"
+)
+
+// pollInput polls for commandline input.
+// Commands are sent over the returned channel.
+func pollInput(in io.Reader) <-chan []string {
+	c := make(chan []string)
+
+	go func() {
+		var last []string
+
+		defer close(c)
+
+		r := bufio.NewReader(in)
+
+		for {
+			line, _, err := r.ReadLine()
+			if err != nil {
+				return
+			}
+
+			if cmd := parseCommand(string(line), last); cmd != nil {
+				last = cmd
+				c <- cmd
+			}
+		}
+
+	}()
+
+	return c
+}
+
+// parseCommand splits the given line into a command and its arguments.
+//
+// An empty line repeats the previous command. This makes
+// stepping through code less tedious. Returns nil if there
+// is nothing to do.
+func parseCommand(line string, last []string) []string {
+	cmd := strings.Fields(line)
+
+	if len(cmd) == 0 {
+		if len(last) == 0 {
+			return nil
+		}
+
+		cmd = last
+	}
+
+	return cmd
+}
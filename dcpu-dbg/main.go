@@ -0,0 +1,168 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+// This tool is an interactive, source-level debugger for DCPU programs.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var (
+	debugfile    = flag.String("d", "", "Path to debug symbol file for binary input, as generated by dcpu-asm -d.")
+	littleendian = flag.Bool("l", false, "Input binary is Little Endian. Defaults to Big Endian.")
+	history      = flag.Int("history", DefaultHistory, "Number of instructions which can be undone. Zero disables reverse execution.")
+)
+
+func main() {
+	d := parseArgs()
+
+	fmt.Printf("%s\n", Version())
+	fmt.Printf("Type 'quit' to exit or 'help' for help.\n")
+	where(d)
+
+	input := pollInput(os.Stdin)
+
+	for {
+		select {
+		case cmd, ok := <-input:
+			if !ok {
+				return
+			}
+
+			if err := Handle(d, cmd); err == ErrQuit {
+				return
+			} else if err != nil {
+				fmt.Fprintf(os.Stderr, "[E] %v\n", err)
+			}
+		}
+	}
+}
+
+func parseArgs() *Debugger {
+	var include string
+
+	version := flag.Bool("v", false, "Display version information.")
+	flag.StringVar(&include, "i", "", "Colon-separated list of additional include paths.")
+
+	flag.Usage = func() {
+		fmt.Printf("Usage: %s [options] <file>\n\n", os.Args[0])
+		flag.PrintDefaults()
+		fmt.Println()
+		usage(os.Stdout)
+	}
+
+	flag.Parse()
+
+	if *version {
+		fmt.Printf("%s\n", Version())
+		os.Exit(0)
+	}
+
+	if flag.NArg() == 0 {
+		fmt.Fprintln(os.Stderr, "No input file.")
+		os.Exit(1)
+	}
+
+	input := filepath.Clean(flag.Arg(0))
+	dir, _ := filepath.Split(input)
+	includes := []string{filepath.Clean(dir)}
+
+	if len(include) > 0 {
+		for _, v := range strings.Split(include, ":") {
+			includes = append(includes, filepath.Clean(v))
+		}
+	}
+
+	program, dbg, err := LoadProgram(input, *debugfile, includes, *littleendian)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+
+	return NewDebugger(program, NewSymbols(dbg), *history)
+}
+
+func usage(w io.Writer) {
+	fmt.Fprint(w, `List of known commands. Short forms are listed in parentheses.
+An empty line repeats the previous command.
+
+Locations can be addresses (0x1f), label or function names (main),
+or source lines (main.dasm:12).
+
+ step (s) [N]
+   Execute the next N instructions. N defaults to 1.
+
+ back (bk) [N]
+   Undo the last N instructions. N defaults to 1.
+
+ rcontinue (rc)
+   Run backwards until the start of the recorded history, or until
+   a breakpoint or watchpoint is hit. This finds the instruction
+   which last changed a watched memory word.
+
+ next (n)
+   Execute the next instruction. If it is a JSR, run until the
+   called function returns.
+
+ out (o)
+   Run until the current function returns.
+
+ continue (c)
+   Run until the program stops, or a breakpoint or watchpoint is hit.
+   Press ctrl-C to interrupt a running program.
+
+ break (b) [location]
+   Set a breakpoint at the given location. Without arguments,
+   this lists all breakpoints.
+
+ delete (d) <location|all>
+   Remove the breakpoint at the given location, or all of them.
+
+ watch (w) [location]
+   Stop whenever the memory word at the given location changes.
+   Without arguments, this lists all watchpoints.
+
+ unwatch <location|all>
+   Remove the watchpoint at the given location, or all of them.
+
+ where
+   Show the next instruction to be executed.
+
+ list (l) [location [N]]
+   Show N instructions starting at the given location. This defaults
+   to 8 instructions at the current PC.
+
+ stack (bt)
+   Show the call stack.
+
+ regs (r)
+   Show register contents.
+
+ mem (m) <location> [N]
+   Show N words of memory starting at the given location.
+   N defaults to 8.
+
+ set <register|[location]> <value>
+   Change the contents of a register or memory word. The value can be
+   a number or a location. For example: 'set a 0x10' or 'set [data] -1'.
+
+ save <file>
+   Write a snapshot of the machine state to the given file.
+
+ load <file>
+   Restore the machine state from a snapshot file. Breakpoints
+   and watchpoints are retained. The call stack is cleared.
+
+ reset
+   Restart the program. Breakpoints and watchpoints are retained.
+
+ quit (q)
+   Exit the debugger.
+`)
+}
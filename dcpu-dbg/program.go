@@ -0,0 +1,98 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/jteeuwen/dcpu/asm"
+	"github.com/jteeuwen/dcpu/cpu"
+	dp "github.com/jteeuwen/dcpu/parser"
+	"github.com/jteeuwen/dcpu/parser/util"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+)
+
+// LoadProgram loads the given file. Files with the `.dasm` extension
+// are assembled. Anything else is treated as a binary, as generated
+// by dcpu-asm. Its debug symbols are read from the given debug file.
+func LoadProgram(file, debugfile string, includes []string, little_endian bool) ([]cpu.Word, *asm.DebugInfo, error) {
+	var program []cpu.Word
+	var dbg *asm.DebugInfo
+
+	if strings.ToLower(filepath.Ext(file)) == ".dasm" {
+		var ast dp.AST
+
+		err := util.ReadSource(&ast, file, includes)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if program, dbg, err = asm.Assemble(&ast); err != nil {
+			return nil, nil, err
+		}
+	} else {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		program = ReadProgram(data, little_endian)
+
+		if len(debugfile) > 0 {
+			if dbg, err = ReadDebug(debugfile); err != nil {
+				return nil, nil, err
+			}
+		}
+	}
+
+	if len(program) > cpu.MemSize {
+		return nil, nil, errors.New(fmt.Sprintf(
+			"%s: Program size of %d words exceeds available memory.", file, len(program)))
+	}
+
+	if dbg == nil {
+		dbg = new(asm.DebugInfo)
+	}
+
+	return program, dbg, nil
+}
+
+// ReadDebug loads the debug symbols from the given file,
+// as generated by dcpu-asm -d.
+func ReadDebug(file string) (*asm.DebugInfo, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	dbg := new(asm.DebugInfo)
+	return dbg, json.Unmarshal(data, dbg)
+}
+
+// ReadProgram turns the given binary data into a program.
+// This is the inverse of what dcpu-asm writes.
+//
+// An odd number of bytes is padded with a zero byte.
+func ReadProgram(data []byte, little_endian bool) []cpu.Word {
+	if len(data)%2 != 0 {
+		data = append(data, 0)
+	}
+
+	program := make([]cpu.Word, len(data)/2)
+
+	for i := range program {
+		a, b := data[i*2], data[i*2+1]
+
+		if little_endian {
+			a, b = b, a
+		}
+
+		program[i] = cpu.Word(a)<<8 | cpu.Word(b)
+	}
+
+	return program
+}
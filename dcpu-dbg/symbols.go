@@ -0,0 +1,196 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/jteeuwen/dcpu/asm"
+	"github.com/jteeuwen/dcpu/cpu"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Symbols resolves addresses to names and source locations,
+// and vice versa. It uses the debug symbols generated by the assembler.
+type Symbols struct {
+	dbg     *asm.DebugInfo
+	labels  map[cpu.Word]string // Label name per address.
+	sources map[string][]string // Cache of source file lines.
+}
+
+// NewSymbols creates a new symbol table.
+func NewSymbols(dbg *asm.DebugInfo) *Symbols {
+	s := new(Symbols)
+	s.dbg = dbg
+	s.labels = make(map[cpu.Word]string)
+	s.sources = make(map[string][]string)
+
+	for _, f := range dbg.Functions {
+		s.labels[f.StartAddr] = f.Name
+	}
+
+	for _, l := range dbg.Labels {
+		if _, ok := s.labels[l.Addr]; !ok {
+			s.labels[l.Addr] = l.Name
+		}
+	}
+
+	return s
+}
+
+// Labels returns label names indexed by address.
+func (s *Symbols) Labels() map[cpu.Word]string { return s.labels }
+
+// Resolve turns the given location into an address.
+// The location can be one of:
+//
+//   - A number: 0x1f, 31
+//   - A label or function name: main
+//   - A source file and line: main.dasm:12
+//
+// Source locations resolve to the first instruction generated for that
+// line. If the line yields no code, the next line which does is used.
+func (s *Symbols) Resolve(loc string) (cpu.Word, error) {
+	if n, err := strconv.ParseUint(loc, 0, 16); err == nil {
+		return cpu.Word(n), nil
+	}
+
+	for _, f := range s.dbg.Functions {
+		if f.Name == loc {
+			return f.StartAddr, nil
+		}
+	}
+
+	for _, l := range s.dbg.Labels {
+		if l.Name == loc {
+			return l.Addr, nil
+		}
+	}
+
+	if n := strings.LastIndex(loc, ":"); n > -1 {
+		line, err := strconv.Atoi(loc[n+1:])
+		if err == nil {
+			return s.resolveLine(loc[:n], line)
+		}
+	}
+
+	return 0, errors.New(fmt.Sprintf("Unknown location %q.", loc))
+}
+
+// resolveLine finds the address of the first instruction generated
+// for the given file and line.
+func (s *Symbols) resolveLine(file string, line int) (cpu.Word, error) {
+	var found bool
+	var addr cpu.Word
+
+	best := -1
+
+	for i, sym := range s.dbg.SourceMapping {
+		if sym.File >= len(s.dbg.Files) || sym.Line < line {
+			continue
+		}
+
+		if !matchFile(s.dbg.Files[sym.File].Name, file) {
+			continue
+		}
+
+		if !found || sym.Line < best {
+			found, best, addr = true, sym.Line, cpu.Word(i)
+		}
+	}
+
+	if !found {
+		return 0, errors.New(fmt.Sprintf("No code found at %s:%d.", file, line))
+	}
+
+	return addr, nil
+}
+
+// matchFile returns true if the given file name matches the path.
+// This accepts the full path, or any trailing part of it.
+func matchFile(path, name string) bool {
+	path = filepath.ToSlash(filepath.Clean(path))
+	name = filepath.ToSlash(filepath.Clean(name))
+	return path == name || strings.HasSuffix(path, "/"+name)
+}
+
+// Symbol returns the name of the function or label which holds
+// the given address. For instance: `memchr+3`. This is an empty
+// string if there is no such label.
+func (s *Symbols) Symbol(addr cpu.Word) string {
+	for _, f := range s.dbg.Functions {
+		if addr >= f.StartAddr && addr < f.EndAddr {
+			return offset(f.Name, addr-f.StartAddr)
+		}
+	}
+
+	// Labels are sorted by address. Find the last one before addr.
+	for i := len(s.dbg.Labels) - 1; i >= 0; i-- {
+		if l := s.dbg.Labels[i]; l.Addr <= addr {
+			return offset(l.Name, addr-l.Addr)
+		}
+	}
+
+	return ""
+}
+
+// offset formats a name with an optional offset.
+func offset(name string, off cpu.Word) string {
+	if off == 0 {
+		return name
+	}
+	return fmt.Sprintf("%s+%d", name, off)
+}
+
+// Location returns the source file and line for the given address.
+// This is an empty string if it is unknown.
+func (s *Symbols) Location(addr cpu.Word) string {
+	if int(addr) >= len(s.dbg.SourceMapping) {
+		return ""
+	}
+
+	sym := s.dbg.SourceMapping[addr]
+	if sym.File >= len(s.dbg.Files) {
+		return ""
+	}
+
+	_, name := filepath.Split(s.dbg.Files[sym.File].Name)
+	return fmt.Sprintf("%s:%d", name, sym.Line)
+}
+
+// Source returns the trimmed line of original source code for the
+// given address, if it is available.
+func (s *Symbols) Source(addr cpu.Word) string {
+	if int(addr) >= len(s.dbg.SourceMapping) {
+		return ""
+	}
+
+	sym := s.dbg.SourceMapping[addr]
+	if sym.File >= len(s.dbg.Files) {
+		return ""
+	}
+
+	file := s.dbg.Files[sym.File].Name
+	lines, ok := s.sources[file]
+
+	if !ok {
+		if data, err := ioutil.ReadFile(file); err == nil {
+			for _, line := range bytes.Split(data, []byte{'\n'}) {
+				lines = append(lines, string(line))
+			}
+		}
+
+		s.sources[file] = lines
+	}
+
+	if sym.Line < 1 || sym.Line > len(lines) {
+		return ""
+	}
+
+	return strings.TrimSpace(lines[sym.Line-1])
+}
@@ -0,0 +1,111 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+// This tool turns compiled DCPU programs back into assembly source.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/jteeuwen/dcpu/asm"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+var (
+	input        io.ReadCloser
+	output       io.WriteCloser
+	debugfile    = flag.String("d", "", "Path to debug symbol file, as generated by dcpu-asm -d.")
+	littleendian = flag.Bool("l", false, "Input is Little Endian. Defaults to Big Endian.")
+	annotate     = flag.Bool("a", false, "Annotate instructions with their address and encoding.")
+)
+
+func main() {
+	parseArgs()
+
+	defer input.Close()
+	defer output.Close()
+
+	data, err := ioutil.ReadAll(input)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Input: %v\n", err)
+		os.Exit(1)
+	}
+
+	program := ReadProgram(data, *littleendian)
+
+	dbg, err := readDebug(*debugfile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Debug symbols: %v\n", err)
+		os.Exit(1)
+	}
+
+	err = NewSourceWriter(output, program, dbg).Write(*annotate)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Source writer: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// readDebug loads the debug symbols from the given file.
+// This returns nil if no file has been specified.
+func readDebug(file string) (*asm.DebugInfo, error) {
+	if len(file) == 0 {
+		return nil, nil
+	}
+
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	dbg := new(asm.DebugInfo)
+	return dbg, json.Unmarshal(data, dbg)
+}
+
+func parseArgs() {
+	flag.Usage = func() {
+		fmt.Printf("Usage: %s [options] <file>\n", os.Args[0])
+		fmt.Printf("   or: cat <file> | %s [options]\n\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+
+	outfile := flag.String("o", "", "Path to output file. Defaults to stdout.")
+	version := flag.Bool("v", false, "Display version information.")
+
+	flag.Parse()
+
+	if *version {
+		fmt.Printf("%s\n", Version())
+		os.Exit(0)
+	}
+
+	// See if have an input file. If not, read data from stdin.
+	if flag.NArg() == 0 {
+		input = os.Stdin
+	} else {
+		fd, err := os.Open(filepath.Clean(flag.Arg(0)))
+
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Input path: %v\n", err)
+			os.Exit(1)
+		}
+
+		input = fd
+	}
+
+	if len(*outfile) == 0 {
+		output = os.Stdout
+	} else {
+		var err error
+		output, err = os.Create(*outfile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Output path: %v\n", err)
+			input.Close()
+			os.Exit(1)
+		}
+	}
+}
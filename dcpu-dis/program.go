@@ -0,0 +1,30 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package main
+
+import "github.com/jteeuwen/dcpu/cpu"
+
+// ReadProgram turns the given binary data into a program.
+// This is the inverse of what dcpu-asm writes.
+//
+// An odd number of bytes is padded with a zero byte.
+func ReadProgram(data []byte, little_endian bool) []cpu.Word {
+	if len(data)%2 != 0 {
+		data = append(data, 0)
+	}
+
+	program := make([]cpu.Word, len(data)/2)
+
+	for i := range program {
+		a, b := data[i*2], data[i*2+1]
+
+		if little_endian {
+			a, b = b, a
+		}
+
+		program[i] = cpu.Word(a)<<8 | cpu.Word(b)
+	}
+
+	return program
+}
@@ -0,0 +1,319 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/jteeuwen/dcpu/asm"
+	"github.com/jteeuwen/dcpu/cpu"
+	"github.com/jteeuwen/dcpu/disasm"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+)
+
+// Maximum number of words to write per `dat` line.
+const WordsPerLine = 8
+
+// SourceWriter writes a disassembled program as assembly source.
+type SourceWriter struct {
+	w       io.Writer
+	program []cpu.Word
+	dbg     *asm.DebugInfo
+	labels  map[cpu.Word]string   // Label name to use for references.
+	defs    map[cpu.Word][]string // All label definitions per address.
+	sources map[string][][]byte   // Cache of source file lines.
+}
+
+// NewSourceWriter creates a new writer for the given program.
+// The debug symbols are optional.
+func NewSourceWriter(w io.Writer, program []cpu.Word, dbg *asm.DebugInfo) *SourceWriter {
+	sw := new(SourceWriter)
+	sw.w = w
+	sw.program = program
+	sw.dbg = dbg
+	sw.labels = make(map[cpu.Word]string)
+	sw.defs = make(map[cpu.Word][]string)
+	sw.sources = make(map[string][][]byte)
+
+	if dbg != nil {
+		sw.loadLabels()
+	}
+
+	return sw
+}
+
+// loadLabels collects label and function names from the debug symbols.
+// Names which are not valid label names in source, are renamed.
+func (sw *SourceWriter) loadLabels() {
+	used := make(map[string]bool)
+
+	add := func(name string, addr cpu.Word) {
+		base := sanitize(name)
+		name = base
+
+		for n := 1; used[name]; n++ {
+			name = fmt.Sprintf("%s_%d", base, n)
+		}
+
+		used[name] = true
+		sw.defs[addr] = append(sw.defs[addr], name)
+
+		if _, ok := sw.labels[addr]; !ok {
+			sw.labels[addr] = name
+		}
+	}
+
+	for _, f := range sw.dbg.Functions {
+		if !hasDef(sw.dbg.Labels, f.Name, f.StartAddr) {
+			add(f.Name, f.StartAddr)
+		}
+	}
+
+	for _, l := range sw.dbg.Labels {
+		add(l.Name, l.Addr)
+	}
+}
+
+// Write writes the program as assembly source.
+//
+// If annotate is true, each instruction is followed by a comment
+// holding its address and encoding.
+func (sw *SourceWriter) Write(annotate bool) (err error) {
+	var data []cpu.Word
+
+	// flush writes pending data words.
+	flush := func() {
+		for len(data) > 0 && err == nil {
+			n := len(data)
+			if n > WordsPerLine {
+				n = WordsPerLine
+			}
+
+			words := make([]string, n)
+			for i := range words {
+				words[i] = fmt.Sprintf("0x%04x", data[i])
+			}
+
+			_, err = fmt.Fprintf(sw.w, "   dat %s\n", strings.Join(words, ", "))
+			data = data[n:]
+		}
+	}
+
+	list := sw.layout()
+
+	for i := range list {
+		if err = sw.writeLabels(list[i].Addr, flush); err != nil {
+			return
+		}
+
+		// Invalid instructions and those which the assembler would
+		// encode differently, are written as data. This preserves
+		// the program layout.
+		if !list[i].Valid() || !list[i].Canonical(sw.labels) {
+			data = append(data, list[i].Words...)
+
+			if len(data) >= WordsPerLine {
+				flush()
+			}
+			continue
+		}
+
+		flush()
+
+		if err = sw.writeInstruction(&list[i], annotate); err != nil {
+			return
+		}
+	}
+
+	if err = sw.writeLabels(cpu.Word(len(sw.program)), flush); err != nil {
+		return
+	}
+
+	flush()
+	return
+}
+
+// writeLabels writes the label definitions for the given address.
+func (sw *SourceWriter) writeLabels(addr cpu.Word, flush func()) (err error) {
+	names, ok := sw.defs[addr]
+	if !ok {
+		return
+	}
+
+	flush()
+
+	for _, name := range names {
+		if _, err = fmt.Fprintf(sw.w, "\n:%s\n", name); err != nil {
+			return
+		}
+	}
+
+	return
+}
+
+// layout decodes the program into a list of instructions. Data words
+// are returned as invalid, single word instructions.
+//
+// Instructions which have a label pointing into the middle of them, are
+// treated as data. This ensures all labels can be defined in the output.
+// Labels beyond the end of the program are discarded.
+func (sw *SourceWriter) layout() []disasm.Instruction {
+	var list []disasm.Instruction
+
+	for addr := 0; addr < len(sw.program); {
+		instr := disasm.Decode(sw.program, cpu.Word(addr))
+
+		if sw.isData(instr.Addr) || sw.hasLabelWithin(&instr) {
+			instr = disasm.Instruction{
+				Addr:  instr.Addr,
+				Size:  1,
+				Words: sw.program[addr : addr+1],
+			}
+		}
+
+		list = append(list, instr)
+		addr += int(instr.Size)
+	}
+
+	for addr := range sw.labels {
+		if int(addr) > len(sw.program) {
+			delete(sw.labels, addr)
+			delete(sw.defs, addr)
+		}
+	}
+
+	return list
+}
+
+// hasLabelWithin returns true if any label points into the given
+// instruction, excluding its first word.
+func (sw *SourceWriter) hasLabelWithin(instr *disasm.Instruction) bool {
+	for addr := instr.Addr + 1; addr < instr.Addr+instr.Size; addr++ {
+		if _, ok := sw.defs[addr]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// writeInstruction writes a single instruction along with an optional
+// comment holding its address, encoding and original source.
+func (sw *SourceWriter) writeInstruction(instr *disasm.Instruction, annotate bool) (err error) {
+	var comment []string
+
+	if annotate {
+		words := make([]string, len(instr.Words))
+		for i := range words {
+			words[i] = fmt.Sprintf("%04x", instr.Words[i])
+		}
+
+		comment = append(comment, fmt.Sprintf("%04x: %s", instr.Addr, strings.Join(words, " ")))
+	}
+
+	if line := sw.sourceLine(instr.Addr); len(line) > 0 {
+		comment = append(comment, line)
+	}
+
+	if len(comment) == 0 {
+		_, err = fmt.Fprintf(sw.w, "   %s\n", instr.Format(sw.labels))
+		return
+	}
+
+	_, err = fmt.Fprintf(sw.w, "   %-30s ; %s\n",
+		instr.Format(sw.labels), strings.Join(comment, " | "))
+	return
+}
+
+// isData returns true if the original source for the given address
+// is a data section.
+func (sw *SourceWriter) isData(addr cpu.Word) bool {
+	line := bytes.ToLower(sw.source(addr))
+	return bytes.HasPrefix(line, []byte("dat ")) || bytes.HasPrefix(line, []byte("dat\t"))
+}
+
+// sourceLine returns the original source location and code for the
+// given address. It is formatted as `file:line | code`.
+func (sw *SourceWriter) sourceLine(addr cpu.Word) string {
+	if sw.dbg == nil || int(addr) >= len(sw.dbg.SourceMapping) {
+		return ""
+	}
+
+	sym := sw.dbg.SourceMapping[addr]
+	if sym.File >= len(sw.dbg.Files) {
+		return ""
+	}
+
+	_, name := filepath.Split(sw.dbg.Files[sym.File].Name)
+
+	if line := sw.source(addr); len(line) > 0 {
+		return fmt.Sprintf("%s:%d | %s", name, sym.Line, line)
+	}
+
+	return fmt.Sprintf("%s:%d", name, sym.Line)
+}
+
+// source returns the trimmed line of original source code for the
+// given address, if it is available.
+func (sw *SourceWriter) source(addr cpu.Word) []byte {
+	if sw.dbg == nil || int(addr) >= len(sw.dbg.SourceMapping) {
+		return nil
+	}
+
+	sym := sw.dbg.SourceMapping[addr]
+	if sym.File >= len(sw.dbg.Files) {
+		return nil
+	}
+
+	file := sw.dbg.Files[sym.File].Name
+	lines, ok := sw.sources[file]
+
+	if !ok {
+		if data, err := ioutil.ReadFile(file); err == nil {
+			lines = bytes.Split(data, []byte{'\n'})
+		}
+
+		sw.sources[file] = lines
+	}
+
+	if sym.Line < 1 || sym.Line > len(lines) {
+		return nil
+	}
+
+	return bytes.TrimSpace(lines[sym.Line-1])
+}
+
+// hasDef returns true if the given label is in the list.
+func hasDef(list []asm.LabelInfo, name string, addr cpu.Word) bool {
+	for i := range list {
+		if list[i].Name == name && list[i].Addr == addr {
+			return true
+		}
+	}
+	return false
+}
+
+// sanitize turns the given name into a valid label name.
+// Generated labels, like function epilogs, contain characters
+// the parser does not accept.
+func sanitize(name string) string {
+	b := []byte(name)
+
+	for i, c := range b {
+		switch {
+		case c == '_', c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z':
+		case c >= '0' && c <= '9' && i > 0:
+		default:
+			b[i] = '_'
+		}
+	}
+
+	if len(b) == 0 {
+		return "_"
+	}
+
+	return string(b)
+}
@@ -0,0 +1,126 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/jteeuwen/dcpu/cpu"
+	"github.com/jteeuwen/dcpu/cpu/hw/clock"
+	"github.com/jteeuwen/dcpu/cpu/hw/hmd2043"
+	"github.com/jteeuwen/dcpu/cpu/hw/hmu1440"
+	"github.com/jteeuwen/dcpu/cpu/hw/keyboard"
+	"github.com/jteeuwen/dcpu/cpu/hw/lem1802"
+	"github.com/jteeuwen/dcpu/cpu/hw/spc2000"
+	"sort"
+	"strings"
+)
+
+// A DeviceFunc creates a device builder from the optional argument
+// supplied in the -dev flag. For example: `hmd2043:disk.img`.
+type DeviceFunc func(arg string) (cpu.DeviceBuilder, error)
+
+// List of known devices, indexed by name.
+var devices = map[string]DeviceFunc{
+	"clock":    noArg(clock.New),
+	"hmd2043":  newHMD2043,
+	"keyboard": noArg(keyboard.New),
+	"lem1802":  noArg(lem1802.New),
+	"spc2000":  noArg(spc2000.New),
+}
+
+// List of disks opened for the devices. These are written back
+// to their files when the emulator exits.
+var disks []*hmu1440.HMU1440
+
+// ParseDevices turns the given comma-separated device list into
+// device builders. Devices are returned in the order in which
+// they are listed. This determines their hardware index.
+func ParseDevices(list string) ([]cpu.DeviceBuilder, error) {
+	var out []cpu.DeviceBuilder
+
+	if len(list) == 0 {
+		return nil, nil
+	}
+
+	for _, spec := range strings.Split(list, ",") {
+		var arg string
+
+		name := strings.TrimSpace(spec)
+
+		if n := strings.Index(name, ":"); n > -1 {
+			name, arg = name[:n], name[n+1:]
+		}
+
+		df, ok := devices[strings.ToLower(name)]
+		if !ok {
+			return nil, errors.New(fmt.Sprintf(
+				"Unknown device %q. Want one of: %s", name, DeviceNames()))
+		}
+
+		db, err := df(arg)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("%s: %v", name, err))
+		}
+
+		out = append(out, db)
+	}
+
+	return out, nil
+}
+
+// DeviceNames returns a comma-separated list of all known devices.
+func DeviceNames() string {
+	names := make([]string, 0, len(devices))
+
+	for name := range devices {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+// CloseDisks writes all open disks back to their files.
+func CloseDisks() (err error) {
+	for _, d := range disks {
+		if e := d.Close(); e != nil && err == nil {
+			err = e
+		}
+	}
+
+	disks = nil
+	return
+}
+
+// noArg wraps a device which takes no arguments.
+func noArg(db cpu.DeviceBuilder) DeviceFunc {
+	return func(arg string) (cpu.DeviceBuilder, error) {
+		if len(arg) > 0 {
+			return nil, errors.New("Device takes no arguments.")
+		}
+		return db, nil
+	}
+}
+
+// newHMD2043 creates a disk drive. If an argument is supplied,
+// it is the path to a disk image which is inserted into the drive.
+func newHMD2043(arg string) (cpu.DeviceBuilder, error) {
+	if len(arg) == 0 {
+		return hmd2043.New, nil
+	}
+
+	disk := hmu1440.New()
+	if err := disk.Open(arg); err != nil {
+		return nil, err
+	}
+
+	disks = append(disks, disk)
+
+	return func(f cpu.IntFunc) cpu.Device {
+		d := hmd2043.New(f)
+		d.(*hmd2043.HMD2043).Insert(disk)
+		return d
+	}, nil
+}
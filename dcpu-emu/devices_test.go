@@ -0,0 +1,92 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package main
+
+import (
+	"github.com/jteeuwen/dcpu/cpu"
+	"github.com/jteeuwen/dcpu/cpu/hw/clock"
+	"github.com/jteeuwen/dcpu/cpu/hw/hmd2043"
+	"github.com/jteeuwen/dcpu/cpu/hw/keyboard"
+	"github.com/jteeuwen/dcpu/cpu/hw/lem1802"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseDevices(t *testing.T) {
+	for _, tt := range []struct {
+		list string
+		want []cpu.DeviceBuilder
+	}{
+		{"", nil},
+		{"clock", []cpu.DeviceBuilder{clock.New}},
+		{"lem1802, keyboard ,CLOCK", []cpu.DeviceBuilder{lem1802.New, keyboard.New, clock.New}},
+		{"hmd2043", []cpu.DeviceBuilder{hmd2043.New}},
+
+		// Each duplicate is a separate device with its own index.
+		{"clock,keyboard,clock", []cpu.DeviceBuilder{clock.New, keyboard.New, clock.New}},
+	} {
+		have, err := ParseDevices(tt.list)
+		if err != nil {
+			t.Fatalf("%q: %v", tt.list, err)
+		}
+
+		if len(have) != len(tt.want) {
+			t.Fatalf("%q: want %d devices, got %d", tt.list, len(tt.want), len(have))
+		}
+
+		for i := range have {
+			a, b := have[i](nil), tt.want[i](nil)
+
+			if a.Id() != b.Id() || a.Manufacturer() != b.Manufacturer() {
+				t.Fatalf("%q: device %d mismatch: want %08x, got %08x",
+					tt.list, i, b.Id(), a.Id())
+			}
+		}
+	}
+}
+
+func TestParseDevicesErrors(t *testing.T) {
+	for _, list := range []string{
+		"floppy",
+		"clock,floppy",
+		"clock,,keyboard",
+		"clock:1",
+		"hmd2043:does-not-exist.img",
+	} {
+		if _, err := ParseDevices(list); err == nil {
+			t.Fatalf("%q: want error", list)
+		}
+	}
+}
+
+func TestParseDevicesDisk(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dcpu-emu")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "disk.img")
+	if err = ioutil.WriteFile(file, make([]byte, 1024), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	list, err := ParseDevices("hmd2043:" + file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer CloseDisks()
+
+	if len(list) != 1 || len(disks) != 1 {
+		t.Fatalf("Want 1 device and disk, got %d and %d", len(list), len(disks))
+	}
+
+	if _, ok := list[0](nil).(*hmd2043.HMD2043); !ok {
+		t.Fatalf("Want HMD2043 device")
+	}
+}
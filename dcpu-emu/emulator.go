@@ -0,0 +1,174 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"github.com/jteeuwen/dcpu/asm"
+	"github.com/jteeuwen/dcpu/cpu"
+	"io"
+	"os"
+	"time"
+)
+
+// Known exit states.
+const (
+	StateExit    = "exit"    // Program executed EXIT.
+	StatePanic   = "panic"   // Program executed PANIC.
+	StateError   = "error"   // The CPU returned an error.
+	StateCycles  = "cycles"  // Cycle limit was reached.
+	StateTimeout = "timeout" // Time limit was reached.
+)
+
+// Number of cycles to run between checks for the exit conditions.
+const BatchSize = 1000
+
+// Emulator runs a single program.
+type Emulator struct {
+	cpu   *cpu.CPU
+	dbg   *asm.DebugInfo // Debug symbols. This is nil for binaries.
+	state string         // Exit state.
+	err   error          // Error for the StatePanic and StateError states.
+}
+
+// NewEmulator creates a CPU with the given program and devices.
+func NewEmulator(program []cpu.Word, dbg *asm.DebugInfo, devices []cpu.DeviceBuilder) *Emulator {
+	e := new(Emulator)
+	e.dbg = dbg
+	e.cpu = cpu.New()
+
+	copy(e.cpu.Store.Mem[:], program)
+
+	for _, db := range devices {
+		e.cpu.RegisterDevice(db)
+	}
+
+	return e
+}
+
+// Run runs the program until it exits, fails or reaches one of the
+// given limits. A limit of zero means no limit.
+//
+// The clock speed determines the duration of a single cycle.
+// Zero runs the program as fast as possible.
+func (e *Emulator) Run(clock time.Duration, maxcycles uint64, timeout time.Duration) {
+	var deadline time.Time
+
+	if timeout > 0 {
+		deadline = time.Now().Add(timeout)
+	}
+
+	e.cpu.ClockSpeed = clock
+
+	for {
+		n := uint64(BatchSize)
+
+		if maxcycles > 0 {
+			if e.cpu.Cycles() >= maxcycles {
+				e.state = StateCycles
+				return
+			}
+
+			if left := maxcycles - e.cpu.Cycles(); left < n {
+				n = left
+			}
+		}
+
+		if timeout > 0 && time.Now().After(deadline) {
+			e.state = StateTimeout
+			return
+		}
+
+		if err := e.cpu.RunCycles(n); err != nil {
+			switch err.(type) {
+			case *cpu.TestError:
+				e.state = StatePanic
+			default:
+				if err == io.EOF {
+					e.state = StateExit
+					return
+				}
+
+				e.state = StateError
+			}
+
+			e.err = err
+			return
+		}
+	}
+}
+
+// Save writes a snapshot of the machine state to the given file.
+func (e *Emulator) Save(file string) (err error) {
+	fd, err := os.Create(file)
+	if err != nil {
+		return
+	}
+
+	defer fd.Close()
+	return e.cpu.Snapshot(fd)
+}
+
+// Restore loads the machine state from the given snapshot file.
+// This replaces the program loaded by NewEmulator.
+func (e *Emulator) Restore(file string) (err error) {
+	fd, err := os.Open(file)
+	if err != nil {
+		return
+	}
+
+	defer fd.Close()
+	return e.cpu.Restore(fd)
+}
+
+// Failed returns true if the program did not end with EXIT.
+func (e *Emulator) Failed() bool { return e.state != StateExit }
+
+// Report writes the exit state, cycle count and register contents.
+// Each value is written on its own line as `name value` pairs.
+// This makes the output easy to process by scripts.
+func (e *Emulator) Report(w io.Writer) {
+	s := e.cpu.Store
+
+	fmt.Fprintf(w, "state  %s\n", e.state)
+
+	if e.err != nil {
+		fmt.Fprintf(w, "error  %v\n", e.err)
+
+		if te, ok := e.err.(*cpu.TestError); ok {
+			fmt.Fprintf(w, "source %s\n", e.sourceLine(te.PC))
+		}
+	}
+
+	fmt.Fprintf(w, "cycles %d\n", e.cpu.Cycles())
+
+	regs := []struct {
+		name string
+		v    cpu.Word
+	}{
+		{"a", s.A}, {"b", s.B}, {"c", s.C}, {"x", s.X},
+		{"y", s.Y}, {"z", s.Z}, {"i", s.I}, {"j", s.J},
+		{"pc", s.PC}, {"sp", s.SP}, {"ex", s.EX}, {"ia", s.IA},
+	}
+
+	for _, r := range regs {
+		fmt.Fprintf(w, "%-6s %04x\n", r.name, r.v)
+	}
+}
+
+// sourceLine returns the original source location for the given
+// address. This yields the address itself if no debug symbols
+// are available.
+func (e *Emulator) sourceLine(pc cpu.Word) string {
+	if e.dbg == nil || int(pc) >= len(e.dbg.SourceMapping) {
+		return fmt.Sprintf("%04x", pc)
+	}
+
+	sym := e.dbg.SourceMapping[pc]
+	if sym.File >= len(e.dbg.Files) {
+		return fmt.Sprintf("%04x", pc)
+	}
+
+	return fmt.Sprintf("%s:%d", e.dbg.Files[sym.File].Name, sym.Line)
+}
@@ -0,0 +1,122 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+// This tool runs DCPU programs with a configurable set of devices.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/jteeuwen/dcpu/cpu"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+var (
+	input        string   // Input program.
+	includes     []string // List of paths where we look to resolve source file references.
+	devlist      = flag.String("dev", "", "Comma-separated list of devices to register. Use `name:arg` to supply an argument.")
+	clockspeed   = flag.Int64("c", int64(cpu.DefaultClockSpeed), "Duration of a CPU cycle in nanoseconds. Zero runs as fast as possible.")
+	maxcycles    = flag.Uint64("maxcycles", 0, "Stop after this many cycles. Defaults to no limit.")
+	timeout      = flag.Duration("timeout", 0, "Stop after this amount of time. Defaults to no limit.")
+	littleendian = flag.Bool("l", false, "Input binary is Little Endian. Defaults to Big Endian.")
+	savefile     = flag.String("save", "", "Write a snapshot of the machine state to this file when the program stops.")
+	restorefile  = flag.String("restore", "", "Resume from the machine state in this snapshot file.")
+)
+
+func main() {
+	parseArgs()
+
+	devices, err := ParseDevices(*devlist)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Devices: %v\n", err)
+		os.Exit(1)
+	}
+
+	defer CloseDisks()
+
+	program, dbg, err := LoadProgram(input, includes, *littleendian)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		CloseDisks()
+		os.Exit(1)
+	}
+
+	emu := NewEmulator(program, dbg, devices)
+
+	if len(*restorefile) > 0 {
+		if err = emu.Restore(*restorefile); err != nil {
+			fmt.Fprintf(os.Stderr, "Restore: %v\n", err)
+			CloseDisks()
+			os.Exit(1)
+		}
+	}
+
+	emu.Run(time.Duration(*clockspeed), *maxcycles, *timeout)
+	emu.Report(os.Stdout)
+
+	if len(*savefile) > 0 {
+		if err = emu.Save(*savefile); err != nil {
+			fmt.Fprintf(os.Stderr, "Save: %v\n", err)
+			CloseDisks()
+			os.Exit(1)
+		}
+	}
+
+	if err = CloseDisks(); err != nil {
+		fmt.Fprintf(os.Stderr, "Disks: %v\n", err)
+		os.Exit(1)
+	}
+
+	switch emu.state {
+	case StateExit:
+	case StateCycles, StateTimeout:
+		os.Exit(2)
+	default:
+		os.Exit(1)
+	}
+}
+
+// process commandline arguments.
+func parseArgs() {
+	var version bool
+	var include string
+
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stdout, "Usage: %s [options] <file>\n", os.Args[0])
+		flag.PrintDefaults()
+		fmt.Fprintf(os.Stdout, "\nKnown devices: %s\n", DeviceNames())
+	}
+
+	flag.StringVar(&include, "i", "", "Colon-separated list of additional include paths.")
+	flag.BoolVar(&version, "v", false, "Display version information.")
+	flag.Parse()
+
+	if version {
+		fmt.Fprintf(os.Stdout, "%s\n", Version())
+		os.Exit(0)
+	}
+
+	if flag.NArg() == 0 {
+		fmt.Fprintf(os.Stderr, "No input file.\n")
+		os.Exit(1)
+	}
+
+	input = filepath.Clean(flag.Arg(0))
+
+	if _, err := os.Lstat(input); err != nil {
+		fmt.Fprintf(os.Stderr, "Input path: %v\n", err)
+		os.Exit(1)
+	}
+
+	dir, _ := filepath.Split(input)
+	includes = append(includes, filepath.Clean(dir))
+
+	if len(include) > 0 {
+		for _, v := range strings.Split(include, ":") {
+			includes = append(includes, filepath.Clean(v))
+		}
+	}
+}
@@ -0,0 +1,111 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"github.com/jteeuwen/dcpu/asm"
+	"github.com/jteeuwen/dcpu/cpu"
+	"github.com/jteeuwen/dcpu/link"
+	"io"
+	"io/ioutil"
+	"os"
+)
+
+// readObject reads the given object file.
+func readObject(file string) (*asm.Object, error) {
+	fd, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+
+	defer fd.Close()
+	return asm.ReadObject(fd)
+}
+
+// readArchive reads the given archive file.
+func readArchive(file string) (*link.Archive, error) {
+	fd, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+
+	defer fd.Close()
+	return link.ReadArchive(fd)
+}
+
+// writeArchive bundles the given object files into an archive.
+func writeArchive(inputs []string, file string) (err error) {
+	ar := link.NewArchive()
+
+	for _, in := range inputs {
+		obj, err := readObject(in)
+		if err != nil {
+			return err
+		}
+
+		ar.Add(in, obj)
+	}
+
+	w, err := create(file)
+	if err != nil {
+		return
+	}
+
+	defer w.Close()
+	return link.WriteArchive(w, ar)
+}
+
+// writeDebug writes the given debug symbols as JSON,
+// in the same format as dcpu-asm.
+func writeDebug(d *asm.DebugInfo, file string) (err error) {
+	if d == nil || len(file) == 0 {
+		return
+	}
+
+	data, err := json.MarshalIndent(d, "", " ")
+	if err != nil {
+		return
+	}
+
+	return ioutil.WriteFile(file, data, 0644)
+}
+
+// writeProgram writes the given program as a binary,
+// in the same format as dcpu-asm.
+func writeProgram(program []cpu.Word, file string, little_endian bool) (err error) {
+	w, err := create(file)
+	if err != nil {
+		return
+	}
+
+	defer w.Close()
+
+	var b [2]byte
+
+	for _, word := range program {
+		b[0] = byte((word >> 8) & 0xff)
+		b[1] = byte(word & 0xff)
+
+		if little_endian {
+			b[0], b[1] = b[1], b[0]
+		}
+
+		if _, err = w.Write(b[:]); err != nil {
+			return
+		}
+	}
+
+	return
+}
+
+// create opens the given file for writing. An empty
+// name yields stdout.
+func create(file string) (io.WriteCloser, error) {
+	if len(file) == 0 {
+		return os.Stdout, nil
+	}
+
+	return os.Create(file)
+}
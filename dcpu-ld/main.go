@@ -0,0 +1,115 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+// This tool links relocatable objects into a DCPU program.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/jteeuwen/dcpu/asm"
+	"github.com/jteeuwen/dcpu/cpu"
+	"github.com/jteeuwen/dcpu/link"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var (
+	inputs       []string
+	outfile      = flag.String("o", "", "Path to output. Defaults to stdout.")
+	debugfile    = flag.String("d", "", "Path to debug symbol file.")
+	base         = flag.Uint("b", 0, "Base address at which the program is loaded.")
+	littleendian = flag.Bool("l", false, "Generate Little Endian binary output. Defaults to Big Endian.")
+	archive      = flag.Bool("a", false, "Create an archive from the input objects, instead of linking them.")
+)
+
+func main() {
+	parseArgs()
+
+	if *archive {
+		if err := writeArchive(inputs, *outfile); err != nil {
+			fmt.Fprintf(os.Stderr, "Archive: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	var objects []*asm.Object
+	var archives []*link.Archive
+
+	for _, file := range inputs {
+		if isArchive(file) {
+			ar, err := readArchive(file)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "%s: %v\n", file, err)
+				os.Exit(1)
+			}
+
+			archives = append(archives, ar)
+			continue
+		}
+
+		obj, err := readObject(file)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", file, err)
+			os.Exit(1)
+		}
+
+		objects = append(objects, obj)
+	}
+
+	program, dbg, err := link.Link(objects, archives, cpu.Word(*base))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Linker: %v\n", err)
+		os.Exit(1)
+	}
+
+	if err = writeDebug(dbg, *debugfile); err != nil {
+		fmt.Fprintf(os.Stderr, "Debug writer: %v\n", err)
+		os.Exit(1)
+	}
+
+	if err = writeProgram(program, *outfile, *littleendian); err != nil {
+		fmt.Fprintf(os.Stderr, "Binary writer: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// isArchive returns true if the given file is an archive.
+// Archives are recognized by their `.a` extension.
+func isArchive(file string) bool {
+	return strings.ToLower(filepath.Ext(file)) == ".a"
+}
+
+// process commandline arguments.
+func parseArgs() {
+	version := flag.Bool("v", false, "Display version information.")
+
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stdout, "Usage: %s [options] <file> [<file> ...]\n", os.Args[0])
+		fmt.Fprintf(os.Stdout, "   or: %s -a -o <archive> <object> [<object> ...]\n\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+
+	flag.Parse()
+
+	if *version {
+		fmt.Fprintf(os.Stdout, "%s\n", Version())
+		os.Exit(0)
+	}
+
+	if flag.NArg() == 0 {
+		fmt.Fprintf(os.Stderr, "No input files.\n")
+		os.Exit(1)
+	}
+
+	if *base > 0xffff {
+		fmt.Fprintf(os.Stderr, "Base address %x out of range.\n", *base)
+		os.Exit(1)
+	}
+
+	for _, file := range flag.Args() {
+		inputs = append(inputs, filepath.Clean(file))
+	}
+}
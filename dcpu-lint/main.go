@@ -0,0 +1,124 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+// This tool checks DCPU source files for suspicious code.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/jteeuwen/dcpu/lint"
+	"github.com/jteeuwen/dcpu/parser"
+	"github.com/jteeuwen/dcpu/parser/util"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var (
+	inputs     []string
+	includes   []string
+	categories []lint.Category
+)
+
+func main() {
+	var count int
+
+	parseArgs()
+
+	for _, file := range inputs {
+		list, err := lintFile(file)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			os.Exit(1)
+		}
+
+		for _, w := range list {
+			fmt.Fprintf(os.Stdout, "%s\n", w)
+		}
+
+		count += len(list)
+	}
+
+	if count > 0 {
+		os.Exit(1)
+	}
+}
+
+// lintFile lints the given file. Only warnings for code in the file
+// itself are returned. Code loaded to resolve references is ignored.
+func lintFile(file string) ([]*lint.Warning, error) {
+	var ast parser.AST
+
+	if err := util.ReadSource(&ast, file, includes); err != nil {
+		return nil, err
+	}
+
+	abs, err := filepath.Abs(file)
+	if err != nil {
+		return nil, err
+	}
+
+	var out []*lint.Warning
+
+	for _, w := range lint.Lint(&ast, categories...) {
+		if w.File == abs {
+			out = append(out, w)
+		}
+	}
+
+	return out, nil
+}
+
+// process commandline arguments.
+func parseArgs() {
+	include := flag.String("i", "", "Colon separated list of additional include paths.")
+	warnings := flag.String("w", "", "Comma separated list of warning categories to report. Defaults to all.")
+	version := flag.Bool("v", false, "Display version information.")
+
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stdout, "Usage: %s [options] <file> [<file> ...]\n\n", os.Args[0])
+		flag.PrintDefaults()
+
+		fmt.Fprintf(os.Stdout, "\nWarning categories:\n\n")
+
+		for _, c := range lint.Categories {
+			fmt.Fprintf(os.Stdout, "  %s\n", c)
+		}
+	}
+
+	flag.Parse()
+
+	if *version {
+		fmt.Fprintf(os.Stdout, "%s\n", Version())
+		os.Exit(0)
+	}
+
+	if flag.NArg() == 0 {
+		fmt.Fprintf(os.Stderr, "No input files.\n")
+		os.Exit(1)
+	}
+
+	for _, file := range flag.Args() {
+		inputs = append(inputs, filepath.Clean(file))
+	}
+
+	if len(*warnings) > 0 {
+		var err error
+
+		if categories, err = lint.ParseCategories(*warnings); err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	if len(*include) > 0 {
+		for _, path := range strings.Split(*include, ":") {
+			includes = append(includes, filepath.Clean(path))
+		}
+	}
+
+	if wd, err := os.Getwd(); err == nil {
+		includes = append(includes, filepath.Clean(wd))
+	}
+}
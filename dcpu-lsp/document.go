@@ -0,0 +1,424 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"github.com/jteeuwen/dcpu/asm"
+	"github.com/jteeuwen/dcpu/cpu"
+	"github.com/jteeuwen/dcpu/disasm"
+	"github.com/jteeuwen/dcpu/lint"
+	"github.com/jteeuwen/dcpu/parser"
+	"github.com/jteeuwen/dcpu/parser/util"
+	"io/ioutil"
+	"net/url"
+	"path/filepath"
+	"strings"
+)
+
+// Symbol kinds.
+const (
+	KindLabel    = "label"
+	KindConstant = "constant"
+	KindFunction = "function"
+)
+
+// A Document is a source file which is open in the editor.
+type Document struct {
+	URI      string
+	Path     string
+	Text     string
+	analysis *Analysis
+}
+
+func NewDocument(uri, text string) *Document {
+	return &Document{
+		URI:  uri,
+		Path: pathFromURI(uri),
+		Text: text,
+	}
+}
+
+// occurrence is a single definition of, or reference to a symbol.
+type occurrence struct {
+	name string
+	kind string // Symbol kind. Empty for references.
+	file string
+	line int
+	col  int
+}
+
+// Analysis holds everything we know about a document.
+// The document is treated as the entry point of a program.
+type Analysis struct {
+	symbols []occurrence        // All symbol definitions and references.
+	defs    map[string]int      // Index of the definition for each symbol.
+	files   []string            // Files making up the program.
+	code    []cpu.Word          // Assembled program, if it built.
+	debug   *asm.DebugInfo      // Debug symbols for code.
+	diags   []Diagnostic        // Errors and warnings for the document.
+	lines   map[string][]string // Source lines, per file.
+	doc     *Document
+}
+
+// analyze parses and assembles the given document. Included files
+// are loaded from disk.
+func analyze(doc *Document, includes []string) *Analysis {
+	var ast parser.AST
+
+	a := &Analysis{
+		defs:  make(map[string]int),
+		lines: make(map[string][]string),
+		doc:   doc,
+	}
+
+	a.lines[doc.Path] = strings.Split(doc.Text, "\n")
+
+	err := util.ParseSource(&ast, strings.NewReader(doc.Text), doc.Path, includes)
+
+	// Index what we have, even if there are errors.
+	// This keeps navigation working while code is being edited.
+	if ast.Root != nil {
+		a.files = ast.Files
+		a.index(ast.Root.Children())
+	}
+
+	if err != nil {
+		a.addErrors(err)
+		return a
+	}
+
+	for _, w := range lint.Lint(&ast) {
+		if w.File == doc.Path {
+			a.addDiagnostic(w.File, w.Line, w.Col, SeverityWarning, string(w.Category), w.Msg)
+		}
+	}
+
+	// The assembler changes the AST. Build from a fresh copy.
+	var tmp parser.AST
+
+	if err = util.ParseSource(&tmp, strings.NewReader(doc.Text), doc.Path, includes); err != nil {
+		a.addErrors(err)
+		return a
+	}
+
+	if a.code, a.debug, err = asm.Assemble(&tmp); err != nil {
+		a.addErrors(err)
+	}
+
+	return a
+}
+
+// addErrors adds diagnostics for the given error.
+func (a *Analysis) addErrors(err error) {
+	list, ok := err.(parser.ErrorList)
+	if !ok {
+		list = parser.ErrorList{err}
+	}
+
+	for _, e := range list {
+		switch tt := e.(type) {
+		case *parser.ParseError:
+			a.addDiagnostic(tt.File, tt.Line, tt.Col, SeverityError, "", tt.Msg)
+		case *asm.BuildError:
+			a.addDiagnostic(tt.File, tt.Line, tt.Col, SeverityError, "", tt.Msg)
+		default:
+			a.addDiagnostic(a.doc.Path, 1, 1, SeverityError, "", e.Error())
+		}
+	}
+}
+
+// addDiagnostic adds a diagnostic at the given position. Problems in
+// other files are reported at the top of the document.
+func (a *Analysis) addDiagnostic(file string, line, col, severity int, code, msg string) {
+	if file != a.doc.Path {
+		msg = fmt.Sprintf("%s:%d:%d %s", file, line, col, msg)
+		line, col = 1, 1
+	}
+
+	a.diags = append(a.diags, Diagnostic{
+		Range:    a.rangeOf(file, line, col),
+		Severity: severity,
+		Source:   AppName,
+		Code:     code,
+		Message:  msg,
+	})
+}
+
+// index finds symbol definitions and references in the given nodes.
+func (a *Analysis) index(list []parser.Node) {
+	for _, v := range list {
+		switch tt := v.(type) {
+		case *parser.Label:
+			a.add(tt, tt.Data, KindLabel)
+
+		case *parser.Function:
+			name := tt.Children()[0].(*parser.Name)
+			a.add(name, name.Data, KindFunction)
+			a.index(tt.Children()[1:])
+
+		case *parser.Instruction:
+			args := tt.Children()[1:]
+
+			if tt.Children()[0].(*parser.Name).Data == "equ" {
+				name := args[0].(*parser.Expression).Children()[0].(*parser.Name)
+				a.add(name, name.Data, KindConstant)
+				args = args[1:]
+			}
+
+			for _, arg := range args {
+				a.indexRefs(arg.(*parser.Expression))
+			}
+		}
+	}
+}
+
+// indexRefs finds symbol references in the given node.
+func (a *Analysis) indexRefs(n parser.NodeCollection) {
+	for _, v := range n.Children() {
+		switch tt := v.(type) {
+		case *parser.Name:
+			if !parser.IsRegister(tt.Data) && tt.Data != "pick" {
+				a.add(tt, tt.Data, "")
+			}
+
+		case parser.NodeCollection:
+			a.indexRefs(tt)
+		}
+	}
+}
+
+// add adds a symbol occurrence. If kind is set, it is a definition.
+//
+// Labels generated for macro expansions are skipped. All their nodes
+// share the position of the invocation.
+func (a *Analysis) add(n parser.Node, name, kind string) {
+	if strings.HasPrefix(name, "__") && !strings.HasPrefix(name, "__anon_") {
+		return
+	}
+
+	if len(kind) > 0 {
+		if _, ok := a.defs[name]; !ok {
+			a.defs[name] = len(a.symbols)
+		}
+	}
+
+	a.symbols = append(a.symbols, occurrence{
+		name: name,
+		kind: kind,
+		file: a.fileName(n),
+		line: n.Line(),
+		col:  n.Col(),
+	})
+}
+
+// fileName returns the name of the file holding the given node.
+func (a *Analysis) fileName(n parser.Node) string {
+	return a.files[n.File()]
+}
+
+// lookup finds the symbol occurrence at the given position.
+// Returns nil if there is none.
+func (a *Analysis) lookup(file string, pos Position) *occurrence {
+	for i := range a.symbols {
+		s := &a.symbols[i]
+
+		if s.file != file || s.line != pos.Line+1 {
+			continue
+		}
+
+		r := a.rangeOf(s.file, s.line, s.col)
+		if pos.Character >= r.Start.Character && pos.Character < r.End.Character {
+			return s
+		}
+	}
+
+	return nil
+}
+
+// definition returns the definition of the given symbol.
+func (a *Analysis) definition(name string) *occurrence {
+	if i, ok := a.defs[name]; ok {
+		return &a.symbols[i]
+	}
+	return nil
+}
+
+// references returns all references to the given symbol.
+func (a *Analysis) references(name string, withDefs bool) []*occurrence {
+	var list []*occurrence
+
+	for i := range a.symbols {
+		s := &a.symbols[i]
+
+		if s.name == name && (withDefs || len(s.kind) == 0) {
+			list = append(list, s)
+		}
+	}
+
+	return list
+}
+
+// location returns the editor location of the given symbol occurrence.
+func (a *Analysis) location(s *occurrence) Location {
+	return Location{
+		URI:   uriFromPath(s.file),
+		Range: a.rangeOf(s.file, s.line, s.col),
+	}
+}
+
+// hover describes the symbol or instruction at the given position.
+// Returns an empty string if there is nothing to say.
+func (a *Analysis) hover(pos Position) string {
+	if s := a.lookup(a.doc.Path, pos); s != nil {
+		return a.describe(s.name)
+	}
+
+	return a.describeLine(pos.Line + 1)
+}
+
+// describe describes the given symbol.
+func (a *Analysis) describe(name string) string {
+	def := a.definition(name)
+	if def == nil {
+		return fmt.Sprintf("`%s`: undefined", name)
+	}
+
+	text := fmt.Sprintf("%s `%s`", def.kind, name)
+
+	if def.kind == KindConstant {
+		return text + "\n\n```dasm\n" + strings.TrimSpace(a.line(def.file, def.line)) + "\n```"
+	}
+
+	if a.debug != nil {
+		for _, l := range a.debug.Labels {
+			if l.Name == name {
+				text += fmt.Sprintf(" at address `0x%04x`", l.Addr)
+				break
+			}
+		}
+	}
+
+	return text
+}
+
+// describeLine describes the encoded size and cost of the code
+// generated for the given line of the document.
+func (a *Analysis) describeLine(line int) string {
+	if a.debug == nil {
+		return ""
+	}
+
+	start, size := -1, 0
+
+	for addr, si := range a.debug.SourceMapping {
+		if si.Line != line || a.debug.Files[si.File].Name != a.doc.Path {
+			if start != -1 {
+				break
+			}
+			continue
+		}
+
+		if start == -1 {
+			start = addr
+		}
+
+		size++
+	}
+
+	if start == -1 {
+		return ""
+	}
+
+	fields := strings.Fields(a.line(a.doc.Path, line))
+	if len(fields) > 0 && strings.EqualFold(fields[0], "dat") {
+		return fmt.Sprintf("%d word(s) of data at `0x%04x`", size, start)
+	}
+
+	// A line can hold more than one instruction, when it invokes a macro.
+	var cost cpu.Word
+
+	for addr := start; addr < start+size; {
+		instr := disasm.Decode(a.code, cpu.Word(addr))
+		cost += cpu.Cost(cpu.Decode(a.code[addr]))
+		addr += int(instr.Size)
+	}
+
+	return fmt.Sprintf("%d word(s), %d cycle(s) at `0x%04x`", size, cost, start)
+}
+
+// rangeOf returns the range of the word at the given one-based
+// line and column.
+func (a *Analysis) rangeOf(file string, line, col int) Range {
+	start := Position{line - 1, col - 1}
+	end := Position{line - 1, col - 1 + wordLen(a.line(file, line), col-1)}
+	return Range{start, end}
+}
+
+// line returns the given one-based line of the given file.
+// Files other than the document are read from disk.
+func (a *Analysis) line(file string, line int) string {
+	lines, ok := a.lines[file]
+
+	if !ok {
+		if data, err := ioutil.ReadFile(file); err == nil {
+			lines = strings.Split(string(data), "\n")
+		}
+
+		a.lines[file] = lines
+	}
+
+	if line < 1 || line > len(lines) {
+		return ""
+	}
+
+	return lines[line-1]
+}
+
+// wordLen returns the length of the identifier or anonymous label
+// reference at the given offset. It returns at least 1.
+func wordLen(s string, offset int) int {
+	if offset < 0 || offset >= len(s) {
+		return 1
+	}
+
+	n := offset
+	for n < len(s) && isIdent(s[n]) {
+		n++
+	}
+
+	// Anonymous label references consist of plus or minus signs.
+	if n == offset && (s[n] == '+' || s[n] == '-') {
+		for n < len(s) && s[n] == s[offset] {
+			n++
+		}
+	}
+
+	if n == offset {
+		return 1
+	}
+
+	return n - offset
+}
+
+func isIdent(c byte) bool {
+	return c == '_' || c == '.' || (c >= 'a' && c <= 'z') ||
+		(c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+}
+
+// pathFromURI returns the file path for the given file URI.
+func pathFromURI(uri string) string {
+	u, err := url.Parse(uri)
+	if err != nil || u.Scheme != "file" {
+		return uri
+	}
+
+	return filepath.Clean(filepath.FromSlash(u.Path))
+}
+
+// uriFromPath returns the file URI for the given path.
+func uriFromPath(path string) string {
+	u := url.URL{Scheme: "file", Path: filepath.ToSlash(path)}
+	return u.String()
+}
@@ -0,0 +1,95 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package main
+
+import "testing"
+
+// testSource is a small program used by the analysis tests.
+// Lines and columns in the tests are zero-based.
+const testSource = `:main
+    jsr count
+    set pc, main
+
+; Count to ten.
+:count
+    set a, 0
+:loop
+    add a, 1
+    ifl a, limit
+        set pc, loop
+    set pc, pop
+
+equ limit, 10
+`
+
+func TestWordLen(t *testing.T) {
+	for _, tt := range []struct {
+		s      string
+		offset int
+		want   int
+	}{
+		{"set pc, loop", 8, 4},
+		{"set pc, loop", 0, 3},
+		{"set pc, loop", 6, 1},
+		{"set pc, loop", 3, 1},
+		{"jsr foo.bar_2", 4, 9},
+		{"set pc, ++", 8, 2},
+		{"set pc, -", 8, 1},
+		{"ifl a, +-", 7, 1},
+		{"", 0, 1},
+		{"abc", -1, 1},
+		{"abc", 3, 1},
+	} {
+		if have := wordLen(tt.s, tt.offset); have != tt.want {
+			t.Errorf("wordLen(%q, %d) mismatch. Want %d, have %d",
+				tt.s, tt.offset, tt.want, have)
+		}
+	}
+}
+
+func TestLookup(t *testing.T) {
+	doc := NewDocument("file:///lsp/test.dasm", testSource)
+	a := analyze(doc, nil)
+
+	if len(a.diags) > 0 {
+		t.Fatalf("Unexpected diagnostics: %+v", a.diags)
+	}
+
+	for _, tt := range []struct {
+		line, char int
+		name, kind string
+	}{
+		{0, 1, "main", KindLabel},
+		{1, 8, "count", ""},
+		{1, 12, "count", ""},
+		{2, 12, "main", ""},
+		{5, 1, "count", KindLabel},
+		{9, 11, "limit", ""},
+		{10, 16, "loop", ""},
+		{13, 4, "limit", KindConstant},
+		{1, 4, "", ""},
+		{1, 13, "", ""},
+		{4, 4, "", ""},
+		{6, 8, "", ""},
+	} {
+		s := a.lookup(doc.Path, Position{tt.line, tt.char})
+
+		if len(tt.name) == 0 {
+			if s != nil {
+				t.Errorf("%d:%d: Expected no symbol, have %q", tt.line, tt.char, s.name)
+			}
+			continue
+		}
+
+		if s == nil {
+			t.Errorf("%d:%d: Expected %q, have nothing", tt.line, tt.char, tt.name)
+			continue
+		}
+
+		if s.name != tt.name || s.kind != tt.kind {
+			t.Errorf("%d:%d: Want %q (%s), have %q (%s)",
+				tt.line, tt.char, tt.name, tt.kind, s.name, s.kind)
+		}
+	}
+}
@@ -0,0 +1,61 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+// This tool is a Language Server Protocol server for DCPU assembly.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var includes []string
+
+func main() {
+	parseArgs()
+
+	srv := NewServer(NewConn(os.Stdin, os.Stdout), includes)
+
+	ok, err := srv.Run()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+
+	// The protocol wants us to signal an exit without shutdown.
+	if !ok {
+		os.Exit(1)
+	}
+}
+
+// process commandline arguments.
+func parseArgs() {
+	include := flag.String("i", "", "Colon separated list of additional include paths.")
+	version := flag.Bool("v", false, "Display version information.")
+
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stdout, "Usage: %s [options]\n\n", os.Args[0])
+		fmt.Fprintf(os.Stdout, "The server communicates over stdin and stdout.\n\n")
+		flag.PrintDefaults()
+	}
+
+	flag.Parse()
+
+	if *version {
+		fmt.Fprintf(os.Stdout, "%s\n", Version())
+		os.Exit(0)
+	}
+
+	if len(*include) > 0 {
+		for _, path := range strings.Split(*include, ":") {
+			includes = append(includes, filepath.Clean(path))
+		}
+	}
+
+	if wd, err := os.Getwd(); err == nil {
+		includes = append(includes, filepath.Clean(wd))
+	}
+}
@@ -0,0 +1,141 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// JSON-RPC error codes.
+const (
+	ParseError     = -32700
+	InvalidRequest = -32600
+	MethodNotFound = -32601
+	InvalidParams  = -32602
+	InternalError  = -32603
+)
+
+// Message is a JSON-RPC request, response or notification.
+// Notifications have no ID.
+type Message struct {
+	Version string           `json:"jsonrpc"`
+	ID      *json.RawMessage `json:"id,omitempty"`
+	Method  string           `json:"method,omitempty"`
+	Params  json.RawMessage  `json:"params,omitempty"`
+	Result  interface{}      `json:"result,omitempty"`
+	Error   *ResponseError   `json:"error,omitempty"`
+}
+
+// ResponseError is the error part of a JSON-RPC response.
+type ResponseError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *ResponseError) Error() string { return e.Message }
+
+// Conn reads and writes JSON-RPC messages, framed by LSP headers.
+type Conn struct {
+	r *bufio.Reader
+	w io.Writer
+}
+
+func NewConn(r io.Reader, w io.Writer) *Conn {
+	return &Conn{bufio.NewReader(r), w}
+}
+
+// Read reads the next message.
+func (c *Conn) Read() (msg *Message, err error) {
+	size := -1
+
+	// Read headers, up to the empty line which ends them.
+	for {
+		line, err := c.r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+
+		line = strings.TrimSpace(line)
+		if len(line) == 0 {
+			break
+		}
+
+		parts := strings.SplitN(line, ":", 2)
+		if len(parts) != 2 || !strings.EqualFold(parts[0], "Content-Length") {
+			continue
+		}
+
+		if size, err = strconv.Atoi(strings.TrimSpace(parts[1])); err != nil {
+			return nil, errors.New(fmt.Sprintf("Invalid Content-Length: %v", err))
+		}
+	}
+
+	if size < 0 {
+		return nil, errors.New("Missing Content-Length header.")
+	}
+
+	data := make([]byte, size)
+	if _, err = io.ReadFull(c.r, data); err != nil {
+		return
+	}
+
+	msg = new(Message)
+	err = json.Unmarshal(data, msg)
+	return
+}
+
+// Write writes the given message.
+func (c *Conn) Write(msg *Message) (err error) {
+	msg.Version = "2.0"
+
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return
+	}
+
+	if _, err = fmt.Fprintf(c.w, "Content-Length: %d\r\n\r\n", len(data)); err != nil {
+		return
+	}
+
+	_, err = c.w.Write(data)
+	return
+}
+
+// Reply sends the response to the given request.
+func (c *Conn) Reply(req *Message, result interface{}, err error) error {
+	msg := &Message{ID: req.ID}
+
+	switch tt := err.(type) {
+	case nil:
+		// A null result must still be sent.
+		if result == nil {
+			result = json.RawMessage("null")
+		}
+		msg.Result = result
+
+	case *ResponseError:
+		msg.Error = tt
+
+	default:
+		msg.Error = &ResponseError{InternalError, err.Error()}
+	}
+
+	return c.Write(msg)
+}
+
+// Notify sends a notification.
+func (c *Conn) Notify(method string, params interface{}) error {
+	data, err := json.Marshal(params)
+	if err != nil {
+		return err
+	}
+
+	return c.Write(&Message{Method: method, Params: data})
+}
@@ -0,0 +1,112 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// frame returns the given message body with the given header
+// and its length.
+func frame(header, body string) string {
+	return fmt.Sprintf("%s: %d\r\n\r\n%s", header, len(body), body)
+}
+
+func TestConnWrite(t *testing.T) {
+	var buf bytes.Buffer
+
+	conn := NewConn(nil, &buf)
+	if err := conn.Notify("exit", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	body := `{"jsonrpc":"2.0","method":"exit","params":null}`
+	want := frame("Content-Length", body)
+
+	if buf.String() != want {
+		t.Fatalf("Output mismatch.\nWant: %q\nHave: %q", want, buf.String())
+	}
+}
+
+func TestConnRead(t *testing.T) {
+	in := "Content-Type: application/vscode-jsonrpc; charset=utf-8\r\n" +
+		frame("Content-Length", `{"jsonrpc":"2.0","id":1,"method":"foo"}`) +
+		frame("content-length", `{"jsonrpc":"2.0","method":"é"}`)
+
+	conn := NewConn(strings.NewReader(in), nil)
+
+	msg, err := conn.Read()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if msg.Method != "foo" || msg.ID == nil || string(*msg.ID) != "1" {
+		t.Fatalf("First message mismatch: %+v", msg)
+	}
+
+	// The length is in bytes, not characters.
+	if msg, err = conn.Read(); err != nil {
+		t.Fatal(err)
+	}
+
+	if msg.Method != "é" || msg.ID != nil {
+		t.Fatalf("Second message mismatch: %+v", msg)
+	}
+}
+
+func TestConnReadInvalid(t *testing.T) {
+	for _, in := range []string{
+		"Content-Type: text/plain\r\n\r\n{}",
+		"Content-Length: abc\r\n\r\n{}",
+		"Content-Length: 10\r\n\r\n{}",
+		"Content-Length: 2\r\n\r\n[]",
+		"Content-Length: 2\r\n",
+		"",
+	} {
+		conn := NewConn(strings.NewReader(in), nil)
+
+		if _, err := conn.Read(); err == nil {
+			t.Errorf("Expected error for %q", in)
+		}
+	}
+}
+
+// Messages written by one connection can be read back by another.
+func TestConnRoundTrip(t *testing.T) {
+	var buf bytes.Buffer
+
+	id := json.RawMessage("7")
+	req := &Message{ID: &id, Method: "shutdown"}
+
+	conn := NewConn(&buf, &buf)
+
+	if err := conn.Write(req); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := conn.Reply(req, nil, &ResponseError{InvalidParams, "bad"}); err != nil {
+		t.Fatal(err)
+	}
+
+	msg, err := conn.Read()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if msg.Version != "2.0" || msg.Method != "shutdown" || string(*msg.ID) != "7" {
+		t.Fatalf("Request mismatch: %+v", msg)
+	}
+
+	if msg, err = conn.Read(); err != nil {
+		t.Fatal(err)
+	}
+
+	if msg.Error == nil || msg.Error.Code != InvalidParams || msg.Error.Message != "bad" {
+		t.Fatalf("Response mismatch: %+v", msg)
+	}
+}
@@ -0,0 +1,299 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/jteeuwen/dcpu/parser"
+	"github.com/jteeuwen/dcpu/parser/util"
+	"io"
+	"strings"
+)
+
+// Server handles Language Server Protocol requests.
+type Server struct {
+	conn     *Conn
+	docs     map[string]*Document // Open documents, by URI.
+	includes []string             // Include paths.
+	shutdown bool                 // Have we received a shutdown request?
+}
+
+func NewServer(conn *Conn, includes []string) *Server {
+	return &Server{
+		conn:     conn,
+		docs:     make(map[string]*Document),
+		includes: includes,
+	}
+}
+
+// Run handles messages until the client sends the exit notification,
+// or closes the connection. It returns false if the server was not
+// shut down properly.
+func (s *Server) Run() (bool, error) {
+	for {
+		msg, err := s.conn.Read()
+		if err != nil {
+			if err == io.EOF {
+				err = nil
+			}
+			return false, err
+		}
+
+		if msg.Method == "exit" {
+			return s.shutdown, nil
+		}
+
+		result, err := s.handle(msg)
+
+		// Notifications get no response.
+		if msg.ID == nil {
+			continue
+		}
+
+		if err = s.conn.Reply(msg, result, err); err != nil {
+			return false, err
+		}
+	}
+}
+
+// handle handles a single request or notification.
+func (s *Server) handle(msg *Message) (interface{}, error) {
+	switch msg.Method {
+	case "initialize":
+		var p InitializeParams
+		if err := decode(msg, &p); err != nil {
+			return nil, err
+		}
+		return s.initialize(&p), nil
+
+	case "shutdown":
+		s.shutdown = true
+		return nil, nil
+
+	case "textDocument/didOpen":
+		var p DidOpenParams
+		if err := decode(msg, &p); err != nil {
+			return nil, err
+		}
+
+		doc := NewDocument(p.TextDocument.URI, p.TextDocument.Text)
+		s.docs[doc.URI] = doc
+		return nil, s.update(doc)
+
+	case "textDocument/didChange":
+		var p DidChangeParams
+		if err := decode(msg, &p); err != nil {
+			return nil, err
+		}
+
+		doc, ok := s.docs[p.TextDocument.URI]
+		if !ok || len(p.ContentChanges) == 0 {
+			return nil, nil
+		}
+
+		// We use full document sync. The last change holds the new text.
+		doc.Text = p.ContentChanges[len(p.ContentChanges)-1].Text
+		return nil, s.update(doc)
+
+	case "textDocument/didSave":
+		// Files included by open documents may have changed.
+		for _, doc := range s.docs {
+			if err := s.update(doc); err != nil {
+				return nil, err
+			}
+		}
+		return nil, nil
+
+	case "textDocument/didClose":
+		var p DidCloseParams
+		if err := decode(msg, &p); err != nil {
+			return nil, err
+		}
+
+		delete(s.docs, p.TextDocument.URI)
+		return nil, s.conn.Notify("textDocument/publishDiagnostics",
+			PublishDiagnosticsParams{p.TextDocument.URI, []Diagnostic{}})
+
+	case "textDocument/definition":
+		var p TextDocumentPositionParams
+		if err := decode(msg, &p); err != nil {
+			return nil, err
+		}
+		return s.definition(&p), nil
+
+	case "textDocument/references":
+		var p ReferenceParams
+		if err := decode(msg, &p); err != nil {
+			return nil, err
+		}
+		return s.references(&p), nil
+
+	case "textDocument/hover":
+		var p TextDocumentPositionParams
+		if err := decode(msg, &p); err != nil {
+			return nil, err
+		}
+		return s.hover(&p), nil
+
+	case "textDocument/formatting":
+		var p FormattingParams
+		if err := decode(msg, &p); err != nil {
+			return nil, err
+		}
+		return s.format(&p)
+	}
+
+	if msg.ID == nil {
+		return nil, nil // Unknown notifications are ignored.
+	}
+
+	return nil, &ResponseError{MethodNotFound,
+		fmt.Sprintf("Unsupported method %q.", msg.Method)}
+}
+
+// initialize handles the initialize request.
+func (s *Server) initialize(p *InitializeParams) *InitializeResult {
+	s.includes = append(s.includes, p.InitializationOptions.Includes...)
+
+	if len(p.RootURI) > 0 {
+		s.includes = append(s.includes, pathFromURI(p.RootURI))
+	}
+
+	rev := AppVersionRev
+	if len(rev) == 0 {
+		rev = "0"
+	}
+
+	return &InitializeResult{
+		Capabilities: ServerCapabilities{
+			TextDocumentSync:           SyncFull,
+			DefinitionProvider:         true,
+			ReferencesProvider:         true,
+			HoverProvider:              true,
+			DocumentFormattingProvider: true,
+		},
+		ServerInfo: ServerInfo{
+			Name:    AppName,
+			Version: fmt.Sprintf("%d.%d.%s", AppVersionMajor, AppVersionMinor, rev),
+		},
+	}
+}
+
+// update analyzes the given document and publishes its diagnostics.
+func (s *Server) update(doc *Document) error {
+	doc.analysis = analyze(doc, s.includes)
+
+	diags := doc.analysis.diags
+	if diags == nil {
+		diags = []Diagnostic{} // Clears old diagnostics.
+	}
+
+	return s.conn.Notify("textDocument/publishDiagnostics",
+		PublishDiagnosticsParams{doc.URI, diags})
+}
+
+// symbolAt returns the analysis of the given document and the symbol
+// at the given position. Either can be nil.
+func (s *Server) symbolAt(p *TextDocumentPositionParams) (*Analysis, *occurrence) {
+	doc, ok := s.docs[p.TextDocument.URI]
+	if !ok || doc.analysis == nil {
+		return nil, nil
+	}
+
+	return doc.analysis, doc.analysis.lookup(doc.Path, p.Position)
+}
+
+// definition handles the definition request.
+func (s *Server) definition(p *TextDocumentPositionParams) interface{} {
+	a, sym := s.symbolAt(p)
+	if sym == nil {
+		return nil
+	}
+
+	def := a.definition(sym.name)
+	if def == nil {
+		return nil
+	}
+
+	return a.location(def)
+}
+
+// references handles the references request.
+func (s *Server) references(p *ReferenceParams) interface{} {
+	a, sym := s.symbolAt(&p.TextDocumentPositionParams)
+	if sym == nil {
+		return nil
+	}
+
+	list := []Location{}
+
+	for _, ref := range a.references(sym.name, p.Context.IncludeDeclaration) {
+		list = append(list, a.location(ref))
+	}
+
+	return list
+}
+
+// hover handles the hover request.
+func (s *Server) hover(p *TextDocumentPositionParams) interface{} {
+	doc, ok := s.docs[p.TextDocument.URI]
+	if !ok || doc.analysis == nil {
+		return nil
+	}
+
+	text := doc.analysis.hover(p.Position)
+	if len(text) == 0 {
+		return nil
+	}
+
+	return &Hover{Contents: MarkupContent{"markdown", text}}
+}
+
+// format handles the formatting request. The document is formatted
+// by the same rules as dcpu-fmt uses.
+func (s *Server) format(p *FormattingParams) (interface{}, error) {
+	var ast parser.AST
+	var buf bytes.Buffer
+
+	doc, ok := s.docs[p.TextDocument.URI]
+	if !ok {
+		return nil, nil
+	}
+
+	if err := ast.Parse(strings.NewReader(doc.Text), doc.Path); err != nil {
+		return nil, &ResponseError{InternalError, err.Error()}
+	}
+
+	sw := util.NewSourceWriter(&buf, &ast)
+	sw.Tabs = !p.Options.InsertSpaces
+
+	if p.Options.TabSize > 0 {
+		sw.TabWidth = p.Options.TabSize
+	}
+
+	sw.Write()
+
+	// Replace the whole document.
+	end := Position{strings.Count(doc.Text, "\n") + 1, 0}
+
+	return []TextEdit{{
+		Range:   Range{Position{0, 0}, end},
+		NewText: buf.String(),
+	}}, nil
+}
+
+// decode decodes the parameters of the given message.
+func decode(msg *Message, v interface{}) error {
+	if len(msg.Params) == 0 {
+		return nil
+	}
+
+	if err := json.Unmarshal(msg.Params, v); err != nil {
+		return &ResponseError{InvalidParams, err.Error()}
+	}
+
+	return nil
+}
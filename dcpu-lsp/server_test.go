@@ -0,0 +1,147 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"testing"
+)
+
+// A definition and references request on an in-memory document,
+// going through the full message loop.
+func TestServerNavigation(t *testing.T) {
+	var in, out bytes.Buffer
+
+	const uri = "file:///lsp/test.dasm"
+
+	client := NewConn(&out, &in)
+	id := 0
+
+	request := func(method string, params interface{}) {
+		data, err := json.Marshal(params)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		msg := &Message{Method: method, Params: data}
+
+		if method != "textDocument/didOpen" && method != "exit" {
+			id++
+			raw := json.RawMessage(fmt.Sprint(id))
+			msg.ID = &raw
+		}
+
+		if err := client.Write(msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var open DidOpenParams
+	open.TextDocument.URI = uri
+	open.TextDocument.Text = testSource
+	request("textDocument/didOpen", open)
+
+	// `loop` in `set pc, loop`.
+	var pos TextDocumentPositionParams
+	pos.TextDocument.URI = uri
+	pos.Position = Position{10, 16}
+	request("textDocument/definition", pos)
+
+	var refs ReferenceParams
+	refs.TextDocumentPositionParams = pos
+	refs.Context.IncludeDeclaration = true
+	request("textDocument/references", refs)
+
+	// `count` in `jsr count`.
+	refs.Position = Position{1, 9}
+	refs.Context.IncludeDeclaration = false
+	request("textDocument/references", refs)
+
+	// Whitespace.
+	pos.Position = Position{3, 0}
+	request("textDocument/definition", pos)
+
+	request("shutdown", nil)
+	request("exit", nil)
+
+	ok, err := NewServer(NewConn(&in, &out), nil).Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !ok {
+		t.Fatalf("Expected clean shutdown.")
+	}
+
+	// Diagnostics for the opened document come first.
+	var diags PublishDiagnosticsParams
+	read(t, client, "textDocument/publishDiagnostics", &diags)
+
+	if diags.URI != uri || len(diags.Diagnostics) != 0 {
+		t.Fatalf("Unexpected diagnostics: %+v", diags)
+	}
+
+	var def Location
+	read(t, client, "", &def)
+
+	if def.URI != uri || def.Range != (Range{Position{7, 1}, Position{7, 5}}) {
+		t.Fatalf("Definition mismatch: %+v", def)
+	}
+
+	var locs []Location
+	read(t, client, "", &locs)
+	checkLines(t, locs, 7, 10)
+
+	read(t, client, "", &locs)
+	checkLines(t, locs, 1)
+
+	var none *Location
+	read(t, client, "", &none)
+
+	if none != nil {
+		t.Fatalf("Expected no definition, have %+v", none)
+	}
+}
+
+// read reads the next message from the given connection and decodes
+// its parameters, or its result if method is empty.
+func read(t *testing.T, c *Conn, method string, v interface{}) {
+	msg, err := c.Read()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if msg.Method != method || msg.Error != nil {
+		t.Fatalf("Unexpected message: %+v", msg)
+	}
+
+	data := []byte(msg.Params)
+
+	if len(method) == 0 {
+		if data, err = json.Marshal(msg.Result); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err = json.Unmarshal(data, v); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// checkLines checks that the given locations are on the given lines.
+func checkLines(t *testing.T, locs []Location, lines ...int) {
+	if len(locs) != len(lines) {
+		t.Fatalf("Location count mismatch. Want %d, have %d: %+v",
+			len(lines), len(locs), locs)
+	}
+
+	for i := range locs {
+		if locs[i].Range.Start.Line != lines[i] {
+			t.Fatalf("Location %d mismatch. Want line %d, have %+v",
+				i, lines[i], locs[i])
+		}
+	}
+}
@@ -5,44 +5,165 @@
 package main
 
 import (
+	"bytes"
 	"flag"
 	"fmt"
+	"github.com/jteeuwen/dcpu/cpu"
+	"io"
 	"os"
 	"path/filepath"
+	"regexp"
+	"sort"
 	"strings"
+	"sync"
+	"time"
 )
 
 var (
-	input    string   // Input source directory.
-	includes []string // List of paths where we look to resolve source file references.
-	clock    = flag.Int64("c", 1000, "Clock speed in nanoseconds at which to run the tests.")
-	profile  = flag.Bool("p", false, "Save profiling data for each test as file.dasm => file.prof.")
-	trace    = flag.Bool("t", false, "Print trace output for each instruction as it is executed.")
+	input     string   // Input source directory.
+	includes  []string // List of paths where we look to resolve source file references.
+	clock     = flag.Int64("c", int64(cpu.DefaultClockSpeed), "Duration of a CPU cycle in nanoseconds. Zero runs tests as fast as possible.")
+	profile   = flag.Bool("p", false, "Save profiling data for each test as file.dasm => file.prof.")
+	trace     = flag.Bool("t", false, "Print trace output for each instruction as it is executed.")
+	failfast  = flag.Bool("failfast", false, "Stop at the first test which fails.")
+	jobs      = flag.Int("j", 1, "Number of test files to run concurrently.")
+	junit     = flag.String("junit", "", "Write results as JUnit XML to the given file.")
+	jsonout   = flag.Bool("json", false, "Write results as JSON to stdout. Other output goes to stderr.")
+	timeout   = flag.Duration("timeout", 0, "Stop a test which runs longer than this. Zero disables the limit.")
+	maxcycles = flag.Uint64("maxcycles", 0, "Stop a test which runs more than this many cycles. Zero disables the limit.")
 )
 
-func main() {
-	var err error
+// console receives progress and trace output. This is stderr
+// when results are written as JSON.
+var console io.Writer = os.Stdout
+
+// filter selects the test functions to run. Nil runs all of them.
+var filter *regexp.Regexp
 
+func main() {
 	parseArgs()
-	tests := collectTests()
 
-	for {
-		select {
-		case file := <-tests:
-			if len(file) == 0 {
-				return
-			}
+	sum := runTests(collectTests())
 
-			t := NewTest(file, includes)
+	if *jsonout {
+		if err := WriteJSON(os.Stdout, sum); err != nil {
+			fmt.Fprintf(os.Stderr, "JSON writer: %v\n", err)
+			os.Exit(1)
+		}
+	} else {
+		sum.Write(console)
+	}
 
-			err = t.Run()
+	if len(*junit) > 0 {
+		if err := writeJUnitFile(*junit, sum); err != nil {
+			fmt.Fprintf(os.Stderr, "JUnit writer: %v\n", err)
+			os.Exit(1)
+		}
+	}
 
-			if err != nil {
-				fmt.Fprintf(os.Stderr, "%v\n", err)
-				os.Exit(1)
+	if !sum.Ok() {
+		os.Exit(1)
+	}
+}
+
+// output holds the result of a test, along with its buffered output.
+type output struct {
+	result *Result
+	buf    *bytes.Buffer
+}
+
+// runTests runs the given test files on a pool of workers.
+//
+// With more than one worker, the output of each test is buffered
+// and written in one piece once the test is done. This keeps the
+// output of concurrent tests from interleaving.
+func runTests(files <-chan string) *Summary {
+	var sum Summary
+	var wg sync.WaitGroup
+
+	done := make(chan output)
+	stop := make(chan struct{})
+	start := time.Now()
+
+	for i := 0; i < *jobs; i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for file := range files {
+				// Do not start new tests once we are stopping.
+				select {
+				case <-stop:
+					return
+				default:
+				}
+
+				var out output
+				w := console
+
+				if *jobs > 1 {
+					out.buf = new(bytes.Buffer)
+					w = out.buf
+				}
+
+				out.result = NewTest(file, includes, w).Run()
+
+				select {
+				case done <- out:
+				case <-stop:
+					return
+				}
 			}
+		}()
+	}
+
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	for out := range done {
+		if out.buf != nil {
+			out.buf.WriteTo(console)
+		}
+
+		r := out.result
+		if r == nil {
+			continue // No tests selected.
+		}
+
+		sum.Add(r)
+
+		errs := r.Errors()
+		if len(errs) == 0 {
+			continue
+		}
+
+		for _, err := range errs {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+		}
+
+		if *failfast {
+			close(stop)
+			break
 		}
 	}
+
+	sum.Duration = time.Since(start)
+	sort.Sort(byFile(sum.Results))
+	return &sum
+}
+
+// writeJUnitFile writes the given results as JUnit XML to the given file.
+func writeJUnitFile(file string, sum *Summary) error {
+	fd, err := os.Create(file)
+	if err != nil {
+		return err
+	}
+
+	defer fd.Close()
+	return WriteJUnit(fd, sum)
 }
 
 // collectTests traverses the input directory and finds all
@@ -101,6 +222,7 @@ func collectTests() <-chan string {
 func parseArgs() {
 	var version bool
 	var include string
+	var run string
 
 	flag.Usage = func() {
 		fmt.Fprintf(os.Stdout, "Usage: %s [options] path\n", os.Args[0])
@@ -109,8 +231,26 @@ func parseArgs() {
 
 	flag.StringVar(&include, "i", "", "Colon-separated list of additional include paths.")
 	flag.BoolVar(&version, "v", false, "Display version information.")
+	flag.StringVar(&run, "run", "", "Run only test functions matching this regular expression.")
 	flag.Parse()
 
+	if *jsonout {
+		console = os.Stderr
+	}
+
+	if len(run) > 0 {
+		var err error
+
+		if filter, err = regexp.Compile(run); err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid -run pattern: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	if *jobs < 1 {
+		*jobs = 1
+	}
+
 	if version {
 		fmt.Fprintf(os.Stdout, "%s\n", Version())
 		os.Exit(0)
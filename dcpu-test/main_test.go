@@ -0,0 +1,133 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Test programs which pass and fail. Passing takes a little while,
+// so a failure is seen before most other tests are done.
+const (
+	passSource = "set i, 0\n:loop\nadd i, 1\nifn i, 0x2000\nset pc, loop\nexit\n"
+	failSource = "panic msg\nexit\n:msg\ndat \"boom\", 0\n"
+)
+
+// writeFixtures writes a test file for each of the given sources
+// and returns their names, in order.
+func writeFixtures(t *testing.T, dir string, src ...string) []string {
+	var files []string
+
+	for i, v := range src {
+		file := filepath.Join(dir, fmt.Sprintf("t%02d_test.dasm", i))
+
+		if err := ioutil.WriteFile(file, []byte(v), 0600); err != nil {
+			t.Fatal(err)
+		}
+
+		files = append(files, file)
+	}
+
+	return files
+}
+
+// testRun runs the given files with the given flags. It returns the
+// summary and the number of files which were handed to workers.
+func testRun(t *testing.T, files []string, j int, ff bool) (*Summary, int) {
+	defer func(j int, ff bool, c int64, w io.Writer) {
+		*jobs, *failfast, *clock, console = j, ff, c, w
+	}(*jobs, *failfast, *clock, console)
+
+	*jobs, *failfast, *clock, console = j, ff, 0, ioutil.Discard
+
+	c := make(chan string)
+	quit := make(chan struct{})
+	taken := make(chan int)
+
+	go func() {
+		n := 0
+		defer func() { taken <- n }()
+		defer close(c)
+
+		for _, file := range files {
+			select {
+			case c <- file:
+				n++
+			case <-quit:
+				return
+			}
+		}
+	}()
+
+	sum := runTests(c)
+	close(quit)
+	return sum, <-taken
+}
+
+func TestRunTestsOrder(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dcpu-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	var src []string
+	for i := 0; i < 12; i++ {
+		if i%3 == 1 {
+			src = append(src, failSource)
+		} else {
+			src = append(src, passSource)
+		}
+	}
+
+	files := writeFixtures(t, dir, src...)
+	sum, _ := testRun(t, files, 4, false)
+
+	if len(sum.Results) != len(files) {
+		t.Fatalf("Want %d results, got %d", len(files), len(sum.Results))
+	}
+
+	for i, r := range sum.Results {
+		if r.File != files[i] {
+			t.Fatalf("Result %d: want %s, got %s", i, files[i], r.File)
+		}
+
+		if failed := r.Status != StatusPass; failed != (i%3 == 1) {
+			t.Fatalf("Result %d: unexpected status %v", i, r.Status)
+		}
+	}
+}
+
+func TestRunTestsFailFast(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dcpu-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	src := []string{failSource}
+	for i := 0; i < 30; i++ {
+		src = append(src, passSource)
+	}
+
+	files := writeFixtures(t, dir, src...)
+	sum, taken := testRun(t, files, 3, true)
+
+	if sum.Ok() {
+		t.Fatalf("Want failed summary")
+	}
+
+	// Only the tests already running when the failure came in may finish.
+	if taken > 2*3 || len(sum.Results) > 3 {
+		t.Fatalf("Want remaining tests skipped. Ran %d of %d, with %d results",
+			taken, len(files), len(sum.Results))
+	}
+}
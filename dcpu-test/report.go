@@ -0,0 +1,196 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// jsonReport is the JSON representation of a test run.
+type jsonReport struct {
+	Passed int          `json:"passed"`
+	Failed int          `json:"failed"`
+	Errors int          `json:"errors"`
+	Time   float64      `json:"time"` // In seconds.
+	Cycles uint64       `json:"cycles"`
+	Tests  []jsonResult `json:"tests"`
+}
+
+// jsonResult is the JSON representation of a single test file.
+type jsonResult struct {
+	File      string       `json:"file,omitempty"`
+	Name      string       `json:"name,omitempty"`
+	Status    string       `json:"status"`
+	Message   string       `json:"message,omitempty"`
+	CallStack []string     `json:"callstack,omitempty"`
+	Time      float64      `json:"time"` // In seconds.
+	Cycles    uint64       `json:"cycles"`
+	Cases     []jsonResult `json:"cases,omitempty"`
+}
+
+// newJSONResult returns the JSON representation of the given result.
+func newJSONResult(r *Result) jsonResult {
+	jr := jsonResult{
+		Name:      r.Name,
+		Status:    r.Status.String(),
+		Message:   r.Message,
+		CallStack: r.CallStack,
+		Time:      r.Duration.Seconds(),
+		Cycles:    r.Cycles,
+	}
+
+	if len(r.Name) == 0 {
+		jr.File = r.File
+	}
+
+	for _, tc := range r.Cases {
+		jr.Cases = append(jr.Cases, newJSONResult(tc))
+	}
+
+	return jr
+}
+
+// WriteJSON writes the given results as a JSON document.
+func WriteJSON(w io.Writer, sum *Summary) error {
+	report := jsonReport{
+		Passed: sum.Count(StatusPass),
+		Failed: sum.Count(StatusFail),
+		Errors: sum.Count(StatusError),
+		Time:   sum.Duration.Seconds(),
+		Cycles: sum.Cycles(),
+		Tests:  make([]jsonResult, len(sum.Results)),
+	}
+
+	for i, r := range sum.Results {
+		report.Tests[i] = newJSONResult(r)
+	}
+
+	data, err := json.MarshalIndent(&report, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(w, "%s\n", data)
+	return err
+}
+
+// JUnit XML elements. Each test file is written as a suite, holding
+// a test case for each test function.
+type (
+	junitSuites struct {
+		XMLName  xml.Name     `xml:"testsuites"`
+		Tests    int          `xml:"tests,attr"`
+		Failures int          `xml:"failures,attr"`
+		Errors   int          `xml:"errors,attr"`
+		Time     string       `xml:"time,attr"`
+		Suites   []junitSuite `xml:"testsuite"`
+	}
+
+	junitSuite struct {
+		Name       string          `xml:"name,attr"`
+		Tests      int             `xml:"tests,attr"`
+		Failures   int             `xml:"failures,attr"`
+		Errors     int             `xml:"errors,attr"`
+		Time       string          `xml:"time,attr"`
+		Properties []junitProperty `xml:"properties>property"`
+		Cases      []junitCase     `xml:"testcase"`
+	}
+
+	junitProperty struct {
+		Name  string `xml:"name,attr"`
+		Value string `xml:"value,attr"`
+	}
+
+	junitCase struct {
+		Name      string        `xml:"name,attr"`
+		ClassName string        `xml:"classname,attr"`
+		Time      string        `xml:"time,attr"`
+		Failure   *junitMessage `xml:"failure,omitempty"`
+		Error     *junitMessage `xml:"error,omitempty"`
+	}
+
+	junitMessage struct {
+		Message string `xml:"message,attr"`
+		Text    string `xml:",chardata"`
+	}
+)
+
+// WriteJUnit writes the given results as a JUnit XML document.
+func WriteJUnit(w io.Writer, sum *Summary) error {
+	doc := junitSuites{
+		Tests:    sum.Count(StatusPass) + sum.Count(StatusFail) + sum.Count(StatusError),
+		Failures: sum.Count(StatusFail),
+		Errors:   sum.Count(StatusError),
+		Time:     seconds(sum.Duration),
+		Suites:   make([]junitSuite, len(sum.Results)),
+	}
+
+	for i, r := range sum.Results {
+		suite := junitSuite{
+			Name: r.File,
+			Time: seconds(r.Duration),
+			Properties: []junitProperty{
+				{"cycles", fmt.Sprint(r.Cycles)},
+			},
+		}
+
+		for _, tc := range r.Tests() {
+			suite.Cases = append(suite.Cases, newJUnitCase(tc))
+			suite.Tests++
+			suite.Failures += btoi(tc.Status == StatusFail)
+			suite.Errors += btoi(tc.Status == StatusError)
+		}
+
+		doc.Suites[i] = suite
+	}
+
+	data, err := xml.MarshalIndent(&doc, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(w, "%s%s\n", xml.Header, data)
+	return err
+}
+
+// newJUnitCase returns the JUnit test case for the given result.
+func newJUnitCase(r *Result) junitCase {
+	tc := junitCase{
+		Name:      r.Name,
+		ClassName: r.File,
+		Time:      seconds(r.Duration),
+	}
+
+	if len(tc.Name) == 0 {
+		tc.Name = r.File
+	}
+
+	msg := &junitMessage{r.Message, strings.Join(r.CallStack, "\n")}
+
+	switch r.Status {
+	case StatusFail:
+		tc.Failure = msg
+	case StatusError:
+		tc.Error = msg
+	}
+
+	return tc
+}
+
+// seconds formats the given duration in seconds.
+func seconds(d time.Duration) string {
+	return fmt.Sprintf("%.3f", d.Seconds())
+}
+
+func btoi(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
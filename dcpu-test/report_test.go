@@ -0,0 +1,114 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"testing"
+)
+
+func TestWriteJSON(t *testing.T) {
+	var buf bytes.Buffer
+	var report jsonReport
+
+	sum := testSummary()
+	sum.Results[1].CallStack = []string{"b_test.dasm:3 | jsr asserteq"}
+
+	if err := WriteJSON(&buf, sum); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := json.Unmarshal(buf.Bytes(), &report); err != nil {
+		t.Fatalf("Invalid JSON: %v\n%s", err, buf.String())
+	}
+
+	if report.Passed != 2 || report.Failed != 2 || report.Errors != 1 {
+		t.Errorf("Count mismatch. Want 2/2/1, have %d/%d/%d",
+			report.Passed, report.Failed, report.Errors)
+	}
+
+	if report.Cycles != 60 {
+		t.Errorf("Cycle count mismatch. Want 60, have %d", report.Cycles)
+	}
+
+	if len(report.Tests) != 3 {
+		t.Fatalf("Test count mismatch. Want 3, have %d", len(report.Tests))
+	}
+
+	b := report.Tests[1]
+	if b.File != "b_test.dasm" || b.Status != "FAIL" ||
+		b.Message != "Assertion failed: A != B" || len(b.CallStack) != 1 {
+		t.Errorf("Failed test mismatch: %+v", b)
+	}
+
+	c := report.Tests[2]
+	if len(c.Cases) != 2 {
+		t.Fatalf("Case count mismatch. Want 2, have %d", len(c.Cases))
+	}
+
+	// Test functions carry a name, but no file.
+	if tc := c.Cases[1]; tc.Name != "test_two" || tc.File != "" || tc.Status != "FAIL" {
+		t.Errorf("Test function mismatch: %+v", tc)
+	}
+}
+
+func TestWriteJUnit(t *testing.T) {
+	var buf bytes.Buffer
+	var doc junitSuites
+
+	sum := testSummary()
+	sum.Results[1].CallStack = []string{"one", "two"}
+
+	if err := WriteJUnit(&buf, sum); err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.HasPrefix(buf.Bytes(), []byte(xml.Header)) {
+		t.Errorf("Missing XML header.")
+	}
+
+	if err := xml.Unmarshal(buf.Bytes(), &doc); err != nil {
+		t.Fatalf("Invalid XML: %v\n%s", err, buf.String())
+	}
+
+	if doc.Tests != 5 || doc.Failures != 2 || doc.Errors != 1 {
+		t.Errorf("Count mismatch. Want 5/2/1, have %d/%d/%d",
+			doc.Tests, doc.Failures, doc.Errors)
+	}
+
+	if len(doc.Suites) != 3 {
+		t.Fatalf("Suite count mismatch. Want 3, have %d", len(doc.Suites))
+	}
+
+	// A file without test functions is a suite with a single case.
+	a := doc.Suites[0]
+	if a.Name != "a_test.dasm" || a.Tests != 1 || len(a.Cases) != 1 ||
+		a.Cases[0].Name != "a_test.dasm" || a.Cases[0].Failure != nil {
+		t.Errorf("Passing suite mismatch: %+v", a)
+	}
+
+	if len(a.Properties) != 1 || a.Properties[0].Value != "10" {
+		t.Errorf("Cycle property mismatch: %+v", a.Properties)
+	}
+
+	b := doc.Suites[1].Cases[0]
+	if b.Failure == nil || b.Failure.Message != "Assertion failed: A != B" ||
+		b.Failure.Text != "one\ntwo" || b.Error != nil {
+		t.Errorf("Failed case mismatch: %+v", b)
+	}
+
+	// The file error is reported as a case of its own.
+	c := doc.Suites[2]
+	if c.Tests != 3 || c.Failures != 1 || c.Errors != 1 {
+		t.Errorf("Suite count mismatch. Want 3/1/1, have %d/%d/%d",
+			c.Tests, c.Failures, c.Errors)
+	}
+
+	if len(c.Cases) != 3 || c.Cases[0].Name != "test_one" ||
+		c.Cases[0].ClassName != "c_test.dasm" || c.Cases[2].Error == nil {
+		t.Errorf("Suite cases mismatch: %+v", c.Cases)
+	}
+}
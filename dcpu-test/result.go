@@ -0,0 +1,142 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"time"
+)
+
+// Known test outcomes.
+const (
+	StatusPass  Status = iota // All assertions held.
+	StatusFail                // An assertion failed.
+	StatusError               // The test could not be built or run.
+)
+
+// Status describes the outcome of a test file.
+type Status uint8
+
+func (s Status) String() string {
+	switch s {
+	case StatusPass:
+		return "PASS"
+	case StatusFail:
+		return "FAIL"
+	}
+	return "ERROR"
+}
+
+// Result holds the outcome of a single test file, or of a single
+// test function in that file.
+type Result struct {
+	File      string        // Test source file.
+	Name      string        // Test function name. Empty for whole files.
+	Cases     []*Result     // Results of the test functions in the file.
+	Status    Status        // Outcome of the test.
+	Err       error         // Formatted failure or error, if any.
+	Message   string        // Failure or error message, if any.
+	CallStack []string      // Call stack at the failure, innermost call first.
+	Duration  time.Duration // Time taken to build and run the test.
+	Cycles    uint64        // Number of CPU cycles executed.
+}
+
+// Tests returns the individual tests making up this result. This is
+// the list of test functions, or the result itself if there are none.
+// An error in a file with test functions counts as a test of its own.
+func (r *Result) Tests() []*Result {
+	if len(r.Cases) == 0 {
+		return []*Result{r}
+	}
+
+	if r.Err != nil {
+		return append(r.Cases[:len(r.Cases):len(r.Cases)], r)
+	}
+
+	return r.Cases
+}
+
+// Errors returns all failures and errors in this result.
+func (r *Result) Errors() []error {
+	var list []error
+
+	if r.Err != nil {
+		list = append(list, r.Err)
+	}
+
+	for _, tc := range r.Cases {
+		if tc.Err != nil {
+			list = append(list, tc.Err)
+		}
+	}
+
+	return list
+}
+
+// Summary collects the results of a test run.
+type Summary struct {
+	Results  []*Result
+	Duration time.Duration // Time taken by the whole run.
+}
+
+// Add adds the given result.
+func (s *Summary) Add(r *Result) { s.Results = append(s.Results, r) }
+
+// Count returns the number of tests with the given status.
+func (s *Summary) Count(status Status) int {
+	var n int
+
+	for _, r := range s.Results {
+		for _, tc := range r.Tests() {
+			if tc.Status == status {
+				n++
+			}
+		}
+	}
+
+	return n
+}
+
+// Ok returns true if all tests passed.
+func (s *Summary) Ok() bool {
+	return s.Count(StatusFail) == 0 && s.Count(StatusError) == 0
+}
+
+// Cycles returns the total number of cycles executed.
+func (s *Summary) Cycles() uint64 {
+	var n uint64
+
+	for _, r := range s.Results {
+		n += r.Cycles
+	}
+
+	return n
+}
+
+// Write writes a table of all results, followed by the totals.
+func (s *Summary) Write(w io.Writer) {
+	fmt.Fprintf(w, "\nSummary:\n")
+
+	for _, r := range s.Results {
+		fmt.Fprintf(w, "  %-5s %10s %10d cycles  %s\n",
+			r.Status, r.Duration, r.Cycles, r.File)
+
+		for _, tc := range r.Cases {
+			fmt.Fprintf(w, "  %-5s %10s %10d cycles    %s\n",
+				tc.Status, tc.Duration, tc.Cycles, tc.Name)
+		}
+	}
+
+	fmt.Fprintf(w, "\n%d passed, %d failed, %d error(s) in %s, %d cycles.\n",
+		s.Count(StatusPass), s.Count(StatusFail), s.Count(StatusError),
+		s.Duration, s.Cycles())
+}
+
+// byFile sorts results by file name.
+type byFile []*Result
+
+func (b byFile) Len() int           { return len(b) }
+func (b byFile) Less(i, j int) bool { return b[i].File < b[j].File }
+func (b byFile) Swap(i, j int)      { b[i], b[j] = b[j], b[i] }
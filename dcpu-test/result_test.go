@@ -0,0 +1,118 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// testSummary returns a summary with a passing file, a failing file
+// and a file with test functions, one of which failed. The last file
+// also failed to run its remaining functions.
+func testSummary() *Summary {
+	var sum Summary
+
+	sum.Add(&Result{File: "a_test.dasm", Status: StatusPass, Cycles: 10})
+	sum.Add(&Result{
+		File:    "b_test.dasm",
+		Status:  StatusFail,
+		Err:     errors.New("b failed"),
+		Message: "Assertion failed: A != B",
+		Cycles:  20,
+	})
+	sum.Add(&Result{
+		File:   "c_test.dasm",
+		Status: StatusError,
+		Err:    errors.New("c broke"),
+		Cycles: 30,
+		Cases: []*Result{
+			{File: "c_test.dasm", Name: "test_one", Status: StatusPass, Cycles: 5},
+			{File: "c_test.dasm", Name: "test_two", Status: StatusFail,
+				Err: errors.New("test_two failed"), Cycles: 25},
+		},
+	})
+
+	return &sum
+}
+
+func TestSummaryCount(t *testing.T) {
+	sum := testSummary()
+
+	for _, tt := range []struct {
+		status Status
+		want   int
+	}{
+		{StatusPass, 2},
+		{StatusFail, 2},
+		{StatusError, 1},
+	} {
+		if have := sum.Count(tt.status); have != tt.want {
+			t.Errorf("Count(%s) mismatch. Want %d, have %d", tt.status, tt.want, have)
+		}
+	}
+
+	if sum.Ok() {
+		t.Errorf("Expected summary with failures to not be ok.")
+	}
+
+	if have := sum.Cycles(); have != 60 {
+		t.Errorf("Cycle count mismatch. Want 60, have %d", have)
+	}
+}
+
+func TestSummaryOk(t *testing.T) {
+	var sum Summary
+
+	if !sum.Ok() {
+		t.Fatalf("Expected empty summary to be ok.")
+	}
+
+	sum.Add(&Result{File: "a_test.dasm"})
+	sum.Add(&Result{File: "b_test.dasm", Cases: []*Result{{Name: "test_one"}}})
+
+	if !sum.Ok() {
+		t.Fatalf("Expected passing summary to be ok.")
+	}
+}
+
+func TestResultTests(t *testing.T) {
+	sum := testSummary()
+
+	if n := len(sum.Results[0].Tests()); n != 1 {
+		t.Errorf("Test count mismatch for file without functions. Want 1, have %d", n)
+	}
+
+	// The file error counts as a test of its own.
+	c := sum.Results[2]
+	if n := len(c.Tests()); n != 3 {
+		t.Errorf("Test count mismatch for file with error. Want 3, have %d", n)
+	}
+
+	if len(c.Cases) != 2 {
+		t.Errorf("Tests() modified the list of cases.")
+	}
+
+	if n := len(c.Errors()); n != 2 {
+		t.Errorf("Error count mismatch. Want 2, have %d", n)
+	}
+}
+
+func TestSummaryWrite(t *testing.T) {
+	var buf bytes.Buffer
+
+	testSummary().Write(&buf)
+	out := buf.String()
+
+	for _, want := range []string{
+		"PASS", "a_test.dasm", "FAIL", "b_test.dasm", "ERROR", "c_test.dasm",
+		"test_one", "test_two", "2 passed, 2 failed, 1 error(s)", "60 cycles.",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("Summary does not contain %q:\n%s", want, out)
+		}
+	}
+}
@@ -28,14 +28,19 @@ type Test struct {
 	profile   *prof.Profile       // Profiling information.
 	includes  []string            // Include paths.
 	callstack []string            // callstack for the test program.
+	bin       []cpu.Word          // Compiled program.
+	watch     watchdog            // Infinite loop detection.
 	file      string              // Test source file.
+	out       io.Writer           // Destination for progress and trace output.
 }
 
-// NewTest creates a new test cases.
-func NewTest(file string, inc []string) *Test {
+// NewTest creates a new test cases. Progress and trace output
+// is written to w.
+func NewTest(file string, inc []string, w io.Writer) *Test {
 	t := new(Test)
 	t.file = file
 	t.includes = inc
+	t.out = w
 	t.cache = make(map[cpu.Word]string)
 	return t
 }
@@ -44,43 +49,227 @@ func NewTest(file string, inc []string) *Test {
 // the unit tests defined in it. Additionally, it runs a profiler
 // on the program which can optionally be written to an output file
 // for examination.
-func (t *Test) Run() (err error) {
-	fmt.Fprintf(os.Stdout, "[*] %s...\n", t.file)
+//
+// It returns nil if the file has no tests selected by the -run flag.
+func (t *Test) Run() *Result {
+	r := &Result{File: t.file}
+	start := time.Now()
 
-	ast, err := t.parse()
-	if err != nil {
-		return
+	c, err := t.load()
+
+	if err == nil {
+		funcs := t.functions()
+
+		if len(funcs) == 0 && filter != nil {
+			return nil
+		}
+
+		fmt.Fprintf(t.out, "[*] %s...\n", t.file)
+
+		if len(funcs) == 0 {
+			err = t.runProgram(c, r)
+		} else {
+			err = t.runFunctions(c, funcs, r)
+		}
+
+		if err == nil {
+			err = t.writeProfile()
+		}
+	} else {
+		fmt.Fprintf(t.out, "[*] %s...\n", t.file)
 	}
 
-	c, err := t.compile(ast)
+	r.Duration = time.Since(start)
+
 	if err != nil {
-		return
+		r.Err = err
+		r.Status = StatusError
+		r.Message = err.Error()
 	}
 
-	err = c.Run(0)
+	return r
+}
 
-	if err != nil {
-		if te, ok := err.(*cpu.TestError); ok {
-			err = t.formatTestError(te)
-			return
+// runProgram runs the whole test program, from address 0 to
+// the first EXIT or PANIC.
+func (t *Test) runProgram(c *cpu.CPU, r *Result) error {
+	if !hasExit(t.bin) {
+		return errors.New(fmt.Sprintf(
+			"%s: Program has no unconditional EXIT. This means the test will run indefinitely.", t.file))
+	}
+
+	t.reset(c)
+	return t.exec(c, 0, r)
+}
+
+// runFunctions runs each of the given test functions on a fresh
+// copy of the program. The file fails if any of them fails.
+func (t *Test) runFunctions(c *cpu.CPU, funcs []asm.FuncInfo, r *Result) error {
+	if len(t.bin) >= cpu.MemSize {
+		return errors.New(fmt.Sprintf(
+			"%s: Program leaves no room for the test harness.", t.file))
+	}
+
+	// Test functions return to this EXIT.
+	stub := cpu.Word(len(t.bin))
+	t.bin = append(t.bin, cpu.Encode(cpu.EXT, cpu.EXIT, 0))
+	t.profile = prof.New(t.bin, t.dbg)
+
+	for _, f := range funcs {
+		tc := &Result{File: t.file, Name: f.Name}
+		start := time.Now()
+
+		t.reset(c)
+		// Push the return address. SP points at the next free slot.
+		c.Store.Mem[c.Store.SP] = stub
+		c.Store.SP--
+
+		if err := t.exec(c, f.StartAddr, tc); err != nil {
+			tc.Err = err
+			tc.Message = err.Error()
+			tc.Status = StatusError
+		}
+
+		tc.Duration = time.Since(start)
+		r.Cycles += tc.Cycles
+		r.Cases = append(r.Cases, tc)
+
+		if tc.Status > r.Status {
+			r.Status = tc.Status
 		}
 	}
 
-	if *profile {
-		file := strings.Replace(t.file, ".dasm", ".prof", 1)
+	return nil
+}
 
-		var fd *os.File
-		fd, err = os.Create(file)
+// reset clears the CPU and loads a fresh copy of the program.
+func (t *Test) reset(c *cpu.CPU) {
+	c.Reset()
+	c.Store.Mem = [cpu.MemSize]cpu.Word{}
+	copy(c.Store.Mem[:], t.bin)
+	t.callstack = t.callstack[:0]
+}
 
-		if err != nil {
-			return
+// exec runs the program from the given address. Cycle counts and
+// assertion failures are recorded in the given result.
+func (t *Test) exec(c *cpu.CPU, addr cpu.Word, r *Result) error {
+	err := t.runLimited(c, addr)
+	r.Cycles = c.Cycles()
+
+	var te *cpu.TestError
+
+	switch tt := err.(type) {
+	case *cpu.TestError:
+		te = tt
+		r.CallStack = t.stack()
+
+	case *haltError:
+		// The stack does not show where we stopped. Add it.
+		te = &tt.TestError
+		r.CallStack = append([]string{t.sourceLine(te.PC)}, t.stack()...)
+
+	default:
+		return err
+	}
+
+	r.Status = StatusFail
+	r.Message = te.Msg
+	r.Err = t.formatTestError(te, r.Name, r.CallStack)
+	return nil
+}
+
+// runLimited runs the program from the given address until it exits.
+// It stops the program with a haltError, if it exceeds the limits set
+// by the -maxcycles and -timeout flags, or if it loops forever.
+func (t *Test) runLimited(c *cpu.CPU, addr cpu.Word) error {
+	var deadline time.Time
+
+	if *timeout > 0 {
+		deadline = time.Now().Add(*timeout)
+	}
+
+	chunk := uint64(runChunk)
+
+	if *clock > 0 {
+		if n := uint64(checkInterval / time.Duration(*clock)); n < chunk {
+			chunk = n + 1
+		}
+	}
+
+	t.watch.reset()
+	c.Store.PC = addr
+
+	for {
+		n := chunk
+
+		if *maxcycles > 0 {
+			if c.Cycles() >= *maxcycles {
+				return t.halt(c, "Cycle limit of %d exceeded.", *maxcycles)
+			}
+
+			if left := *maxcycles - c.Cycles(); left < n {
+				n = left
+			}
 		}
 
-		err = prof.Write(t.profile, fd)
-		fd.Close()
+		switch err := c.RunCycles(n); err {
+		case nil:
+		case io.EOF:
+			return nil
+		default:
+			return err
+		}
+
+		if t.watch.looping {
+			return &haltError{cpu.TestError{Msg: "Infinite loop detected.", PC: t.watch.pc}}
+		}
+
+		if !deadline.IsZero() && time.Now().After(deadline) {
+			return t.halt(c, "Timed out after %s.", *timeout)
+		}
 	}
+}
 
-	return
+// halt returns a haltError with the given message, for the
+// instruction at the current PC.
+func (t *Test) halt(c *cpu.CPU, f string, argv ...interface{}) error {
+	return &haltError{cpu.TestError{Msg: fmt.Sprintf(f, argv...), PC: c.Store.PC}}
+}
+
+// functions returns the test functions defined in the program,
+// which are selected by the -run flag. Test functions are defined
+// with `def test_name`.
+func (t *Test) functions() []asm.FuncInfo {
+	var list []asm.FuncInfo
+
+	for _, f := range t.dbg.Functions {
+		if !strings.HasPrefix(f.Name, "test_") {
+			continue
+		}
+
+		if filter == nil || filter.MatchString(f.Name) {
+			list = append(list, f)
+		}
+	}
+
+	return list
+}
+
+// writeProfile writes the profile to file.prof, if requested.
+func (t *Test) writeProfile() error {
+	if !*profile {
+		return nil
+	}
+
+	file := strings.Replace(t.file, ".dasm", ".prof", 1)
+
+	fd, err := os.Create(file)
+	if err != nil {
+		return err
+	}
+
+	defer fd.Close()
+	return prof.Write(t.profile, fd)
 }
 
 // formatTestError constructs a full error message like this:
@@ -89,22 +278,39 @@ func (t *Test) Run() (err error) {
 //      Call stack:
 //      - memchr_test.dasm:7 | jsr asserteq
 //
-func (t *Test) formatTestError(e *cpu.TestError) error {
+// The name of the test function, if any, follows the file name.
+func (t *Test) formatTestError(e *cpu.TestError, name string, stack []string) error {
 	if int(e.PC) >= len(t.dbg.SourceMapping) {
 		return errors.New(fmt.Sprintf("No debug symbols available for address %04x.", e.PC))
 	}
 
 	var b bytes.Buffer
-	fmt.Fprintf(&b, "[E] %s: %s\n", t.file, e.Msg)
+	if len(name) > 0 {
+		fmt.Fprintf(&b, "[E] %s: %s: %s\n", t.file, name, e.Msg)
+	} else {
+		fmt.Fprintf(&b, "[E] %s: %s\n", t.file, e.Msg)
+	}
+
 	fmt.Fprintln(&b, "    Call stack:")
 
-	for i := len(t.callstack) - 1; i >= 0; i-- {
-		fmt.Fprintf(&b, "    - %s\n", t.callstack[i])
+	for _, line := range stack {
+		fmt.Fprintf(&b, "    - %s\n", line)
 	}
 
 	return errors.New(b.String())
 }
 
+// stack returns the call stack, innermost call first.
+func (t *Test) stack() []string {
+	list := make([]string, len(t.callstack))
+
+	for i := range t.callstack {
+		list[len(list)-1-i] = t.callstack[i]
+	}
+
+	return list
+}
+
 // parseInstruction builds a callstack for the executing program.
 // This is used for adequate source context when an error occurs.
 //
@@ -133,7 +339,7 @@ func (t *Test) parseInstruction(pc, op, a, b cpu.Word, s *cpu.Storage, verbose b
 
 	// Print trace output
 	if int(pc) >= len(t.dbg.SourceMapping) {
-		fmt.Fprintf(os.Stdout,
+		fmt.Fprintf(t.out,
 			"%04x: %04x %04x %04x | %04x %04x %04x %04x %04x %04x %04x %04x | %04x %04x %04x | <unknown>\n",
 			pc, op, a, b, s.A, s.B, s.C, s.X, s.Y, s.Z, s.I, s.J, s.SP, s.EX, s.IA)
 		return
@@ -141,7 +347,7 @@ func (t *Test) parseInstruction(pc, op, a, b cpu.Word, s *cpu.Storage, verbose b
 
 	line := t.getSourceLine(pc)
 
-	fmt.Fprintf(os.Stdout,
+	fmt.Fprintf(t.out,
 		"%04x: %04x %04x %04x | %04x %04x %04x %04x %04x %04x %04x %04x | %04x %04x %04x | %s\n",
 		pc, op, a, b, s.A, s.B, s.C, s.X, s.Y, s.Z,
 		s.I, s.J, s.SP, s.EX, s.IA, line)
@@ -160,37 +366,48 @@ func (t *Test) parse() (*dp.AST, error) {
 	return &ast, nil
 }
 
+// load parses and compiles the test source.
+func (t *Test) load() (*cpu.CPU, error) {
+	ast, err := t.parse()
+	if err != nil {
+		return nil, err
+	}
+
+	return t.compile(ast)
+}
+
 // compile compiles the given AST and returns a CPU instance ready to run the code.
 func (t *Test) compile(ast *dp.AST) (c *cpu.CPU, err error) {
-	var bin []cpu.Word
-
-	if bin, t.dbg, err = asm.Assemble(ast); err != nil {
+	if t.bin, t.dbg, err = asm.Assemble(ast); err != nil {
 		return
 	}
 
-	if !hasExit(bin) {
-		return nil, errors.New(fmt.Sprintf(
-			"%s: Program has no unconditional EXIT. This means the test will run indefinitely.", t.file))
-	}
-
-	t.profile = prof.New(bin, t.dbg)
+	t.profile = prof.New(t.bin, t.dbg)
 
 	c = cpu.New()
-	copy(c.Store.Mem[:], bin)
 
-	c.ClockSpeed = time.Duration(time.Duration(*clock))
+	c.ClockSpeed = time.Duration(*clock)
 	c.Trace = func(pc, op, a, b cpu.Word, s *cpu.Storage) {
 		t.parseInstruction(pc, op, a, b, s, *trace)
 	}
 
+	queue := func() (bool, []cpu.Word) {
+		return c.QueueInterrupts(), c.InterruptQueue()
+	}
+
 	c.InstructionHandler = func(pc cpu.Word, s *cpu.Storage) {
 		t.profile.Update(pc, s)
+		t.watch.check(pc, s, queue)
 	}
 
 	c.NotifyBranchSkip = func(pc, cost cpu.Word) {
 		t.profile.UpdateCost(pc, cost)
 	}
 
+	c.NotifyDeviceCost = func(pc, cost cpu.Word) {
+		t.profile.UpdateCost(pc, cost)
+	}
+
 	return
 }
 
@@ -232,6 +449,16 @@ func hasExit(bin []cpu.Word) bool {
 	return false
 }
 
+// sourceLine returns the line of sourcecode for the given PC,
+// or just the address if there are no debug symbols for it.
+func (t *Test) sourceLine(pc cpu.Word) string {
+	if int(pc) >= len(t.dbg.SourceMapping) {
+		return fmt.Sprintf("%04x | <unknown>", pc)
+	}
+
+	return t.getSourceLine(pc)
+}
+
 // getSourceLine fetches the line of sourcecode from the
 // file defined by the given PC value. This data is stored in the
 // debug symbol table.
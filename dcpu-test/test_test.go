@@ -0,0 +1,59 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package main
+
+import (
+	"github.com/jteeuwen/dcpu/asm"
+	"regexp"
+	"testing"
+)
+
+func TestFunctions(t *testing.T) {
+	defer func(f *regexp.Regexp) { filter = f }(filter)
+
+	test := NewTest("foo_test.dasm", nil, nil)
+	test.dbg = &asm.DebugInfo{
+		Functions: []asm.FuncInfo{
+			{Name: "test_first"},
+			{Name: "helper"},
+			{Name: "test_second"},
+			{Name: "my_test_third"},
+			{Name: "test_first_again"},
+		},
+	}
+
+	for _, tt := range []struct {
+		filter string
+		want   []string
+	}{
+		{"", []string{"test_first", "test_second", "test_first_again"}},
+		{"first", []string{"test_first", "test_first_again"}},
+		{"^test_first$", []string{"test_first"}},
+		{"second|again", []string{"test_second", "test_first_again"}},
+		{"third", nil},
+		{"helper", nil},
+	} {
+		filter = nil
+		if len(tt.filter) > 0 {
+			filter = regexp.MustCompile(tt.filter)
+		}
+
+		var have []string
+		for _, f := range test.functions() {
+			have = append(have, f.Name)
+		}
+
+		if len(have) != len(tt.want) {
+			t.Errorf("Filter %q: Want %v, have %v", tt.filter, tt.want, have)
+			continue
+		}
+
+		for i := range have {
+			if have[i] != tt.want[i] {
+				t.Errorf("Filter %q: Want %v, have %v", tt.filter, tt.want, have)
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,113 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package main
+
+import (
+	"github.com/jteeuwen/dcpu/cpu"
+	"time"
+)
+
+const (
+	// Most cycles to run between checks of the test limits.
+	runChunk = 1 << 12
+
+	// Longest time between checks of the test limits, when the
+	// CPU runs at a slow clock speed.
+	checkInterval = 10 * time.Millisecond
+
+	// First number of instructions between checkpoints.
+	minCheckpoint = 1 << 10
+)
+
+// haltError occurs when a test is stopped, because it exceeded
+// its limits or got stuck in an infinite loop.
+type haltError struct {
+	cpu.TestError
+}
+
+// A watchdog detects programs which are stuck in an infinite loop.
+//
+// The test CPU has no hardware attached. Execution is therefore fully
+// determined by the contents of memory, registers and the interrupt
+// queue. If the program
+// reaches a state it has been in before, it will loop forever.
+//
+// Keeping every state is not an option. Instead, the watchdog saves
+// a checkpoint and compares each following state against it. The
+// distance between checkpoints doubles each time, so a loop of any
+// length is eventually caught.
+type watchdog struct {
+	saved    cpu.Storage // State at the last checkpoint.
+	queue    []cpu.Word  // Interrupt queue at the last checkpoint.
+	queueing bool        // Interrupt queueing at the last checkpoint.
+	steps    uint64      // Instructions since the last checkpoint.
+	limit    uint64      // Instructions until the next checkpoint.
+	pc       cpu.Word    // Address of the looping instruction.
+	looping  bool        // Has a loop been detected?
+}
+
+// reset clears the watchdog for a new run.
+func (w *watchdog) reset() {
+	w.steps = 0
+	w.limit = 0
+	w.looping = false
+}
+
+// check examines the state of the CPU before the instruction
+// at the given address executes. The queue function returns the
+// interrupt queueing state and the contents of the queue.
+func (w *watchdog) check(pc cpu.Word, s *cpu.Storage, queue func() (bool, []cpu.Word)) {
+	if w.looping {
+		return
+	}
+
+	if w.steps == w.limit {
+		w.saved = *s
+		w.queueing, w.queue = queue()
+		w.steps = 0
+
+		if w.limit == 0 {
+			w.limit = minCheckpoint
+		} else {
+			w.limit *= 2
+		}
+		return
+	}
+
+	w.steps++
+
+	// Registers differ most of the time. Only compare memory
+	// if they do not.
+	if s.PC != w.saved.PC || s.SP != w.saved.SP || s.A != w.saved.A ||
+		s.B != w.saved.B || s.C != w.saved.C || s.X != w.saved.X ||
+		s.Y != w.saved.Y || s.Z != w.saved.Z || s.I != w.saved.I ||
+		s.J != w.saved.J || s.EX != w.saved.EX || s.IA != w.saved.IA {
+		return
+	}
+
+	if s.Mem != w.saved.Mem {
+		return
+	}
+
+	queueing, list := queue()
+	if queueing != w.queueing || !sameWords(list, w.queue) {
+		return
+	}
+
+	w.pc = pc
+	w.looping = true
+}
+
+func sameWords(a, b []cpu.Word) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
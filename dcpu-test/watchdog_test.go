@@ -0,0 +1,116 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package main
+
+import (
+	"github.com/jteeuwen/dcpu/cpu"
+	"testing"
+)
+
+// noQueue describes an empty interrupt queue.
+func noQueue() (bool, []cpu.Word) { return false, nil }
+
+// A program which does not change its state loops right away.
+func TestWatchdogStuck(t *testing.T) {
+	var w watchdog
+	var s cpu.Storage
+
+	s.PC = 0x10
+	w.check(0x10, &s, noQueue)
+	w.check(0x10, &s, noQueue)
+
+	if !w.looping {
+		t.Fatalf("Expected loop to be detected.")
+	}
+
+	if w.pc != 0x10 {
+		t.Fatalf("Loop address mismatch. Want 0010, have %04x", w.pc)
+	}
+
+	w.reset()
+
+	if w.looping {
+		t.Fatalf("Expected reset to clear the loop.")
+	}
+}
+
+// Loops of any length are found eventually.
+func TestWatchdogLoop(t *testing.T) {
+	for _, period := range []int{2, 3, 100, 5000} {
+		var w watchdog
+		var s cpu.Storage
+
+		for i := 0; i < 8*period+4*minCheckpoint && !w.looping; i++ {
+			s.PC = cpu.Word(i % period)
+			w.check(s.PC, &s, noQueue)
+		}
+
+		if !w.looping {
+			t.Errorf("Loop of %d steps not detected.", period)
+		}
+	}
+}
+
+// Programs which keep changing registers or memory do not loop.
+func TestWatchdogProgress(t *testing.T) {
+	var regs, mem watchdog
+	var s cpu.Storage
+
+	for i := 0; i < 1<<16; i++ {
+		s.A = cpu.Word(i)
+		regs.check(0, &s, noQueue)
+	}
+
+	s.A = 0
+
+	for i := 0; i < 1<<16; i++ {
+		s.Mem[0x8000] = cpu.Word(i)
+		mem.check(0, &s, noQueue)
+	}
+
+	if regs.looping {
+		t.Errorf("Register changes detected as a loop.")
+	}
+
+	if mem.looping {
+		t.Errorf("Memory changes detected as a loop.")
+	}
+}
+
+// A program which only changes its interrupt state does not loop.
+func TestWatchdogInterrupts(t *testing.T) {
+	var s cpu.Storage
+	var queue []cpu.Word
+	var queueing bool
+
+	state := func() (bool, []cpu.Word) {
+		return queueing, append([]cpu.Word(nil), queue...)
+	}
+
+	// Interrupts are added to the queue.
+	var w watchdog
+	queueing = true
+
+	for i := 0; i < cpu.MaxIntQueue; i++ {
+		queue = append(queue, cpu.Word(i))
+		w.check(0, &s, state)
+	}
+
+	if w.looping {
+		t.Errorf("Interrupt queue changes detected as a loop.")
+	}
+
+	// Only the queueing state differs from the checkpoint.
+	w.reset()
+	queue = nil
+
+	queueing = false
+	w.check(0, &s, state)
+	queueing = true
+	w.check(0, &s, state)
+
+	if w.looping {
+		t.Errorf("Interrupt queueing change detected as a loop.")
+	}
+}
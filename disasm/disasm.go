@@ -0,0 +1,266 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+// DCPU disassembler package.
+package disasm
+
+import (
+	"fmt"
+	"github.com/jteeuwen/dcpu/cpu"
+)
+
+// Instruction describes a single decoded instruction.
+type Instruction struct {
+	Addr   cpu.Word   // Address of the instruction.
+	Size   cpu.Word   // Number of words occupied by the instruction.
+	Opcode cpu.Word   // Basic opcode, or the extended opcode for EXT instructions.
+	Ext    bool       // Is this an extended instruction?
+	Name   string     // Mnemonic. This is empty for invalid instructions.
+	Args   []Operand  // Operands in source order.
+	Words  []cpu.Word // Raw instruction words.
+}
+
+// Operand describes a single instruction operand.
+type Operand struct {
+	Code   cpu.Word // Operand code as it is encoded in the instruction.
+	Value  cpu.Word // Value of the next word, if the operand uses one.
+	Target bool     // Is this the write target of the instruction?
+}
+
+// Disassemble decodes the given program into a list of instructions.
+// Words which do not encode a valid instruction are returned as
+// single word instructions with an empty name.
+func Disassemble(code []cpu.Word) []Instruction {
+	var list []Instruction
+
+	for addr := 0; addr < len(code); {
+		instr := Decode(code, cpu.Word(addr))
+		list = append(list, instr)
+		addr += int(instr.Size)
+	}
+
+	return list
+}
+
+// Decode decodes the instruction at the given address.
+func Decode(code []cpu.Word, addr cpu.Word) (instr Instruction) {
+	op, a, b := cpu.Decode(code[addr])
+
+	instr.Addr = addr
+	instr.Size = 1
+	instr.Words = code[int(addr) : int(addr)+1]
+
+	if op == cpu.EXT {
+		if int(a) < len(extended) {
+			instr.Name = extended[a]
+		}
+
+		instr.Opcode = a
+		instr.Ext = true
+
+		if instr.Name == "" {
+			return
+		}
+
+		if a != cpu.EXIT {
+			instr.Args = []Operand{{Code: b}}
+		} else if b != 0 {
+			// EXIT takes no arguments. The assembler always
+			// encodes it as zero.
+			instr.Name = ""
+			return
+		}
+	} else {
+		instr.Name = basic[op]
+		instr.Opcode = op
+
+		if instr.Name == "" {
+			return
+		}
+
+		instr.Args = []Operand{{Code: a, Target: true}, {Code: b}}
+	}
+
+	size := cpu.Sizeof(op, a, b)
+	if int(addr)+int(size) > len(code) {
+		// Truncated instruction.
+		instr.Name = ""
+		instr.Args = nil
+		return
+	}
+
+	next := addr + 1
+	for i := range instr.Args {
+		if instr.Args[i].HasWord() {
+			instr.Args[i].Value = code[next]
+			next++
+		}
+	}
+
+	instr.Size = size
+	instr.Words = code[int(addr) : int(addr)+int(size)]
+	return
+}
+
+// Valid returns true if the instruction was decoded successfully.
+func (i *Instruction) Valid() bool { return i.Name != "" }
+
+// Canonical returns true if assembling the instruction's textual form
+// yields the exact same encoding. The assembler picks the smallest
+// encoding for each operand, once all label addresses are known. This
+// is not the case for literals which could have been encoded in short
+// form, but were not. Nor for `set pc, x` with a target close enough to
+// be reached with `add pc, n` or `sub pc, n`.
+//
+// Invalid instructions are always canonical, as they are formatted
+// as plain data.
+func (i *Instruction) Canonical(labels map[cpu.Word]string) bool {
+	if !i.Valid() {
+		return true
+	}
+
+	for _, arg := range i.Args {
+		if !arg.Target && arg.Code == 0x1f && isShort(arg.Value) {
+			return false
+		}
+	}
+
+	if i.isJump() && i.Args[1].Code == 0x1f {
+		pc := i.Addr + 1
+		dist := i.Args[1].Value - pc
+
+		if i.Args[1].Value < pc {
+			dist = pc - i.Args[1].Value
+		}
+
+		if dist <= 0x1e {
+			return false
+		}
+	}
+
+	return true
+}
+
+// String returns the instruction in source form.
+func (i *Instruction) String() string { return i.Format(nil) }
+
+// Format returns the instruction in source form. Literals which match
+// the address of a label in the given map, are replaced by the label name.
+func (i *Instruction) Format(labels map[cpu.Word]string) string {
+	if !i.Valid() {
+		return fmt.Sprintf("dat 0x%04x", i.Words[0])
+	}
+
+	s := i.Name
+
+	for n := range i.Args {
+		if n == 0 {
+			s += " "
+		} else {
+			s += ", "
+		}
+
+		s += i.Args[n].format(labels, i.isTarget(n))
+	}
+
+	return s
+}
+
+// isJump returns true if this is a `set pc, x` instruction.
+func (i *Instruction) isJump() bool {
+	return !i.Ext && i.Opcode == cpu.SET && i.Args[0].Code == 0x1c
+}
+
+// isTarget returns true if the given operand is a jump target.
+func (i *Instruction) isTarget(n int) bool {
+	if i.Ext {
+		return i.Opcode == cpu.JSR
+	}
+	return n == 1 && i.isJump()
+}
+
+// HasWord returns true if the operand reads the next instruction word.
+func (o Operand) HasWord() bool {
+	return o.Code == 0x1a || o.Code == 0x1e || o.Code == 0x1f ||
+		(o.Code >= 0x10 && o.Code <= 0x17)
+}
+
+// String returns the operand in source form.
+func (o Operand) String() string { return o.format(nil, false) }
+
+// format returns the operand in source form. Literals which match the
+// address of a label, are replaced by the label name. This is done for
+// short and long forms alike, as the assembler picks either one for
+// label references.
+func (o Operand) format(labels map[cpu.Word]string, jump bool) string {
+	switch {
+	case o.Code <= 0x07:
+		return registers[o.Code]
+
+	case o.Code <= 0x0f:
+		return fmt.Sprintf("[%s]", registers[o.Code-0x08])
+
+	case o.Code <= 0x17:
+		return fmt.Sprintf("[%s + %s]", value(o.Value, labels, false), registers[o.Code-0x10])
+
+	case o.Code == 0x18:
+		if o.Target {
+			return "push"
+		}
+		return "pop"
+
+	case o.Code == 0x19:
+		return "peek"
+
+	case o.Code == 0x1a:
+		return fmt.Sprintf("[sp + %s]", literal(o.Value))
+
+	case o.Code == 0x1b:
+		return "sp"
+
+	case o.Code == 0x1c:
+		return "pc"
+
+	case o.Code == 0x1d:
+		return "ex"
+
+	case o.Code == 0x1e:
+		return fmt.Sprintf("[%s]", value(o.Value, labels, false))
+
+	case o.Code == 0x1f:
+		return value(o.Value, labels, jump)
+	}
+
+	// Short form literal.
+	return value(o.Code-0x21, labels, jump)
+}
+
+// value returns the given value as a label name if there is one
+// at this address. Otherwise it returns the literal value.
+//
+// Zero is far more often a plain number than the address of the
+// program's first label. It is only replaced in jump targets.
+func value(v cpu.Word, labels map[cpu.Word]string, jump bool) string {
+	if v == 0 && !jump {
+		return literal(v)
+	}
+
+	if name, ok := labels[v]; ok {
+		return name
+	}
+	return literal(v)
+}
+
+// literal formats the given value as a number.
+func literal(v cpu.Word) string {
+	if v <= 9 {
+		return fmt.Sprintf("%d", v)
+	}
+	return fmt.Sprintf("0x%04x", v)
+}
+
+// isShort returns true if the assembler would encode the given
+// literal in short form.
+func isShort(v cpu.Word) bool {
+	return v == 0xffff || v <= 0x1e
+}
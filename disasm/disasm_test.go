@@ -0,0 +1,152 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package disasm
+
+import (
+	"bytes"
+	"github.com/jteeuwen/dcpu/asm"
+	"github.com/jteeuwen/dcpu/cpu"
+	"github.com/jteeuwen/dcpu/parser"
+	"strings"
+	"testing"
+)
+
+func assemble(t *testing.T, src string) ([]cpu.Word, *asm.DebugInfo) {
+	var ast parser.AST
+
+	if err := ast.Parse(bytes.NewBufferString(src), ""); err != nil {
+		t.Fatal(err)
+	}
+
+	bin, dbg, err := asm.Assemble(&ast)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return bin, dbg
+}
+
+func TestDecode(t *testing.T) {
+	code := []cpu.Word{
+		cpu.Encode(cpu.SET, 0x10, 0x1f), 0x1234, 0x100,
+		cpu.Encode(cpu.EXT, cpu.JSR, 0x22),
+		cpu.Encode(cpu.SET, 0x18, 0x1a), 3,
+		cpu.Encode(0x18, 0, 0),
+		cpu.Encode(cpu.EXT, cpu.EXIT, 0),
+	}
+
+	want := []string{
+		"set [0x1234 + a], 0x0100",
+		"jsr 1",
+		"set push, [sp + 3]",
+		"dat 0x0018",
+		"exit",
+	}
+
+	list := Disassemble(code)
+	if len(list) != len(want) {
+		t.Fatalf("Want %d instructions, got %d", len(want), len(list))
+	}
+
+	for i := range list {
+		if s := list[i].String(); s != want[i] {
+			t.Fatalf("Instruction %d: want %q, got %q", i, want[i], s)
+		}
+	}
+}
+
+// disassemble formats the given program as source code, using the
+// labels from the debug symbols. Fails if any instruction is not
+// canonical.
+func disassemble(t *testing.T, bin []cpu.Word, dbg *asm.DebugInfo) string {
+	var src bytes.Buffer
+
+	labels := make(map[cpu.Word]string)
+	for _, l := range dbg.Labels {
+		labels[l.Addr] = l.Name
+	}
+
+	for _, instr := range Disassemble(bin) {
+		if name, ok := labels[instr.Addr]; ok {
+			src.WriteString(":" + name + "\n")
+		}
+
+		if !instr.Canonical(labels) {
+			t.Fatalf("%04x: %s is not canonical", instr.Addr, instr.Format(labels))
+		}
+
+		src.WriteString(instr.Format(labels) + "\n")
+	}
+
+	// Labels at the end of the program.
+	for _, l := range dbg.Labels {
+		if int(l.Addr) >= len(bin) {
+			src.WriteString(":" + l.Name + "\n")
+		}
+	}
+
+	return src.String()
+}
+
+// Ensure that disassembling and then re-assembling a program
+// yields the original program and source.
+func TestRoundtrip(t *testing.T) {
+	want := `:main
+set a, 0
+set i, 0
+set [data + i], 0x0030
+:loop
+add i, 1
+ifn i, end
+sub pc, 4
+jsr sub
+ife a, 0
+jsr main
+set push, [sp + 1]
+set pc, end
+:sub
+set pc, pop
+`
+	// The program is padded so `end` does not fit in a short literal.
+	pad := strings.Repeat("dat 0x0000\n", 0x20)
+
+	bin, dbg := assemble(t, want+pad+":end\nexit\n:data\n")
+	src := disassemble(t, bin, dbg)
+
+	if !strings.HasPrefix(src, want) {
+		t.Fatalf("Source mismatch.\nWant:\n%s\nHave:\n%s", want, src)
+	}
+
+	out, _ := assemble(t, src)
+
+	if len(out) != len(bin) {
+		t.Fatalf("Size mismatch. Want %d, got %d\n%s", len(bin), len(out), src)
+	}
+
+	for i := range bin {
+		if bin[i] != out[i] {
+			t.Fatalf("Code mismatch at %d. Want %04x, got %04x\n%s", i, bin[i], out[i], src)
+		}
+	}
+}
+
+func TestCanonical(t *testing.T) {
+	for _, v := range []struct {
+		code []cpu.Word
+		want bool
+	}{
+		{[]cpu.Word{cpu.Encode(cpu.SET, 0, 0x21+5)}, true},
+		{[]cpu.Word{cpu.Encode(cpu.SET, 0, 0x1f), 5}, false},
+		{[]cpu.Word{cpu.Encode(cpu.SET, 0, 0x1f), 0x100}, true},
+		{[]cpu.Word{cpu.Encode(cpu.EXT, cpu.JSR, 0x1f), 0x10}, false},
+		{[]cpu.Word{cpu.Encode(cpu.SET, 0x1c, 0x1f), 0x1f}, false},
+		{[]cpu.Word{cpu.Encode(cpu.SET, 0x1c, 0x1f), 0x100}, true},
+	} {
+		instr := Decode(v.code, 0)
+
+		if have := instr.Canonical(nil); have != v.want {
+			t.Fatalf("%s: want canonical %v, got %v", instr.String(), v.want, have)
+		}
+	}
+}
@@ -0,0 +1,55 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package disasm
+
+import "github.com/jteeuwen/dcpu/cpu"
+
+// Mnemonics for basic opcodes. Empty entries denote invalid opcodes.
+var basic = [...]string{
+	cpu.SET: "set",
+	cpu.ADD: "add",
+	cpu.SUB: "sub",
+	cpu.MUL: "mul",
+	cpu.MLI: "mli",
+	cpu.DIV: "div",
+	cpu.DVI: "dvi",
+	cpu.MOD: "mod",
+	cpu.MDI: "mdi",
+	cpu.AND: "and",
+	cpu.BOR: "bor",
+	cpu.XOR: "xor",
+	cpu.SHR: "shr",
+	cpu.ASR: "asr",
+	cpu.SHL: "shl",
+	cpu.IFB: "ifb",
+	cpu.IFC: "ifc",
+	cpu.IFE: "ife",
+	cpu.IFN: "ifn",
+	cpu.IFG: "ifg",
+	cpu.IFA: "ifa",
+	cpu.IFL: "ifl",
+	cpu.IFU: "ifu",
+	cpu.ADX: "adx",
+	cpu.SBX: "sbx",
+	cpu.STI: "sti",
+	cpu.STD: "std",
+}
+
+// Mnemonics for extended opcodes. Empty entries denote invalid opcodes.
+var extended = [...]string{
+	cpu.JSR:   "jsr",
+	cpu.INT:   "int",
+	cpu.IAG:   "iag",
+	cpu.IAS:   "ias",
+	cpu.RFI:   "rfi",
+	cpu.IAQ:   "iaq",
+	cpu.HWN:   "hwn",
+	cpu.HWQ:   "hwq",
+	cpu.HWI:   "hwi",
+	cpu.PANIC: "panic",
+	cpu.EXIT:  "exit",
+}
+
+// Register names, indexed by operand code.
+var registers = [...]string{"a", "b", "c", "x", "y", "z", "i", "j"}
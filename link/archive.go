@@ -0,0 +1,77 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package link
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/jteeuwen/dcpu/asm"
+	"io"
+	"io/ioutil"
+)
+
+// Version of the archive file format.
+const ArchiveVersion = 1
+
+// Archive is a collection of objects, like a library.
+// The linker only includes the members it needs.
+type Archive struct {
+	Version int
+	Members []Member
+}
+
+// Member is a single object in an archive.
+type Member struct {
+	Name   string // Name of the object file it was created from.
+	Object *asm.Object
+}
+
+// NewArchive creates an empty archive.
+func NewArchive() *Archive {
+	return &Archive{Version: ArchiveVersion}
+}
+
+// Add appends the given object.
+func (a *Archive) Add(name string, obj *asm.Object) {
+	a.Members = append(a.Members, Member{name, obj})
+}
+
+// WriteArchive writes the given archive.
+func WriteArchive(w io.Writer, a *Archive) (err error) {
+	data, err := json.MarshalIndent(a, "", " ")
+	if err != nil {
+		return
+	}
+
+	_, err = w.Write(data)
+	return
+}
+
+// ReadArchive reads an archive, as written by WriteArchive.
+func ReadArchive(r io.Reader) (a *Archive, err error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return
+	}
+
+	a = new(Archive)
+	if err = json.Unmarshal(data, a); err != nil {
+		return nil, err
+	}
+
+	if a.Version != ArchiveVersion {
+		return nil, errors.New(fmt.Sprintf(
+			"Unsupported archive version %d. Want %d.", a.Version, ArchiveVersion))
+	}
+
+	for i, m := range a.Members {
+		if m.Object == nil || m.Object.Version != asm.ObjectVersion {
+			return nil, errors.New(fmt.Sprintf(
+				"Invalid archive member %d: %s.", i, m.Name))
+		}
+	}
+
+	return
+}
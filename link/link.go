@@ -0,0 +1,231 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+// DCPU linker package.
+package link
+
+import (
+	"errors"
+	"fmt"
+	"github.com/jteeuwen/dcpu/asm"
+	"github.com/jteeuwen/dcpu/cpu"
+)
+
+// linker holds linker state.
+type linker struct {
+	objects  []*asm.Object
+	archives []*Archive
+	symbols  map[string]int // Maps exported symbols to the object defining them.
+	included map[*asm.Object]bool
+}
+
+// Link combines the given objects into a single program, which is
+// meant to be loaded at the given base address.
+//
+// All objects are included, in the given order. Archive members are
+// appended only when they export a symbol which is imported, but
+// not defined by any of the objects included so far.
+//
+// Debug symbols hold absolute addresses. Their source mapping is
+// indexed by address, like that of the assembler. Entries below the
+// base address are empty.
+func Link(objects []*asm.Object, archives []*Archive, base cpu.Word) (prog []cpu.Word, dbg *asm.DebugInfo, err error) {
+	var l linker
+	l.archives = archives
+	l.symbols = make(map[string]int)
+	l.included = make(map[*asm.Object]bool)
+
+	for _, obj := range objects {
+		if err = l.add(obj); err != nil {
+			return
+		}
+	}
+
+	if err = l.resolve(); err != nil {
+		return
+	}
+
+	return l.build(base)
+}
+
+// add includes the given object and registers its exports.
+func (l *linker) add(obj *asm.Object) error {
+	for _, sym := range obj.Exports {
+		if index, ok := l.symbols[sym.Name]; ok {
+			return errors.New(fmt.Sprintf(
+				"Duplicate symbol %q in %s. First defined in %s.",
+				sym.Name, objectName(obj), objectName(l.objects[index])))
+		}
+
+		l.symbols[sym.Name] = len(l.objects)
+	}
+
+	l.objects = append(l.objects, obj)
+	l.included[obj] = true
+	return nil
+}
+
+// resolve includes archive members until all imports are defined.
+func (l *linker) resolve() (err error) {
+	for i := 0; i < len(l.objects); i++ {
+		for _, name := range l.objects[i].Imports {
+			if _, ok := l.symbols[name]; ok {
+				continue
+			}
+
+			obj := l.find(name)
+			if obj == nil {
+				return errors.New(fmt.Sprintf(
+					"Undefined symbol %q in %s.", name, objectName(l.objects[i])))
+			}
+
+			if err = l.add(obj); err != nil {
+				return
+			}
+		}
+	}
+
+	return
+}
+
+// find returns the first archive member which exports the given
+// symbol and has not been included yet. Returns nil if there is none.
+func (l *linker) find(name string) *asm.Object {
+	for _, ar := range l.archives {
+		for _, m := range ar.Members {
+			if l.included[m.Object] {
+				continue
+			}
+
+			for _, sym := range m.Object.Exports {
+				if sym.Name == name {
+					return m.Object
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// build lays out all included objects, starting at the given
+// base address, and applies their relocations.
+func (l *linker) build(base cpu.Word) (prog []cpu.Word, dbg *asm.DebugInfo, err error) {
+	start := make([]cpu.Word, len(l.objects))
+	size := 0
+
+	for i, obj := range l.objects {
+		start[i] = base + cpu.Word(size)
+		size += len(obj.Code)
+	}
+
+	if int(base)+size > cpu.MemSize {
+		return nil, nil, errors.New(fmt.Sprintf(
+			"Program size of %d words at base %04x exceeds available memory.", size, base))
+	}
+
+	labels := make(map[string]cpu.Word)
+
+	for name, index := range l.symbols {
+		for _, sym := range l.objects[index].Exports {
+			if sym.Name == name {
+				labels[name] = start[index] + sym.Addr
+				break
+			}
+		}
+	}
+
+	prog = make([]cpu.Word, 0, size)
+	dbg = new(asm.DebugInfo)
+	dbg.SourceMapping = make([]asm.SourceInfo, base, int(base)+size)
+
+	for i, obj := range l.objects {
+		code := make([]cpu.Word, len(obj.Code))
+		copy(code, obj.Code)
+
+		for _, r := range obj.Relocations {
+			if int(r.Addr) >= len(code) {
+				return nil, nil, errors.New(fmt.Sprintf(
+					"Invalid relocation at %04x in %s.", r.Addr, objectName(obj)))
+			}
+
+			if len(r.Symbol) == 0 {
+				code[r.Addr] += start[i]
+			} else {
+				code[r.Addr] += labels[r.Symbol]
+			}
+		}
+
+		prog = append(prog, code...)
+		mergeDebug(dbg, obj.Debug, start[i], len(code))
+	}
+
+	// Exports are normally part of the objects' own labels already.
+	// Objects without debug symbols still need them listed.
+	for name, addr := range labels {
+		if !hasLabel(dbg.Labels, name, addr) {
+			dbg.Labels = append(dbg.Labels, asm.LabelInfo{Name: name, Addr: addr})
+		}
+	}
+
+	dbg.SortLabels()
+	return
+}
+
+// hasLabel returns true if the list holds the given label.
+func hasLabel(list []asm.LabelInfo, name string, addr cpu.Word) bool {
+	for _, l := range list {
+		if l.Name == name && l.Addr == addr {
+			return true
+		}
+	}
+	return false
+}
+
+// mergeDebug appends the debug symbols of an object which
+// starts at the given address. This includes the object's private
+// labels, so they still show up in debuggers and disassemblers.
+func mergeDebug(dst, src *asm.DebugInfo, start cpu.Word, size int) {
+	files := len(dst.Files)
+
+	if src == nil {
+		src = new(asm.DebugInfo)
+	}
+
+	for _, f := range src.Files {
+		f.StartAddr += start
+		dst.Files = append(dst.Files, f)
+	}
+
+	for _, l := range src.Labels {
+		l.Addr += start
+		dst.Labels = append(dst.Labels, l)
+	}
+
+	for _, f := range src.Functions {
+		f.StartAddr += start
+		f.EndAddr += start
+		dst.Functions = append(dst.Functions, f)
+	}
+
+	for i := 0; i < size; i++ {
+		var si asm.SourceInfo
+
+		if i < len(src.SourceMapping) {
+			si = src.SourceMapping[i]
+			si.File += files
+		}
+
+		dst.SourceMapping = append(dst.SourceMapping, si)
+	}
+}
+
+// objectName returns a name for the given object, for use in
+// error messages. This is the first source file it was built from.
+func objectName(obj *asm.Object) string {
+	if obj.Debug == nil || len(obj.Debug.Files) == 0 {
+		return "<unknown>"
+	}
+
+	return obj.Debug.Files[0].Name
+}
@@ -0,0 +1,152 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package link
+
+import (
+	"bytes"
+	"github.com/jteeuwen/dcpu/asm"
+	"github.com/jteeuwen/dcpu/cpu"
+	"github.com/jteeuwen/dcpu/parser"
+	"testing"
+)
+
+func object(t *testing.T, file, src string) *asm.Object {
+	var ast parser.AST
+
+	if err := ast.Parse(bytes.NewBufferString(src), file); err != nil {
+		t.Fatal(err)
+	}
+
+	obj, err := asm.AssembleObject(&ast)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return obj
+}
+
+func TestLink(t *testing.T) {
+	main := object(t, "main.dasm",
+		`jsr inc
+		 exit`)
+
+	inc := object(t, "inc.dasm",
+		`:inc
+		 add a, 1
+		 set pc, pop`)
+
+	unused := object(t, "unused.dasm",
+		`:unused
+		 set pc, pop`)
+
+	ar := NewArchive()
+	ar.Add("unused.o", unused)
+	ar.Add("inc.o", inc)
+
+	prog, dbg, err := Link([]*asm.Object{main}, []*Archive{ar}, 0x100)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []cpu.Word{
+		cpu.Encode(cpu.EXT, cpu.JSR, 0x1f), 0x0103,
+		cpu.Encode(cpu.EXT, cpu.EXIT, 0),
+		cpu.Encode(cpu.ADD, 0x00, 0x22),
+		cpu.Encode(cpu.SET, 0x1c, 0x18),
+	}
+
+	if len(prog) != len(want) {
+		t.Fatalf("Want %04x, got %04x", want, prog)
+	}
+
+	for i := range want {
+		if prog[i] != want[i] {
+			t.Fatalf("Want %04x, got %04x", want, prog)
+		}
+	}
+
+	if len(dbg.Files) != 2 || dbg.Files[1].StartAddr != 0x103 {
+		t.Fatalf("Want inc.dasm to start at 0103, got %v", dbg.Files)
+	}
+
+	if len(dbg.Labels) != 1 || dbg.Labels[0].Addr != 0x103 {
+		t.Fatalf("Want label inc at 0103, got %v", dbg.Labels)
+	}
+
+	// The source mapping is indexed by address.
+	if len(dbg.SourceMapping) != 0x105 {
+		t.Fatalf("Want source mapping up to 0105, got %d entries", len(dbg.SourceMapping))
+	}
+
+	if si := dbg.SourceMapping[0x103]; si.File != 1 || si.Line != 2 {
+		t.Fatalf("Want inc.dasm:2 at 0103, got %v", si)
+	}
+
+	if si := dbg.SourceMapping[0x102]; si.File != 0 || si.Line != 2 {
+		t.Fatalf("Want main.dasm:2 at 0102, got %v", si)
+	}
+}
+
+func TestLinkErrors(t *testing.T) {
+	a := object(t, "a.dasm", `jsr bar`)
+	b := object(t, "b.dasm", `:bar`)
+	x := object(t, "x/foo.dasm", `:foo`)
+	y := object(t, "y/foo.dasm", `:foo`)
+
+	if _, _, err := Link([]*asm.Object{a}, nil, 0); err == nil {
+		t.Fatalf("Want undefined symbol error")
+	}
+
+	if _, _, err := Link([]*asm.Object{a, b}, nil, 0); err == nil {
+		t.Fatalf("Want undefined symbol error for private label")
+	}
+
+	if _, _, err := Link([]*asm.Object{x, y}, nil, 0); err == nil {
+		t.Fatalf("Want duplicate symbol error")
+	}
+}
+
+// Labels which are not named after their file are private.
+func TestLinkPrivate(t *testing.T) {
+	main := object(t, "main.dasm",
+		`:loop
+		 jsr inc
+		 set pc, loop`)
+
+	inc := object(t, "inc.dasm",
+		`:inc
+		 ife a, 0
+		    set pc, loop
+		 set pc, pop
+		:loop
+		 add a, 1
+		 set pc, pop`)
+
+	prog, dbg, err := Link([]*asm.Object{main, inc}, nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Each object jumps to its own loop.
+	if prog[3] != 0 || prog[6] != 0x0008 {
+		t.Fatalf("Private label mismatch: %04x", prog)
+	}
+
+	// Private labels are still in the debug symbols.
+	want := []asm.LabelInfo{
+		{Name: "loop", Addr: 0x0000},
+		{Name: "inc", Addr: 0x0004},
+		{Name: "loop", Addr: 0x0008},
+	}
+
+	if len(dbg.Labels) != len(want) {
+		t.Fatalf("Want labels %v, got %v", want, dbg.Labels)
+	}
+
+	for i := range want {
+		if dbg.Labels[i] != want[i] {
+			t.Fatalf("Want labels %v, got %v", want, dbg.Labels)
+		}
+	}
+}
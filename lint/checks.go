@@ -0,0 +1,490 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package lint
+
+import (
+	"github.com/jteeuwen/dcpu/parser"
+	"path/filepath"
+	"strings"
+)
+
+// Instructions which write to their first operand.
+var writers = map[string]bool{
+	"set": true, "add": true, "sub": true, "mul": true, "mli": true,
+	"div": true, "dvi": true, "mod": true, "mdi": true, "and": true,
+	"bor": true, "xor": true, "shr": true, "asr": true, "shl": true,
+	"adx": true, "sbx": true, "sti": true, "std": true, "iag": true,
+	"hwn": true,
+}
+
+// Instructions which do not produce executable code.
+var nonCode = map[string]bool{
+	"dat": true, "equ": true, "org": true,
+	"reserve": true, "fill": true, "align": true,
+}
+
+// Pseudo-instructions which produce data.
+var data = map[string]bool{
+	"dat": true, "reserve": true, "fill": true,
+}
+
+// Registers which are preserved across function calls.
+var protected = []string{"x", "y", "z", "i", "j"}
+
+// checkLiteralWrites finds instructions which write to a literal.
+// The CPU silently discards such writes.
+func (l *linter) checkLiteralWrites(list []parser.Node) {
+	for _, v := range list {
+		switch tt := v.(type) {
+		case *parser.Function:
+			l.checkLiteralWrites(tt.Children()[1:])
+
+		case *parser.Instruction:
+			name := instrName(tt)
+			args := tt.Children()[1:]
+
+			if !writers[name] || len(args) == 0 {
+				break
+			}
+
+			if isLiteral(args[0].(*parser.Expression)) {
+				l.warnf(tt, LiteralWrite,
+					"%s writes to a literal operand. The result is discarded.", name)
+			}
+		}
+	}
+}
+
+// checkUnreachable finds code which follows an unconditional jump,
+// and which is not preceded by a label.
+func (l *linter) checkUnreachable(list []parser.Node) {
+	var prev *parser.Instruction
+	var dead, reported bool
+
+	for _, v := range list {
+		switch tt := v.(type) {
+		case *parser.Label:
+			dead, reported = false, false
+
+		case *parser.Function:
+			l.checkUnreachable(tt.Children()[1:])
+			prev, dead, reported = nil, false, false
+
+		case *parser.Instruction:
+			if nonCode[instrName(tt)] {
+				break
+			}
+
+			if dead && !reported {
+				l.warnf(tt, Unreachable, "Unreachable code.")
+				reported = true
+			}
+
+			if isJump(tt) && !isBranch(prev) {
+				dead = true
+			}
+
+			prev = tt
+		}
+	}
+}
+
+// checkFallthrough finds data which can be reached by code running
+// into it. This is usually caused by a conditional jump or return,
+// right before a dat block.
+func (l *linter) checkFallthrough(list []parser.Node) {
+	var last *parser.Instruction // Last code instruction.
+	var falls bool               // Can execution run past it?
+	var file int
+
+	for _, v := range list {
+		// Code in a new file does not continue the previous one.
+		if v.File() != file {
+			last, falls, file = nil, false, v.File()
+		}
+
+		switch tt := v.(type) {
+		case *parser.Function:
+			l.checkFallthrough(tt.Children()[1:])
+			last, falls = nil, false
+
+		case *parser.Instruction:
+			name := instrName(tt)
+
+			if !nonCode[name] {
+				falls = !isJump(tt) || isBranch(last)
+				last = tt
+				break
+			}
+
+			if !data[name] || !falls {
+				break
+			}
+
+			if isJump(last) {
+				l.warnf(tt, Fallthrough,
+					"Execution falls through into data, if the preceding branch is not taken.")
+			} else {
+				l.warnf(tt, Fallthrough, "Execution falls through into data.")
+			}
+
+			falls = false
+		}
+	}
+}
+
+// checkUnused finds labels and constants which are never referenced.
+// Labels generated for macros are ignored, as are entry points.
+func (l *linter) checkUnused(list []parser.Node) {
+	var labels []*parser.Label
+	var consts []*parser.Name
+
+	refs := make(map[string]bool)
+	findDefinitions(list, &labels, &consts, refs)
+
+	if l.enable[UnusedLabel] {
+		for _, v := range labels {
+			if !refs[v.Data] && !strings.HasPrefix(v.Data, "__") && !l.isEntryPoint(v) {
+				l.warnf(v, UnusedLabel, "Label %q is never used.", v.Data)
+			}
+		}
+	}
+
+	if l.enable[UnusedConstant] {
+		for _, v := range consts {
+			// Defines have no line number. They are used by conditions,
+			// which are gone by now.
+			if !refs[v.Data] && v.Line() > 0 {
+				l.warnf(v, UnusedConstant, "Constant %q is never used.", v.Data)
+			}
+		}
+	}
+}
+
+// entryPoints lists labels where execution starts. Nothing in the
+// program needs to refer to them.
+var entryPoints = map[string]bool{
+	"main": true,
+}
+
+// isEntryPoint returns true if the given label is an entry point.
+// This includes labels which share the name of their file. Other
+// programs include the file when they refer to its name.
+func (l *linter) isEntryPoint(label *parser.Label) bool {
+	if entryPoints[label.Data] {
+		return true
+	}
+
+	file := filepath.Base(l.ast.Files[label.File()])
+	return strings.TrimSuffix(file, filepath.Ext(file)) == label.Data
+}
+
+// findDefinitions finds label and constant definitions, along with
+// the names which are referenced in instruction operands.
+func findDefinitions(list []parser.Node, labels *[]*parser.Label, consts *[]*parser.Name, refs map[string]bool) {
+	for _, v := range list {
+		switch tt := v.(type) {
+		case *parser.Label:
+			*labels = append(*labels, tt)
+
+		case *parser.Function:
+			findDefinitions(tt.Children()[1:], labels, consts, refs)
+
+		case *parser.Instruction:
+			args := tt.Children()[1:]
+
+			if instrName(tt) == "equ" {
+				expr := args[0].(*parser.Expression)
+				*consts = append(*consts, expr.Children()[0].(*parser.Name))
+				args = args[1:]
+			}
+
+			for _, arg := range args {
+				findNames(arg.(*parser.Expression), refs)
+			}
+		}
+	}
+}
+
+// findNames adds all names in the given node to refs.
+func findNames(n parser.NodeCollection, refs map[string]bool) {
+	for _, v := range n.Children() {
+		switch tt := v.(type) {
+		case *parser.Name:
+			refs[tt.Data] = true
+
+		case parser.NodeCollection:
+			findNames(tt, refs)
+		}
+	}
+}
+
+// checkClobber finds calls from functions defined with `def`, to
+// subroutines which change protected registers without restoring them.
+//
+// A function only preserves the protected registers it uses itself.
+// Changes made by the subroutine are visible to the function's caller.
+func (l *linter) checkClobber(list []parser.Node) {
+	c := clobberChecker{
+		root:  list,
+		funcs: make(map[string]bool),
+		cache: make(map[string][]string),
+	}
+
+	for _, v := range list {
+		if f, ok := v.(*parser.Function); ok {
+			c.funcs[f.Children()[0].(*parser.Name).Data] = true
+		}
+	}
+
+	for _, v := range list {
+		f, ok := v.(*parser.Function)
+		if !ok {
+			continue
+		}
+
+		name := f.Children()[0].(*parser.Name).Data
+		used := make(map[string]bool)
+		findNames(f, used)
+
+		for _, n := range f.Children()[1:] {
+			instr, ok := n.(*parser.Instruction)
+			if !ok || instrName(instr) != "jsr" {
+				continue
+			}
+
+			target := labelOperand(instr)
+			if len(target) == 0 {
+				continue
+			}
+
+			var regs []string
+
+			for _, r := range c.clobbers(target) {
+				if !used[r] {
+					regs = append(regs, r)
+				}
+			}
+
+			if len(regs) > 0 {
+				l.warnf(instr, Clobber,
+					"Call to %q changes protected register(s) %s, which %q does not preserve.",
+					target, strings.Join(regs, ", "), name)
+			}
+		}
+	}
+}
+
+// clobberChecker finds protected registers which are changed by subroutines.
+type clobberChecker struct {
+	root  []parser.Node
+	funcs map[string]bool     // Names of functions defined with `def`.
+	cache map[string][]string // Clobbered registers per subroutine.
+}
+
+// clobbers returns the protected registers which are changed and not
+// restored by the subroutine at the given label.
+//
+// The subroutine is assumed to run from the label up to the first
+// unconditional `set pc, pop`. Registers pushed onto the stack before
+// they are changed, are considered to be restored. Calls to other
+// subroutines are followed.
+func (c *clobberChecker) clobbers(label string) []string {
+	// Functions preserve protected registers by definition.
+	if c.funcs[label] {
+		return nil
+	}
+
+	if regs, ok := c.cache[label]; ok {
+		return regs
+	}
+
+	// Guards against recursion.
+	c.cache[label] = nil
+
+	code := findCode(c.root, label)
+	pushed := make(map[string]bool)
+	changed := make(map[string]bool)
+
+	var prev *parser.Instruction
+
+	for _, v := range code {
+		instr, ok := v.(*parser.Instruction)
+		if !ok {
+			continue
+		}
+
+		name := instrName(instr)
+		if nonCode[name] {
+			continue
+		}
+
+		switch name {
+		case "jsr":
+			if target := labelOperand(instr); len(target) > 0 {
+				for _, r := range c.clobbers(target) {
+					changed[r] = changed[r] || !pushed[r]
+				}
+			}
+
+		case "sti", "std":
+			changed["i"] = changed["i"] || !pushed["i"]
+			changed["j"] = changed["j"] || !pushed["j"]
+		}
+
+		if writers[name] {
+			dst := operandName(instr, 0)
+
+			if dst == "push" {
+				if src := operandName(instr, 1); isProtected(src) {
+					pushed[src] = true
+				}
+			} else if isProtected(dst) && !pushed[dst] {
+				changed[dst] = true
+			}
+		}
+
+		if isReturn(instr) && !isBranch(prev) {
+			break
+		}
+
+		prev = instr
+	}
+
+	var regs []string
+
+	for _, r := range protected {
+		if changed[r] {
+			regs = append(regs, r)
+		}
+	}
+
+	c.cache[label] = regs
+	return regs
+}
+
+// findCode returns the nodes which follow the given label.
+func findCode(list []parser.Node, label string) []parser.Node {
+	for i, v := range list {
+		switch tt := v.(type) {
+		case *parser.Label:
+			if tt.Data == label {
+				return list[i+1:]
+			}
+
+		case *parser.Function:
+			if code := findCode(tt.Children()[1:], label); code != nil {
+				return code
+			}
+		}
+	}
+
+	return nil
+}
+
+// instrName returns the name of the given instruction.
+func instrName(instr *parser.Instruction) string {
+	return instr.Children()[0].(*parser.Name).Data
+}
+
+// operandName returns the name making up the given operand. It returns
+// an empty string if the operand is anything other than a single name.
+func operandName(instr *parser.Instruction, index int) string {
+	args := instr.Children()[1:]
+	if index >= len(args) {
+		return ""
+	}
+
+	list := parser.StripComments(args[index].(*parser.Expression).Children())
+	if len(list) != 1 {
+		return ""
+	}
+
+	if name, ok := list[0].(*parser.Name); ok {
+		return name.Data
+	}
+
+	return ""
+}
+
+// labelOperand returns the label named by the first operand of the
+// given instruction. It returns an empty string if it is not a label.
+func labelOperand(instr *parser.Instruction) string {
+	name := operandName(instr, 0)
+	if len(name) == 0 || parser.IsRegister(name) {
+		return ""
+	}
+	return name
+}
+
+// isLiteral returns true if the given operand holds no register,
+// memory reference or stack operation.
+func isLiteral(expr *parser.Expression) bool {
+	list := parser.StripComments(expr.Children())
+	if len(list) == 0 {
+		return false
+	}
+
+	for _, v := range list {
+		switch tt := v.(type) {
+		case *parser.Block:
+			return false
+
+		case *parser.Name:
+			if parser.IsRegister(tt.Data) || tt.Data == "pick" {
+				return false
+			}
+
+		case *parser.Expression:
+			if !isLiteral(tt) {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+// isJump returns true if the given instruction changes the program
+// counter, or ends execution.
+func isJump(instr *parser.Instruction) bool {
+	if instr == nil {
+		return false
+	}
+
+	switch name := instrName(instr); name {
+	case "rfi", "return", "exit", "panic":
+		return true
+
+	default:
+		return writers[name] && operandName(instr, 0) == "pc"
+	}
+}
+
+// isReturn returns true if the given instruction returns from
+// a subroutine.
+func isReturn(instr *parser.Instruction) bool {
+	switch instrName(instr) {
+	case "rfi", "return":
+		return true
+	case "set":
+		return operandName(instr, 0) == "pc" && operandName(instr, 1) == "pop"
+	}
+	return false
+}
+
+// isBranch returns true if the given instruction is a conditional.
+func isBranch(instr *parser.Instruction) bool {
+	return instr != nil && parser.IsBranch(instrName(instr))
+}
+
+// isProtected returns true if the given name is a protected register.
+func isProtected(name string) bool {
+	for _, v := range protected {
+		if v == name {
+			return true
+		}
+	}
+	return false
+}
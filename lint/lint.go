@@ -0,0 +1,210 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+// DCPU source code linter package.
+package lint
+
+import (
+	"errors"
+	"fmt"
+	"github.com/jteeuwen/dcpu/parser"
+	"sort"
+	"strings"
+)
+
+// A Category identifies a kind of warning.
+type Category string
+
+// Known warning categories.
+const (
+	LiteralWrite   Category = "literal-write"   // Writes to a literal operand.
+	Unreachable    Category = "unreachable"     // Code after an unconditional jump.
+	UnusedLabel    Category = "unused-label"    // Labels which are never referenced.
+	UnusedConstant Category = "unused-constant" // Constants which are never referenced.
+	Fallthrough    Category = "fallthrough"     // Execution running into data.
+	Clobber        Category = "clobber"         // Calls which clobber protected registers.
+)
+
+// Categories lists all warning categories.
+var Categories = []Category{
+	LiteralWrite, Unreachable, UnusedLabel,
+	UnusedConstant, Fallthrough, Clobber,
+}
+
+// Prefix of comments which suppress warnings.
+const ignoreDirective = "lint:ignore"
+
+// A Warning describes a suspicious piece of code.
+type Warning struct {
+	File     string
+	Msg      string
+	Category Category
+	Line     int
+	Col      int
+}
+
+func (w *Warning) String() string {
+	return fmt.Sprintf("%s:%d:%d [%s] %s", w.File, w.Line, w.Col, w.Category, w.Msg)
+}
+
+// Lint checks the given AST for suspicious code and returns the
+// warnings it finds, sorted by source position. Only the given
+// categories are checked. If there are none, all of them are.
+//
+// The AST should have been expanded, so that macros, conditional
+// code and local labels have been dealt with.
+//
+// Warnings for a line are suppressed by a comment on that line:
+//
+//	set 5, a ; lint:ignore
+//	:unused  ; lint:ignore unused-label
+func Lint(ast *parser.AST, categories ...Category) []*Warning {
+	if len(categories) == 0 {
+		categories = Categories
+	}
+
+	l := linter{
+		ast:    ast,
+		enable: make(map[Category]bool),
+		ignore: make(map[position][]string),
+	}
+
+	for _, c := range categories {
+		l.enable[c] = true
+	}
+
+	if ast.Root == nil {
+		return nil
+	}
+
+	list := ast.Root.Children()
+	l.findSuppressions(list)
+
+	if l.enable[LiteralWrite] {
+		l.checkLiteralWrites(list)
+	}
+
+	if l.enable[Unreachable] {
+		l.checkUnreachable(list)
+	}
+
+	if l.enable[UnusedLabel] || l.enable[UnusedConstant] {
+		l.checkUnused(list)
+	}
+
+	if l.enable[Fallthrough] {
+		l.checkFallthrough(list)
+	}
+
+	if l.enable[Clobber] {
+		l.checkClobber(list)
+	}
+
+	sort.Sort(warningsByPos(l.warnings))
+	return l.warnings
+}
+
+// ParseCategories parses a comma separated list of category names.
+func ParseCategories(s string) ([]Category, error) {
+	var list []Category
+
+	for _, name := range strings.Split(s, ",") {
+		c := Category(strings.TrimSpace(name))
+
+		if !isCategory(c) {
+			return nil, errors.New(fmt.Sprintf("Unknown warning category %q.", c))
+		}
+
+		list = append(list, c)
+	}
+
+	return list, nil
+}
+
+// isCategory returns true if the given category is known.
+func isCategory(c Category) bool {
+	for _, v := range Categories {
+		if v == c {
+			return true
+		}
+	}
+	return false
+}
+
+// position identifies a source line.
+type position struct {
+	file, line int
+}
+
+// linter holds linter state.
+type linter struct {
+	ast      *parser.AST
+	warnings []*Warning
+	enable   map[Category]bool
+	ignore   map[position][]string // Suppressed categories per line. Empty means all.
+}
+
+// warnf adds a warning for the given node, unless it is suppressed.
+func (l *linter) warnf(n parser.Node, c Category, f string, argv ...interface{}) {
+	if list, ok := l.ignore[position{n.File(), n.Line()}]; ok {
+		if len(list) == 0 {
+			return
+		}
+
+		for _, v := range list {
+			if v == string(c) {
+				return
+			}
+		}
+	}
+
+	l.warnings = append(l.warnings, &Warning{
+		File:     l.ast.Files[n.File()],
+		Msg:      fmt.Sprintf(f, argv...),
+		Category: c,
+		Line:     n.Line(),
+		Col:      n.Col(),
+	})
+}
+
+// findSuppressions finds comments which suppress warnings.
+func (l *linter) findSuppressions(list []parser.Node) {
+	for _, v := range list {
+		switch tt := v.(type) {
+		case *parser.Comment:
+			s := strings.TrimSpace(tt.Data)
+			if !strings.HasPrefix(s, ignoreDirective) {
+				break
+			}
+
+			names := strings.FieldsFunc(s[len(ignoreDirective):], func(r rune) bool {
+				return r == ',' || r == ' ' || r == '\t'
+			})
+
+			key := position{tt.File(), tt.Line()}
+			l.ignore[key] = append(l.ignore[key], names...)
+
+		case parser.NodeCollection:
+			l.findSuppressions(tt.Children())
+		}
+	}
+}
+
+// warningsByPos sorts warnings by file, line and column.
+type warningsByPos []*Warning
+
+func (w warningsByPos) Len() int      { return len(w) }
+func (w warningsByPos) Swap(i, j int) { w[i], w[j] = w[j], w[i] }
+
+func (w warningsByPos) Less(i, j int) bool {
+	a, b := w[i], w[j]
+
+	switch {
+	case a.File != b.File:
+		return a.File < b.File
+	case a.Line != b.Line:
+		return a.Line < b.Line
+	}
+
+	return a.Col < b.Col
+}
@@ -0,0 +1,168 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package lint
+
+import (
+	"bytes"
+	"github.com/jteeuwen/dcpu/parser"
+	"testing"
+)
+
+// doTest lints the given source for a single category and checks that
+// warnings are reported for exactly the given lines.
+func doTest(t *testing.T, c Category, src string, lines ...int) {
+	var ast parser.AST
+
+	if err := ast.Parse(bytes.NewBufferString(src), ""); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ast.Expand(); err != nil {
+		t.Fatal(err)
+	}
+
+	list := Lint(&ast, c)
+
+	if len(list) != len(lines) {
+		t.Fatalf("%s: Want %d warnings, have %d: %v", c, len(lines), len(list), list)
+	}
+
+	for i, w := range list {
+		if w.Category != c {
+			t.Fatalf("%s: Unexpected category: %v", c, w)
+		}
+
+		if w.Line != lines[i] {
+			t.Fatalf("%s: Warning %d: Want line %d, have %d: %v", c, i, lines[i], w.Line, w)
+		}
+	}
+}
+
+func TestLiteralWrite(t *testing.T) {
+	doTest(t, LiteralWrite, `
+	equ FOO, 2
+	set 5, a
+	set a, 5
+	add FOO, 1
+	set [0x1000], 1
+	set [a + 1], 1
+	set push, a
+	hwn 3
+	hwn a
+	ife 1, a`, 3, 5, 9)
+}
+
+func TestUnreachable(t *testing.T) {
+	doTest(t, Unreachable, `
+	set pc, main
+	set a, 1
+	set b, 1
+:main
+	ife a, 0
+	   set pc, pop
+	set a, 0
+	sub pc, 1
+	dat 1, 2
+	set a, 1
+:next
+	set pc, pop`, 3, 11)
+}
+
+func TestUnused(t *testing.T) {
+	doTest(t, UnusedLabel, `
+	equ UNUSED, 1
+:main
+	set pc, loop
+:loop
+	set pc, loop
+:data
+	dat 0
+	def f
+	:.unused
+		return
+	end
+	macro m
+	:l
+	   set a, 0
+	endm
+	m`, 7, 10)
+
+	doTest(t, UnusedConstant, `
+	equ ONE, 1
+	equ TWO, 2
+	equ THREE, TWO + 1
+	set a, THREE`, 2)
+}
+
+func TestFallthrough(t *testing.T) {
+	doTest(t, Fallthrough, `
+	ife a, 0
+	   set pc, pop
+:table
+	dat 1, 2, 3
+	dat 4
+
+	set a, 0
+	set pc, pop
+	dat 5
+
+	set b, 1
+	reserve 2`, 5, 13)
+}
+
+func TestClobber(t *testing.T) {
+	doTest(t, Clobber, `
+	def f
+		jsr sub
+		jsr safe
+		jsr g
+		jsr indirect
+	end
+
+	def g
+		set x, 1
+		jsr sub
+	end
+
+:sub
+	set x, 1
+	ife a, 0
+		set pc, pop
+	set i, 2
+	set pc, pop
+	set j, 3
+
+:safe
+	set push, z
+	set z, 1
+	set z, pop
+	set pc, pop
+
+:indirect
+	jsr sub
+	set pc, pop`, 3, 6, 11)
+}
+
+func TestSuppress(t *testing.T) {
+	doTest(t, LiteralWrite, `
+	set 5, a ; lint:ignore
+	set 5, a ; lint:ignore literal-write
+	set 5, a ; lint:ignore unused-label
+	set 5, a`, 4, 5)
+}
+
+func TestParseCategories(t *testing.T) {
+	list, err := ParseCategories("unreachable, clobber")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(list) != 2 || list[0] != Unreachable || list[1] != Clobber {
+		t.Fatalf("Unexpected categories: %v", list)
+	}
+
+	if _, err = ParseCategories("foo"); err == nil {
+		t.Fatal("Expected error for unknown category.")
+	}
+}
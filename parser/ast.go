@@ -14,8 +14,16 @@ import (
 
 // An Abstract Syntax Tree.
 type AST struct {
-	Files []string // List of file names from which this tree was built.
-	Root  *Block   // Root node.
+	Files      []string          // List of file names from which this tree was built.
+	Root       *Block            // Root node.
+	macros     map[string]*Macro // Macros, by name. Filled by ExpandMacros.
+	expansions int               // Number of macro expansions. Used for unique label names.
+	anonymous  int               // Number of anonymous labels. Used for unique label names.
+	last       *Token            // Last token read from the lexer.
+
+	// Maximum number of errors reported by Parse and the assembler.
+	// Defaults to DefaultMaxErrors.
+	MaxErrors int
 }
 
 // Parse takes the given input stream and merges its AST nodes with
@@ -49,18 +57,44 @@ func (a *AST) Parse(r io.Reader, filename string) (err error) {
 
 	io.Copy(&buf, r)
 
-	err = a.readDocument(lex.Run(buf.Bytes()), &a.Root.children)
-	if err != nil {
+	// Syntax errors do not stop the parser. The rest of the code is
+	// still checked, so all errors can be reported at once.
+	var errs ErrorList
+	errs.Add(a.readDocument(lex.Run(buf.Bytes()), &a.Root.children))
+
+	list, err := a.parseMacros(a.Root.children)
+	errs.Add(err)
+	a.Root.children = list
+
+	errs.Add(verify(a, a.Root.children))
+
+	if list, err := a.parseFunctions(a.Root.children); err != nil {
+		errs.Add(err)
+	} else {
+		a.Root.children = list
+	}
+
+	return errs.Err(a.MaxErrors)
+}
+
+// Expand evaluates conditional assembly directives and expands macros.
+// Conditions are evaluated again after the macros have been expanded.
+// This allows macros to contain conditional code. Finally, local and
+// anonymous labels are turned into global ones.
+func (a *AST) Expand() (err error) {
+	if err = a.Prune(); err != nil {
 		return
 	}
 
-	err = verify(a, a.Root.children)
-	if err != nil {
+	if err = a.ExpandMacros(); err != nil {
 		return
 	}
 
-	a.Root.children, err = a.parseFunctions(a.Root.children)
-	return
+	if err = a.Prune(); err != nil {
+		return
+	}
+
+	return a.ResolveLabels()
 }
 
 // Functions returns all function definitions.
@@ -149,21 +183,31 @@ func (a *AST) parseFunctions(in []Node) (out []Node, err error) {
 
 // readDocument reads tokens from the given channel and turns them into AST nodes.
 // It deals with top-level language constructs.
-func (a *AST) readDocument(c <-chan *Token, n *[]Node) (err error) {
+//
+// After an error, the rest of the line is skipped and reading continues
+// on the next line. All errors are returned.
+func (a *AST) readDocument(c <-chan *Token, n *[]Node) error {
+	var errs ErrorList
+	var err error
+
 	file := len(a.Files) - 1
 
 	for {
 		select {
 		case tok := <-c:
 			if tok == nil {
-				return
+				return errs.err()
 			}
 
-			if tok.Type == TokErr {
-				return a.errorf(tok, "%s", tok.Data)
-			}
+			a.last = tok
 
 			switch tok.Type {
+			case TokErr:
+				err = a.errorf(tok, "%s", tok.Data)
+
+			case TokEndLine:
+				// Left over after a syntax error.
+
 			case TokComment:
 				*n = append(*n, NewComment(file, tok.Line, tok.Col, string(tok.Data)))
 
@@ -174,16 +218,23 @@ func (a *AST) readDocument(c <-chan *Token, n *[]Node) (err error) {
 				err = a.readInstruction(c, n, tok)
 
 			default:
-				return a.errorf(tok, "Unexpected token %s. Want Comment, Label or Ident", tok)
+				err = a.errorf(tok, "Unexpected token %s. Want Comment, Label or Ident", tok)
 			}
 
 			if err != nil {
-				return
+				errs.Add(err)
+				a.skipLine(c)
+				err = nil
 			}
 		}
 	}
+}
 
-	return
+// skipLine discards tokens up to and including the end of the current
+// line, unless the last token read was the end of the line.
+func (a *AST) skipLine(c <-chan *Token) {
+	for tok := a.last; tok != nil && tok.Type != TokEndLine; tok = <-c {
+	}
 }
 
 // readInstruction reads tokens from the given channel and turns them into AST nodes.
@@ -197,7 +248,11 @@ func (a *AST) readInstruction(c <-chan *Token, n *[]Node, tok *Token) (err error
 		NewName(file, tok.Line, tok.Col, string(tok.Data)),
 	)
 
-	defer func() { *n = append(*n, instr) }()
+	defer func() {
+		if err == nil {
+			*n = append(*n, instr)
+		}
+	}()
 
 	for {
 		select {
@@ -206,6 +261,8 @@ func (a *AST) readInstruction(c <-chan *Token, n *[]Node, tok *Token) (err error
 				return
 			}
 
+			a.last = tok
+
 			if tok.Type == TokErr {
 				return a.errorf(tok, "%s", tok.Data)
 			}
@@ -311,6 +368,8 @@ func (a *AST) readBlock(c <-chan *Token, n *[]Node, tok *Token) (err error) {
 				return
 			}
 
+			a.last = tok
+
 			if tok.Type == TokErr {
 				return a.errorf(tok, "%s", tok.Data)
 			}
@@ -376,6 +435,8 @@ func (a *AST) readExpr(c <-chan *Token, n *[]Node, tok *Token) (err error) {
 				return
 			}
 
+			a.last = tok
+
 			if tok.Type == TokErr {
 				return a.errorf(tok, "%s", tok.Data)
 			}
@@ -22,3 +22,16 @@ func (n *Comment) Copy(file, line, col int) Node {
 		Data:     n.Data,
 	}
 }
+
+// StripComments returns the given nodes without comments.
+func StripComments(list []Node) []Node {
+	out := make([]Node, 0, len(list))
+
+	for _, v := range list {
+		if _, ok := v.(*Comment); !ok {
+			out = append(out, v)
+		}
+	}
+
+	return out
+}
@@ -0,0 +1,226 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package parser
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/jteeuwen/dcpu/cpu"
+)
+
+// condFrame tracks a single if/else/endif construct.
+type condFrame struct {
+	instr  *Instruction // The opening directive.
+	parent bool         // Is the surrounding code selected?
+	active bool         // Is the current branch selected?
+	taken  bool         // Has any branch been selected?
+	inElse bool         // Have we seen the else directive?
+}
+
+// Define adds a constant to the AST, as if it was defined with
+// `equ name, value` before all other code. The value is a constant
+// expression. It defaults to 1 if it is empty.
+//
+// Defines are visible to conditional assembly directives, as well
+// as to the rest of the program.
+func (a *AST) Define(name, value string) (err error) {
+	var tmp AST
+
+	if len(value) == 0 {
+		value = "1"
+	}
+
+	if IsSpecialName(name) {
+		return errors.New(fmt.Sprintf("Invalid define %q: Reserved name.", name))
+	}
+
+	src := fmt.Sprintf("equ %s, %s\n", name, value)
+
+	if err = tmp.Parse(bytes.NewBufferString(src), "define"); err != nil {
+		return errors.New(fmt.Sprintf("Invalid define %q: %v", name, err))
+	}
+
+	list := StripComments(tmp.Root.children)
+	if len(list) != 1 {
+		return errors.New(fmt.Sprintf("Invalid define %q.", name))
+	}
+
+	if instr, ok := list[0].(*Instruction); !ok || instr.children[0].(*Name).Data != "equ" {
+		return errors.New(fmt.Sprintf("Invalid define %q.", name))
+	}
+
+	if a.Root == nil {
+		a.Root = NewBlock(0, 0, 0)
+	}
+
+	// Defines belong to the first file. They have no line number.
+	a.Root.children = append([]Node{list[0].Copy(0, 0, 0)}, a.Root.children...)
+	return
+}
+
+// Prune evaluates conditional assembly directives and removes the
+// code which is not selected by them:
+//
+//	if <expression>
+//	ifdef <name>
+//	ifndef <name>
+//	else
+//	endif
+//
+// Conditions are evaluated in source order. They can refer to constants
+// which have been defined with `equ` or Define, before the directive.
+// Constants defined in code which is not selected, are ignored.
+//
+// Directives inside macro definitions are left alone. They are
+// evaluated once the macro has been expanded.
+func (a *AST) Prune() (err error) {
+	if a.Root == nil {
+		return
+	}
+
+	consts := make(map[string][]Node)
+	a.Root.children, err = a.pruneList(a.Root.children, consts)
+	return
+}
+
+// pruneList prunes the given nodes. Constants found along the way
+// are added to consts.
+func (a *AST) pruneList(list []Node, consts map[string][]Node) (out []Node, err error) {
+	var stack []*condFrame
+
+	out = make([]Node, 0, len(list))
+	active := true
+
+	for _, v := range list {
+		instr, ok := v.(*Instruction)
+
+		if ok && isCondDirective(instr) {
+			if stack, err = a.pruneDirective(instr, stack, active, consts); err != nil {
+				return
+			}
+
+			active = len(stack) == 0 || stack[len(stack)-1].active
+			continue
+		}
+
+		if !active {
+			continue
+		}
+
+		switch tt := v.(type) {
+		case *Instruction:
+			name := tt.children[0].(*Name)
+
+			if name.Data == "equ" {
+				expr := tt.children[1].(*Expression)
+				key := expr.children[0].(*Name).Data
+				consts[key] = tt.children[2].(*Expression).children
+			}
+
+		case *Function:
+			// Constants defined in a function are local to it.
+			local := make(map[string][]Node, len(consts))
+
+			for k, c := range consts {
+				local[k] = c
+			}
+
+			var body []Node
+			if body, err = a.pruneList(tt.children[1:], local); err != nil {
+				return
+			}
+
+			tt.children = append(tt.children[:1], body...)
+		}
+
+		out = append(out, v)
+	}
+
+	if len(stack) > 0 {
+		f := stack[len(stack)-1]
+		return nil, a.nodeErrorf(f.instr, "Unmatched '%s'.", f.instr.children[0].(*Name).Data)
+	}
+
+	return
+}
+
+// pruneDirective processes a single conditional directive and
+// returns the updated stack.
+func (a *AST) pruneDirective(instr *Instruction, stack []*condFrame, active bool, consts map[string][]Node) ([]*condFrame, error) {
+	name := instr.children[0].(*Name).Data
+
+	switch name {
+	case "else":
+		if len(stack) == 0 {
+			return nil, a.nodeErrorf(instr, "Unmatched 'else'.")
+		}
+
+		f := stack[len(stack)-1]
+		if f.inElse {
+			return nil, a.nodeErrorf(instr, "Duplicate 'else'.")
+		}
+
+		f.inElse = true
+		f.active = f.parent && !f.taken
+		f.taken = true
+		return stack, nil
+
+	case "endif":
+		if len(stack) == 0 {
+			return nil, a.nodeErrorf(instr, "Unmatched 'endif'.")
+		}
+
+		return stack[:len(stack)-1], nil
+	}
+
+	f := &condFrame{instr: instr, parent: active}
+
+	// Conditions in code which is not selected, are not evaluated.
+	if active {
+		expr := instr.children[1].(*Expression)
+
+		switch name {
+		case "ifdef", "ifndef":
+			_, ok := consts[StripComments(expr.children)[0].(*Name).Data]
+			f.active = ok == (name == "ifdef")
+
+		default:
+			v, err := a.evalCond(expr.children, consts)
+			if err != nil {
+				return nil, err
+			}
+
+			f.active = v != 0
+		}
+	}
+
+	f.taken = f.active
+	return append(stack, f), nil
+}
+
+// isCondDirective returns true if the given instruction is a
+// conditional assembly directive.
+func isCondDirective(instr *Instruction) bool {
+	switch instr.children[0].(*Name).Data {
+	case "if", "ifdef", "ifndef", "else", "endif":
+		return true
+	}
+	return false
+}
+
+// evalCond evaluates the given condition. It follows the same rules as
+// expressions in code, except that names can only refer to constants.
+func (a *AST) evalCond(nodes []Node, consts map[string][]Node) (cpu.Word, error) {
+	e := Evaluator{
+		Consts: consts,
+		Errorf: a.nodeErrorf,
+		Value: func(n *Name) (int64, bool, error) {
+			return 0, false, a.nodeErrorf(n, "Undefined constant %q in condition.", n.Data)
+		},
+	}
+
+	v, _, err := e.Eval(nodes)
+	return v, err
+}
@@ -0,0 +1,140 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package parser
+
+import (
+	"bytes"
+	"testing"
+)
+
+// condNames parses and prunes the given source and returns the
+// names of the remaining instructions.
+func condNames(t *testing.T, src string, defines ...string) []string {
+	var ast AST
+
+	for i := 0; i < len(defines); i += 2 {
+		if err := ast.Define(defines[i], defines[i+1]); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := ast.Parse(bytes.NewBufferString(src), ""); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ast.Expand(); err != nil {
+		t.Fatal(err)
+	}
+
+	var names []string
+
+	for _, v := range ast.Root.Children() {
+		// Skip the defines. They have no line number.
+		if instr, ok := v.(*Instruction); ok && instr.Line() > 0 {
+			expr := instr.children[1].(*Expression)
+			names = append(names, expr.children[0].(*Name).Data)
+		}
+	}
+
+	return names
+}
+
+func testCond(t *testing.T, src string, want []string, defines ...string) {
+	have := condNames(t, src, defines...)
+
+	if len(have) != len(want) {
+		t.Fatalf("Result mismatch for %q. Want %v, got %v", src, want, have)
+	}
+
+	for i := range want {
+		if have[i] != want[i] {
+			t.Fatalf("Result mismatch for %q. Want %v, got %v", src, want, have)
+		}
+	}
+}
+
+func TestCond(t *testing.T) {
+	src := `ifdef DEBUG
+   jsr debug
+else
+   jsr release
+endif
+if LEVEL > 1 && LEVEL < 4
+   jsr high
+   ifndef DEBUG
+      jsr quiet
+   endif
+endif`
+
+	testCond(t, src, []string{"debug", "high"}, "DEBUG", "1", "LEVEL", "2")
+	testCond(t, src, []string{"release", "high", "quiet"}, "LEVEL", "1 + 2")
+	testCond(t, src, []string{"release"}, "LEVEL", "(1 << 3)")
+}
+
+func TestCondEqu(t *testing.T) {
+	// Constants in code which is not selected, do not count.
+	testCond(t, `ifndef SIZE
+   equ SIZE, 2
+endif
+if 0
+   equ COLOR, 1
+endif
+if SIZE == 2
+   jsr two
+endif
+ifdef COLOR
+   jsr color
+endif`, []string{"SIZE", "two"})
+}
+
+func TestCondMacro(t *testing.T) {
+	testCond(t, `macro pick n
+   if n > 1
+      jsr many
+   else
+      jsr one
+   endif
+endm
+   pick 1
+   pick 3`, []string{"one", "many"})
+}
+
+func TestCondErrors(t *testing.T) {
+	for _, src := range []string{
+		"if 1",
+		"endif",
+		"if 1\nelse\nelse\nendif",
+		"if FOO\nendif",
+		"if 1 / 0\nendif",
+		"if 0xffff + 1\nendif",
+		"if 1 << 32\nendif",
+		"if 0x8000 * 0x8000 * 2\nendif",
+	} {
+		var ast AST
+
+		if err := ast.Parse(bytes.NewBufferString(src), ""); err != nil {
+			continue
+		}
+
+		if err := ast.Expand(); err == nil {
+			t.Fatalf("Want error for %q", src)
+		}
+	}
+
+	// Errors in code which is not selected, are ignored.
+	testCond(t, "if 0\n   if FOO\n   endif\nendif", nil)
+}
+
+func TestCondWord(t *testing.T) {
+	// Conditions follow the same rules as expressions in code.
+	testCond(t, `if -1
+   jsr minus
+endif
+if (1 << 16) >> 16 == 1
+   jsr shift
+endif
+if SIZE / 2 == 0x1000
+   jsr size
+endif`, []string{"minus", "shift", "size"}, "SIZE", "0x2000")
+}
@@ -3,7 +3,10 @@
 
 package parser
 
-import "fmt"
+import (
+	"fmt"
+	"strings"
+)
 
 // Represents a parse error.
 type ParseError struct {
@@ -22,3 +25,84 @@ func NewParseError(file string, line, col int, f string, argv ...interface{}) *P
 func (e *ParseError) Error() string {
 	return fmt.Sprintf("%s:%d:%d %s", e.File, e.Line, e.Col, e.Msg)
 }
+
+// DefaultMaxErrors is the number of errors which are reported,
+// if AST.MaxErrors is not set.
+const DefaultMaxErrors = 20
+
+// ErrorList holds multiple errors, in the order in which they were found.
+type ErrorList []error
+
+// Error returns all errors, one per line.
+func (e ErrorList) Error() string {
+	list := make([]string, len(e))
+
+	for i := range e {
+		list[i] = e[i].Error()
+	}
+
+	return strings.Join(list, "\n")
+}
+
+// Add appends the given error, unless it is nil.
+// Nested error lists are flattened.
+func (e *ErrorList) Add(err error) {
+	switch tt := err.(type) {
+	case nil:
+	case ErrorList:
+		*e = append(*e, tt...)
+	default:
+		*e = append(*e, err)
+	}
+}
+
+// Err returns the list as a single error. This is nil if the list is
+// empty, or the error itself if there is only one.
+//
+// At most max errors are returned. A max of zero or less means
+// DefaultMaxErrors. The number of errors left out is noted at the end.
+// A list which was limited before, can be combined with other errors
+// and limited again. The note then counts the errors left out by both.
+func (e ErrorList) Err(max int) error {
+	if max <= 0 {
+		max = DefaultMaxErrors
+	}
+
+	var list ErrorList
+	var hidden int
+
+	for _, err := range e {
+		if n, ok := err.(tooManyErrors); ok {
+			hidden += int(n)
+		} else if len(list) < max {
+			list = append(list, err)
+		} else {
+			hidden++
+		}
+	}
+
+	if hidden > 0 {
+		list = append(list, tooManyErrors(hidden))
+	}
+
+	return list.err()
+}
+
+// tooManyErrors notes the number of errors left out of a list.
+type tooManyErrors int
+
+func (e tooManyErrors) Error() string {
+	return fmt.Sprintf("Too many errors. %d more not shown.", int(e))
+}
+
+// err returns the list as a single error, without limiting its size.
+// Used by intermediate steps, whose errors are combined later.
+func (e ErrorList) err() error {
+	switch len(e) {
+	case 0:
+		return nil
+	case 1:
+		return e[0]
+	}
+	return e
+}
@@ -0,0 +1,139 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package parser
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+// errorLines returns the line numbers of the errors in err.
+func errorLines(t *testing.T, err error) []int {
+	list, ok := err.(ErrorList)
+	if !ok {
+		list = ErrorList{err}
+	}
+
+	lines := make([]int, len(list))
+
+	for i, e := range list {
+		pe, ok := e.(*ParseError)
+		if !ok {
+			t.Fatalf("Expected ParseError, got %T: %v", e, e)
+		}
+		lines[i] = pe.Line
+	}
+
+	return lines
+}
+
+func TestErrorRecovery(t *testing.T) {
+	var ast AST
+
+	src := `set a, 1
+set b, , 2
+set c, 3
+set x, (a
+set y, 4 ; comment
+#set z, 6
+set z, 5
+equ foo
+`
+
+	err := ast.Parse(bytes.NewBufferString(src), "")
+	if err == nil {
+		t.Fatal("Expected errors.")
+	}
+
+	want := []int{2, 4, 6, 8}
+	have := errorLines(t, err)
+
+	if len(have) != len(want) {
+		t.Fatalf("Error count mismatch: Want %d, have %d:\n%v", len(want), len(have), err)
+	}
+
+	for i := range want {
+		if have[i] != want[i] {
+			t.Fatalf("Error %d: Want line %d, have %d:\n%v", i, want[i], have[i], err)
+		}
+	}
+
+	// Lines without syntax errors are still parsed.
+	var names []string
+
+	for _, v := range ast.Root.Children() {
+		if instr, ok := v.(*Instruction); ok {
+			expr := instr.children[1].(*Expression)
+			names = append(names, expr.children[0].(*Name).Data)
+		}
+	}
+
+	// The invalid constant is syntactically correct.
+	if strings.Join(names, " ") != "a c y z foo" {
+		t.Fatalf("Unexpected instructions: %v", names)
+	}
+}
+
+func TestMaxErrors(t *testing.T) {
+	var ast AST
+	var src bytes.Buffer
+
+	for i := 0; i < 10; i++ {
+		src.WriteString("set a, , 1\n")
+	}
+
+	ast.MaxErrors = 3
+
+	err := ast.Parse(&src, "")
+
+	list, ok := err.(ErrorList)
+	if !ok {
+		t.Fatalf("Expected ErrorList, got %T: %v", err, err)
+	}
+
+	// The limit, plus a note about the remaining errors.
+	if len(list) != 4 {
+		t.Fatalf("Want 4 errors, have %d:\n%v", len(list), err)
+	}
+
+	if _, ok := list[3].(*ParseError); ok {
+		t.Fatalf("Expected a note about the remaining errors, got %v", list[3])
+	}
+}
+
+// Limited lists can be combined and limited again.
+func TestMaxErrorsCombined(t *testing.T) {
+	var a, b, all ErrorList
+
+	for i := 0; i < 5; i++ {
+		a.Add(NewParseError("a", i, 1, "error"))
+		b.Add(NewParseError("b", i, 1, "error"))
+	}
+
+	all.Add(a.Err(3))
+	all.Add(b.Err(3))
+
+	list, ok := all.Err(4).(ErrorList)
+	if !ok || len(list) != 5 {
+		t.Fatalf("Want 4 errors and a note, have %v", list)
+	}
+
+	if list[3].(*ParseError).File != "b" {
+		t.Fatalf("Want the first error of b in fourth place, have %v", list[3])
+	}
+
+	if msg := list[4].Error(); msg != "Too many errors. 6 more not shown." {
+		t.Fatalf("Note mismatch: %s", msg)
+	}
+}
+
+func TestSingleError(t *testing.T) {
+	var ast AST
+
+	err := ast.Parse(bytes.NewBufferString("set a, , 1\n"), "")
+	if _, ok := err.(*ParseError); !ok {
+		t.Fatalf("Expected ParseError, got %T: %v", err, err)
+	}
+}
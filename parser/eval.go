@@ -0,0 +1,310 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package parser
+
+import "github.com/jteeuwen/dcpu/cpu"
+
+// Intermediate expression values are kept within this range.
+// This leaves enough room to multiply two of them without
+// overflowing the int64 we evaluate in.
+const exprLimit = 1 << 31
+
+// Binary operators, grouped by precedence. Lowest precedence comes first.
+var precedence = [][]string{
+	{"||"},
+	{"&&"},
+	{"|"},
+	{"^"},
+	{"&"},
+	{"=="},
+	{"<", "<=", ">", ">="},
+	{"<<", ">>"},
+	{"+", "-"},
+	{"*", "/", "%"},
+}
+
+// Evaluator evaluates constant expressions over numbers, characters
+// and names. Operators follow the usual C precedence rules and
+// sub-expressions can be grouped using parentheses.
+//
+// The assembler and conditional assembly directives both use it,
+// so expressions mean the same thing everywhere.
+type Evaluator struct {
+	// Consts holds constant definitions. A name found here is replaced
+	// by its value, as if that was written in parentheses.
+	Consts map[string][]Node
+
+	// Value returns the value of a name which is not a constant.
+	// If ok is false, the value is not known yet.
+	Value func(n *Name) (v int64, ok bool, err error)
+
+	// Errorf creates an error for the given node.
+	Errorf func(n Node, f string, argv ...interface{}) error
+
+	nodes      []Node
+	pos        int
+	depth      int   // Nesting depth of constant references.
+	unresolved *Name // First name with an unknown value.
+}
+
+// Eval evaluates the given expression. The value must fit in 16 bits.
+// Negative values are returned in two's complement form.
+//
+// If the value of any name is not known, the first such name is
+// returned as unresolved. The value is meaningless in that case and
+// errors which depend on it are not reported.
+func (e *Evaluator) Eval(nodes []Node) (val cpu.Word, unresolved *Name, err error) {
+	v, unresolved, err := e.EvalInt(nodes)
+	if err != nil || unresolved != nil {
+		return 0, unresolved, err
+	}
+
+	if v < -0x8000 || v > 0xffff {
+		return 0, nil, e.Errorf(StripComments(nodes)[0],
+			"Expression value %d does not fit in 16 bits.", v)
+	}
+
+	return cpu.Word(v), nil, nil
+}
+
+// EvalInt is like Eval, but returns the value before it is
+// reduced to 16 bits.
+func (e *Evaluator) EvalInt(nodes []Node) (v int64, unresolved *Name, err error) {
+	sub := e.sub(nodes)
+
+	if len(sub.nodes) == 0 {
+		return 0, nil, e.Errorf(nodes[0], "Expected expression.")
+	}
+
+	if v, err = sub.parseAll(); err != nil {
+		return
+	}
+
+	return v, sub.unresolved, nil
+}
+
+// sub returns an evaluator for the given sub-expression.
+func (e *Evaluator) sub(nodes []Node) *Evaluator {
+	return &Evaluator{
+		Consts:     e.Consts,
+		Value:      e.Value,
+		Errorf:     e.Errorf,
+		nodes:      StripComments(nodes),
+		depth:      e.depth,
+		unresolved: e.unresolved,
+	}
+}
+
+// parseAll evaluates all nodes as a single expression.
+func (e *Evaluator) parseAll() (v int64, err error) {
+	if v, err = e.parseBinary(0); err != nil {
+		return
+	}
+
+	if e.pos < len(e.nodes) {
+		return 0, e.Errorf(e.nodes[e.pos],
+			"Unexpected node %T in expression.", e.nodes[e.pos])
+	}
+
+	return
+}
+
+// parseBinary evaluates a sequence of binary operations at the
+// given precedence level and above.
+func (e *Evaluator) parseBinary(level int) (v int64, err error) {
+	if level >= len(precedence) {
+		return e.parseUnary()
+	}
+
+	if v, err = e.parseBinary(level + 1); err != nil {
+		return
+	}
+
+	for e.pos < len(e.nodes) {
+		op, ok := e.nodes[e.pos].(*Operator)
+		if !ok || !hasOperator(precedence[level], op.Data) {
+			return
+		}
+
+		e.pos++
+
+		var rhs int64
+		if rhs, err = e.parseBinary(level + 1); err != nil {
+			return
+		}
+
+		if v, err = e.apply(op, v, rhs); err != nil {
+			return
+		}
+	}
+
+	return
+}
+
+// parseUnary evaluates unary operators and operands.
+func (e *Evaluator) parseUnary() (v int64, err error) {
+	if e.pos >= len(e.nodes) {
+		return 0, e.Errorf(e.nodes[len(e.nodes)-1],
+			"Unexpected end of expression.")
+	}
+
+	node := e.nodes[e.pos]
+	e.pos++
+
+	switch tt := node.(type) {
+	case *Operator:
+		switch tt.Data {
+		case "-":
+			v, err = e.parseUnary()
+			return -v, err
+
+		case "+":
+			return e.parseUnary()
+		}
+
+		return 0, e.Errorf(tt, "Unexpected operator %q.", tt.Data)
+
+	case *Expression:
+		return e.parseNested(tt, tt.children)
+
+	case NumericNode:
+		var w cpu.Word
+		if w, err = tt.Parse(); err != nil {
+			return 0, e.Errorf(tt, "%v", err)
+		}
+		return int64(w), nil
+
+	case *Name:
+		if value, ok := e.Consts[tt.Data]; ok {
+			if e.depth >= MaxMacroDepth {
+				return 0, e.Errorf(tt, "Constant %q refers to itself.", tt.Data)
+			}
+
+			e.depth++
+			v, err = e.parseNested(tt, value)
+			e.depth--
+			return
+		}
+
+		v, ok, err := e.Value(tt)
+		if err != nil {
+			return 0, err
+		}
+
+		if !ok {
+			if e.unresolved == nil {
+				e.unresolved = tt
+			}
+			return 0, nil
+		}
+
+		return v, nil
+	}
+
+	return 0, e.Errorf(node,
+		"Unexpected node %T. Want Name, Number, Char, Operator or Expression.", node)
+}
+
+// parseNested evaluates the given nodes as a parenthesized
+// sub-expression. Errors about an empty expression refer to n.
+func (e *Evaluator) parseNested(n Node, nodes []Node) (v int64, err error) {
+	sub := e.sub(nodes)
+
+	if len(sub.nodes) == 0 {
+		return 0, e.Errorf(n, "Expected expression.")
+	}
+
+	if v, err = sub.parseAll(); err != nil {
+		return
+	}
+
+	e.unresolved = sub.unresolved
+	return
+}
+
+// apply applies the given binary operator.
+//
+// While any part of the expression is unresolved, operand values are
+// meaningless. Errors like division by zero are ignored in that case,
+// as they will be caught once the expression is re-evaluated.
+func (e *Evaluator) apply(op *Operator, a, b int64) (v int64, err error) {
+	switch op.Data {
+	case "+":
+		v = a + b
+	case "-":
+		v = a - b
+	case "*":
+		v = a * b
+	case "/", "%":
+		if b == 0 {
+			if e.unresolved != nil {
+				return 0, nil
+			}
+			return 0, e.Errorf(op, "Division by zero.")
+		}
+
+		if op.Data == "/" {
+			v = a / b
+		} else {
+			v = a % b
+		}
+	case "&":
+		v = a & b
+	case "|":
+		v = a | b
+	case "^":
+		v = a ^ b
+	case "<<", ">>":
+		if b < 0 || b > 31 {
+			if e.unresolved != nil {
+				return 0, nil
+			}
+			return 0, e.Errorf(op, "Invalid shift count %d.", b)
+		}
+
+		if op.Data == "<<" {
+			v = a << uint(b)
+		} else {
+			v = a >> uint(b)
+		}
+	case "==":
+		v = bool2int(a == b)
+	case "<":
+		v = bool2int(a < b)
+	case "<=":
+		v = bool2int(a <= b)
+	case ">":
+		v = bool2int(a > b)
+	case ">=":
+		v = bool2int(a >= b)
+	case "&&":
+		v = bool2int(a != 0 && b != 0)
+	case "||":
+		v = bool2int(a != 0 || b != 0)
+	default:
+		return 0, e.Errorf(op, "Unknown operator %q.", op.Data)
+	}
+
+	if (v <= -exprLimit || v >= exprLimit) && e.unresolved == nil {
+		return 0, e.Errorf(op, "Arithmetic overflow in expression.")
+	}
+
+	return
+}
+
+func hasOperator(list []string, op string) bool {
+	for i := range list {
+		if list[i] == op {
+			return true
+		}
+	}
+	return false
+}
+
+func bool2int(b bool) int64 {
+	if b {
+		return 1
+	}
+	return 0
+}
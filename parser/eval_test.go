@@ -0,0 +1,104 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package parser
+
+import (
+	"bytes"
+	"github.com/jteeuwen/dcpu/cpu"
+	"testing"
+)
+
+// evalExpr parses the given expression and evaluates it. Names other
+// than constants evaluate to their length, except for `unknown`.
+func evalExpr(t *testing.T, src string, consts map[string][]Node) (cpu.Word, *Name, error) {
+	var ast AST
+
+	if err := ast.Parse(bytes.NewBufferString("dat "+src), ""); err != nil {
+		t.Fatal(err)
+	}
+
+	expr := ast.Root.children[0].(*Instruction).children[1].(*Expression)
+
+	e := Evaluator{
+		Consts: consts,
+		Errorf: ast.nodeErrorf,
+		Value: func(n *Name) (int64, bool, error) {
+			return int64(len(n.Data)), n.Data != "unknown", nil
+		},
+	}
+
+	return e.Eval(expr.children)
+}
+
+func TestEval(t *testing.T) {
+	consts := map[string][]Node{
+		"ONE": {NewNumber(0, 1, 1, "1")},
+		"TWO": {NewName(0, 2, 1, "ONE"), NewOperator(0, 2, 5, "+"), NewName(0, 2, 7, "ONE")},
+	}
+
+	for _, v := range []struct {
+		src  string
+		want cpu.Word
+	}{
+		{"2 + 3 * 4", 14},
+		{"(2 + 3) * 4", 20},
+		{"1 << 8 | 0xf", 0x10f},
+		{"-1", 0xffff},
+		{"-0x8000", 0x8000},
+		{"(0xffff + 1) >> 1", 0x8000},
+		{"3 > 2 && 2 >= 2 || 0", 1},
+		{"'A' + 1", 'B'},
+		{"abc * 2", 6},
+		{"TWO * TWO", 4},
+	} {
+		have, unresolved, err := evalExpr(t, v.src, consts)
+		if err != nil {
+			t.Fatalf("%q: %v", v.src, err)
+		}
+
+		if unresolved != nil {
+			t.Fatalf("%q: Unexpected unresolved name %q", v.src, unresolved.Data)
+		}
+
+		if have != v.want {
+			t.Fatalf("%q: Want %#04x, have %#04x", v.src, v.want, have)
+		}
+	}
+}
+
+func TestEvalUnresolved(t *testing.T) {
+	// Errors which depend on unresolved names are not reported.
+	for _, src := range []string{"unknown + 1", "1 / unknown", "0xffff * unknown"} {
+		_, unresolved, err := evalExpr(t, src, nil)
+		if err != nil {
+			t.Fatalf("%q: %v", src, err)
+		}
+
+		if unresolved == nil || unresolved.Data != "unknown" {
+			t.Fatalf("%q: Want unresolved name", src)
+		}
+	}
+}
+
+func TestEvalErrors(t *testing.T) {
+	consts := map[string][]Node{
+		"SELF": {NewName(0, 1, 1, "SELF")},
+	}
+
+	for _, src := range []string{
+		"0xffff + 1",
+		"-0x8001",
+		"1 / 0",
+		"1 % 0",
+		"1 << 32",
+		"0x8000 * 0x8000 * 2",
+		"1 +",
+		"()",
+		"SELF",
+	} {
+		if _, _, err := evalExpr(t, src, consts); err == nil {
+			t.Fatalf("Want error for %q", src)
+		}
+	}
+}
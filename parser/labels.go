@@ -0,0 +1,239 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package parser
+
+import (
+	"fmt"
+	"strings"
+)
+
+// anonLabel is an anonymous label, along with its position in the program.
+type anonLabel struct {
+	label *Label
+	dir   string // "+" or "-".
+	pos   int
+}
+
+// anonRef is a reference to an anonymous label.
+type anonRef struct {
+	node  NodeCollection // Expression or block holding the reference.
+	dir   string         // "+" or "-".
+	count int            // Number of labels to skip, plus one.
+	pos   int
+}
+
+// ResolveLabels turns local and anonymous labels into global ones.
+//
+// A local label starts with a dot. Its scope is the enclosing function,
+// or the global label which precedes it in the same file. It is renamed
+// to `<scope>.<name>`. The same happens to references to it, so `.loop`
+// in function `strlen` becomes `strlen.loop`.
+//
+// Anonymous labels are defined as `:+` or `:-`. An operand consisting of
+// only plus signs refers to an anonymous `:+` label further down; `+` is
+// the nearest one, `++` the one after that. Minus signs refer to `:-`
+// labels further up in the same way. Anonymous labels are given unique
+// names.
+func (a *AST) ResolveLabels() (err error) {
+	if a.Root == nil {
+		return
+	}
+
+	if err = a.resolveLocal(a.Root.children, "", -1, false); err != nil {
+		return
+	}
+
+	return a.resolveAnonymous()
+}
+
+// resolveLocal qualifies local labels and references to them.
+// scope is the name of the current scope and file is the file it
+// is defined in. If fixed is true, global labels do not change
+// the scope. This is the case inside functions.
+func (a *AST) resolveLocal(list []Node, scope string, file int, fixed bool) (err error) {
+	for _, v := range list {
+		if v.File() != file && file != -1 && !fixed {
+			scope, file = "", -1
+		}
+
+		switch tt := v.(type) {
+		case *Label:
+			if isLocalName(tt.Data) {
+				if tt.Data, err = a.qualify(tt, tt.Data, scope); err != nil {
+					return
+				}
+				break
+			}
+
+			// Labels generated by macros do not start a new scope.
+			if !fixed && !isAnonName(tt.Data) && !strings.HasPrefix(tt.Data, "__") {
+				scope, file = tt.Data, tt.File()
+			}
+
+		case *Function:
+			name := tt.children[0].(*Name)
+
+			if err = a.resolveLocal(tt.children[1:], name.Data, tt.File(), true); err != nil {
+				return
+			}
+
+			scope, file = "", -1
+
+		case *Instruction:
+			for _, expr := range tt.children[1:] {
+				if err = a.resolveLocalRefs(expr.(*Expression), scope); err != nil {
+					return
+				}
+			}
+		}
+	}
+
+	return
+}
+
+// resolveLocalRefs qualifies references to local labels.
+func (a *AST) resolveLocalRefs(n NodeCollection, scope string) (err error) {
+	for _, v := range n.Children() {
+		switch tt := v.(type) {
+		case *Name:
+			if isLocalName(tt.Data) {
+				tt.Data, err = a.qualify(tt, tt.Data, scope)
+			}
+
+		case NodeCollection:
+			err = a.resolveLocalRefs(tt, scope)
+		}
+
+		if err != nil {
+			return
+		}
+	}
+
+	return
+}
+
+// qualify prefixes the given local name with its scope.
+func (a *AST) qualify(n Node, name, scope string) (string, error) {
+	if len(scope) == 0 {
+		return "", a.nodeErrorf(n, "Local label %q has no enclosing scope.", name)
+	}
+
+	return scope + name, nil
+}
+
+// resolveAnonymous gives anonymous labels unique names and
+// updates references to them.
+func (a *AST) resolveAnonymous() (err error) {
+	var labels []anonLabel
+	var refs []anonRef
+	var pos int
+
+	a.findAnonymous(a.Root.children, &pos, &labels, &refs)
+
+	for _, l := range labels {
+		a.anonymous++
+		l.label.Data = fmt.Sprintf("__anon_%d", a.anonymous)
+	}
+
+	for _, r := range refs {
+		var target *Label
+		count := r.count
+
+		if r.dir == "-" {
+			for i := len(labels) - 1; i >= 0 && target == nil; i-- {
+				l := labels[i]
+
+				if l.pos < r.pos && l.dir == "-" && l.label.File() == r.node.File() {
+					if count--; count == 0 {
+						target = l.label
+					}
+				}
+			}
+		} else {
+			for i := 0; i < len(labels) && target == nil; i++ {
+				l := labels[i]
+
+				if l.pos > r.pos && l.dir == "+" && l.label.File() == r.node.File() {
+					if count--; count == 0 {
+						target = l.label
+					}
+				}
+			}
+		}
+
+		if target == nil {
+			return a.nodeErrorf(r.node, "No anonymous label found for %q.",
+				strings.Repeat(r.dir, r.count))
+		}
+
+		list := r.node.Children()
+		r.node.SetChildren([]Node{
+			NewName(list[0].File(), list[0].Line(), list[0].Col(), target.Data),
+		})
+	}
+
+	return
+}
+
+// findAnonymous finds anonymous labels and references to them,
+// in program order.
+func (a *AST) findAnonymous(list []Node, pos *int, labels *[]anonLabel, refs *[]anonRef) {
+	for _, v := range list {
+		*pos++
+
+		switch tt := v.(type) {
+		case *Label:
+			if isAnonName(tt.Data) {
+				*labels = append(*labels, anonLabel{tt, tt.Data, *pos})
+			}
+
+		case *Function:
+			a.findAnonymous(tt.children[1:], pos, labels, refs)
+
+		case *Instruction:
+			for _, expr := range tt.children[1:] {
+				findAnonymousRefs(expr.(*Expression), *pos, refs)
+			}
+		}
+	}
+}
+
+// findAnonymousRefs finds expressions and blocks which consist
+// of only plus or minus signs.
+func findAnonymousRefs(n NodeCollection, pos int, refs *[]anonRef) {
+	list := StripComments(n.Children())
+
+	if dir, count := anonRefOf(list); count > 0 {
+		*refs = append(*refs, anonRef{n, dir, count, pos})
+		return
+	}
+
+	for _, v := range list {
+		if c, ok := v.(NodeCollection); ok {
+			findAnonymousRefs(c, pos, refs)
+		}
+	}
+}
+
+// anonRefOf returns the direction and count of an anonymous label
+// reference, if the given nodes are one. The count is zero otherwise.
+func anonRefOf(list []Node) (dir string, count int) {
+	for _, v := range list {
+		op, ok := v.(*Operator)
+		if !ok || (op.Data != "+" && op.Data != "-") || (count > 0 && op.Data != dir) {
+			return "", 0
+		}
+
+		dir = op.Data
+		count++
+	}
+
+	return
+}
+
+// isLocalName returns true if the given name denotes a local label.
+func isLocalName(s string) bool { return len(s) > 1 && s[0] == '.' }
+
+// isAnonName returns true if the given name denotes an anonymous label.
+func isAnonName(s string) bool { return s == "+" || s == "-" }
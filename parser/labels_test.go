@@ -0,0 +1,109 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package parser
+
+import (
+	"bytes"
+	"testing"
+)
+
+// resolveNames parses and expands the given source. It returns the
+// names of all labels and of the first operand of every instruction.
+func resolveNames(t *testing.T, src string) (labels, refs []string) {
+	var ast AST
+
+	if err := ast.Parse(bytes.NewBufferString(src), ""); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ast.Expand(); err != nil {
+		t.Fatal(err)
+	}
+
+	var walk func([]Node)
+	walk = func(list []Node) {
+		for _, v := range list {
+			switch tt := v.(type) {
+			case *Label:
+				labels = append(labels, tt.Data)
+			case *Function:
+				labels = append(labels, tt.children[0].(*Name).Data)
+				walk(tt.children[1:])
+			case *Instruction:
+				expr := tt.children[len(tt.children)-1].(*Expression)
+				if name, ok := expr.children[0].(*Name); ok {
+					refs = append(refs, name.Data)
+				}
+			}
+		}
+	}
+
+	walk(ast.Root.children)
+	return
+}
+
+func testNames(t *testing.T, have, want []string) {
+	if len(have) != len(want) {
+		t.Fatalf("Name mismatch. Want %v, got %v", want, have)
+	}
+
+	for i := range want {
+		if have[i] != want[i] {
+			t.Fatalf("Name mismatch. Want %v, got %v", want, have)
+		}
+	}
+}
+
+func TestLocalLabels(t *testing.T) {
+	labels, refs := resolveNames(t, `:main
+:.loop
+   set pc, .loop
+def func
+:.loop
+:inner
+   set pc, .loop
+end
+:other
+:.loop
+   set pc, .loop
+   set pc, main.loop`)
+
+	testNames(t, labels, []string{"main", "main.loop", "func", "func.loop", "inner", "other", "other.loop"})
+	testNames(t, refs, []string{"main.loop", "func.loop", "other.loop", "main.loop"})
+}
+
+func TestAnonymousLabels(t *testing.T) {
+	labels, refs := resolveNames(t, `:-
+   set pc, +
+   set pc, ++
+:+
+   set pc, -
+:+
+:-
+   set pc, [-]
+   set pc, --`)
+
+	testNames(t, labels, []string{"__anon_1", "__anon_2", "__anon_3", "__anon_4"})
+	testNames(t, refs, []string{"__anon_2", "__anon_3", "__anon_1", "__anon_1"})
+}
+
+func TestLabelErrors(t *testing.T) {
+	for _, src := range []string{
+		":.loop",
+		"set pc, .loop",
+		"set pc, +",
+		":+\nset pc, -",
+		":-\nset pc, --",
+	} {
+		var ast AST
+
+		if err := ast.Parse(bytes.NewBufferString(src), ""); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := ast.Expand(); err == nil {
+			t.Fatalf("Want error for %q", src)
+		}
+	}
+}
@@ -14,7 +14,9 @@ var (
 		"ias", "rfi", "iaq", "hwn", "hwq", "hwi",
 
 		// Non-standard and pseudo instructions.
-		"dat", "panic", "exit", "equ", "return",
+		"dat", "panic", "exit", "equ", "return", "org", "reserve", "fill",
+		"align", "macro", "endm", "if", "ifdef", "ifndef", "else", "endif",
+		"include", "incbin",
 	}
 
 	registers = [...]string{
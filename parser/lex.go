@@ -25,6 +25,7 @@ type Lexer struct {
 	pos    int         // Current position in buffer.
 	size   int         // Size of last read rune.
 	prlnsz int         // Size of previous line. Needed for accurate line/col tracking when rewinding.
+	failed bool        // Set when an error has been emitted.
 }
 
 // Run processes the given data and yields tokens on the returned channel.
@@ -50,6 +51,10 @@ func (l *Lexer) Run(data []byte) <-chan *Token {
 		// Loop for as long as we have a valid state.
 		for l.state != nil {
 			l.state = l.state(l)
+
+			if l.state == nil && l.failed {
+				l.state = l.recover()
+			}
 		}
 	}()
 
@@ -66,6 +71,21 @@ func (l *Lexer) errorf(f string, argv ...interface{}) {
 
 	l.ch <- &tok
 	l.ignore()
+	l.failed = true
+}
+
+// recover skips the rest of the line after an error, so lexing
+// can continue on the next line. The newline yields a TokEndLine,
+// which allows the parser to recover as well.
+func (l *Lexer) recover() LexFunc {
+	l.failed = false
+
+	if l.acceptUntil("\n") < 0 {
+		return nil
+	}
+
+	l.ignore()
+	return lexInstruction
 }
 
 func (l *Lexer) emit(tt TokenType) {
@@ -270,7 +290,7 @@ func isDigit(r rune) bool {
 }
 
 func isIdent(r rune) bool {
-	return r == '_' || (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z')
+	return r == '_' || r == '.' || (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z')
 }
 
 func isOperator(r rune) bool {
@@ -58,6 +58,14 @@ func lexText(l *Lexer) LexFunc {
 
 // lexInstruction processes an instruction definition.
 func lexLabel(l *Lexer) LexFunc {
+	// Anonymous labels.
+	if r := l.accept("+-"); r == Eof {
+		return nil
+	} else if r > 0 {
+		l.emit(TokLabel)
+		return lexText
+	}
+
 	r := l.acceptIdent()
 
 	switch {
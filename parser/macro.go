@@ -0,0 +1,425 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package parser
+
+import "fmt"
+
+// Maximum nesting depth of macro invocations. Deeper nesting
+// is most likely the result of a recursive macro.
+const MaxMacroDepth = 32
+
+// A Macro is a named, parameterized piece of code. It is defined as:
+//
+//	macro name [param [, param ...]]
+//	   ...
+//	endm
+//
+// Every invocation of the macro is replaced by a copy of its body,
+// with the parameters replaced by the invocation's arguments.
+type Macro struct {
+	*NodeBase
+	Name     *Name   // Name of the macro.
+	Params   []*Name // Parameter names.
+	children []Node  // Macro body.
+}
+
+func NewMacro(file, line, col int) *Macro {
+	return &Macro{
+		NodeBase: NewNodeBase(file, line, col),
+	}
+}
+
+func (m *Macro) Children() []Node     { return m.children }
+func (m *Macro) SetChildren(n []Node) { m.children = n }
+
+func (m *Macro) Copy(file, line, col int) Node {
+	nm := &Macro{
+		NodeBase: NewNodeBase(file, line, col),
+		Name:     m.Name.Copy(file, line, col).(*Name),
+		Params:   make([]*Name, len(m.Params)),
+		children: make([]Node, len(m.children)),
+	}
+
+	for i := range nm.Params {
+		nm.Params[i] = m.Params[i].Copy(file, line, col).(*Name)
+	}
+
+	for i := range nm.children {
+		nm.children[i] = m.children[i].Copy(file, line, col)
+	}
+
+	return nm
+}
+
+// parseMacros finds macro definitions and turns them into Macro nodes.
+//
+// A definition with errors is left out and the rest are still
+// processed, so uses of the other macros can be expanded. The errors
+// are collected.
+func (a *AST) parseMacros(in []Node) (out []Node, err error) {
+	var errs ErrorList
+	out = make([]Node, 0, len(in))
+
+	for s := 0; s < len(in); s++ {
+		instr, ok := in[s].(*Instruction)
+		if !ok {
+			out = append(out, in[s])
+			continue
+		}
+
+		switch instr.children[0].(*Name).Data {
+		case "endm":
+			errs.Add(a.nodeErrorf(instr, "Unmatched 'endm'."))
+			continue
+		case "macro":
+		default:
+			out = append(out, in[s])
+			continue
+		}
+
+		e, err := a.indexOfEndm(in[s+1:])
+		if err != nil {
+			// Skip the outer definition. The nested one is
+			// processed next.
+			errs.Add(err)
+			continue
+		}
+
+		if e == -1 {
+			// The rest of the code is the macro body.
+			errs.Add(a.nodeErrorf(instr, "Unmatched 'macro'."))
+			break
+		}
+
+		e += s + 1
+
+		m, err := a.newMacro(instr)
+		if err == nil {
+			m.children = append(m.children, in[s+1:e]...)
+			err = verify(a, m.children)
+		}
+
+		if err != nil {
+			errs.Add(err)
+		} else {
+			out = append(out, m)
+		}
+
+		s = e
+	}
+
+	return out, errs.err()
+}
+
+// indexOfEndm finds the 'endm' which closes a macro definition.
+func (a *AST) indexOfEndm(in []Node) (int, error) {
+	for i := range in {
+		instr, ok := in[i].(*Instruction)
+		if !ok {
+			continue
+		}
+
+		switch instr.children[0].(*Name).Data {
+		case "macro":
+			return -1, a.nodeErrorf(instr, "Macro definitions can not be nested.")
+		case "endm":
+			return i, nil
+		}
+	}
+
+	return -1, nil
+}
+
+// newMacro creates a macro from its 'macro' instruction.
+//
+// The macro name and the first parameter are separated by whitespace,
+// so they end up in the same expression.
+func (a *AST) newMacro(instr *Instruction) (m *Macro, err error) {
+	var names []*Name
+
+	for i, n := range instr.children[1:] {
+		expr := n.(*Expression)
+		list := StripComments(expr.children)
+
+		if len(list) == 0 || len(list) > 2 || (i > 0 && len(list) > 1) {
+			goto fail
+		}
+
+		for _, v := range list {
+			name, ok := v.(*Name)
+			if !ok || IsSpecialName(name.Data) {
+				goto fail
+			}
+
+			names = append(names, name)
+		}
+	}
+
+	if len(names) == 0 {
+		goto fail
+	}
+
+	m = NewMacro(instr.File(), instr.Line(), instr.Col())
+	m.Name = names[0]
+	m.Params = names[1:]
+	return
+
+fail:
+	return nil, a.nodeErrorf(instr,
+		"Invalid macro definition. Expected: macro <name> [<param> [, <param>]]")
+}
+
+// ExpandMacros replaces macro invocations with the body of the macro.
+// Macro definitions are removed from the tree.
+//
+// Expanded code is given the file, line and column of the invocation.
+// Labels defined inside a macro are local to each expansion. They are
+// renamed to unique names, along with all references to them.
+//
+// Macros are remembered by the AST. This allows code which is parsed
+// later, to use macros defined in earlier files.
+func (a *AST) ExpandMacros() (err error) {
+	if a.Root == nil {
+		return
+	}
+
+	if a.macros == nil {
+		a.macros = make(map[string]*Macro)
+	}
+
+	if err = a.collectMacros(a.Root, a.macros); err != nil || len(a.macros) == 0 {
+		return
+	}
+
+	var e expander
+	e.ast = a
+	e.macros = a.macros
+
+	if err = e.expandList(a.Root, 0); err != nil {
+		return
+	}
+
+	// Expanded code may contain function definitions.
+	a.Root.children, err = a.parseFunctions(a.Root.children)
+	return
+}
+
+// collectMacros removes macro definitions from the given node
+// and its functions, and adds them to the given map.
+func (a *AST) collectMacros(n NodeCollection, macros map[string]*Macro) error {
+	list := n.Children()
+	out := make([]Node, 0, len(list))
+
+	for _, v := range list {
+		switch tt := v.(type) {
+		case *Macro:
+			if _, ok := macros[tt.Name.Data]; ok {
+				return a.nodeErrorf(tt, "Duplicate macro definition %q.", tt.Name.Data)
+			}
+
+			macros[tt.Name.Data] = tt
+			continue
+
+		case *Function:
+			if err := a.collectMacros(tt, macros); err != nil {
+				return err
+			}
+		}
+
+		out = append(out, v)
+	}
+
+	n.SetChildren(out)
+	return nil
+}
+
+// expander holds macro expansion state.
+type expander struct {
+	ast    *AST
+	macros map[string]*Macro
+}
+
+// expandList expands all macro invocations in the given node.
+func (e *expander) expandList(n NodeCollection, depth int) (err error) {
+	list := n.Children()
+	out := make([]Node, 0, len(list))
+
+	for _, v := range list {
+		switch tt := v.(type) {
+		case *Function:
+			if err = e.expandList(tt, depth); err != nil {
+				return
+			}
+
+		case *Instruction:
+			name := tt.children[0].(*Name)
+
+			m, ok := e.macros[name.Data]
+			if !ok {
+				break
+			}
+
+			if depth >= MaxMacroDepth {
+				return e.ast.nodeErrorf(tt,
+					"Macro %q nests too deeply. Is it recursive?", name.Data)
+			}
+
+			var body *Block
+			if body, err = e.expand(m, tt); err != nil {
+				return
+			}
+
+			if err = e.expandList(body, depth+1); err != nil {
+				return
+			}
+
+			out = append(out, body.children...)
+			continue
+		}
+
+		out = append(out, v)
+	}
+
+	n.SetChildren(out)
+	return
+}
+
+// expand creates a copy of the given macro's body for a single invocation.
+func (e *expander) expand(m *Macro, instr *Instruction) (body *Block, err error) {
+	var args [][]Node
+
+	for _, v := range instr.children[1:] {
+		if list := StripComments(v.(*Expression).children); len(list) > 0 {
+			args = append(args, list)
+		}
+	}
+
+	if len(args) != len(m.Params) {
+		return nil, e.ast.nodeErrorf(instr,
+			"Invalid argument count for macro %q. Want %d", m.Name.Data, len(m.Params))
+	}
+
+	file, line, col := instr.File(), instr.Line(), instr.Col()
+	body = NewBlock(file, line, col)
+
+	for _, v := range m.children {
+		body.children = append(body.children, v.Copy(file, line, col))
+	}
+
+	// Rename local labels. The new names must be valid identifiers,
+	// so the expanded source can be written out and assembled again.
+	e.ast.expansions++
+	labels := make(map[string]string)
+	prefix := fmt.Sprintf("__%s_%d_", m.Name.Data, e.ast.expansions)
+
+	findMacroLabels(body.children, prefix, labels)
+	renameLabels(body.children, labels)
+
+	// Substitute parameters.
+	params := make(map[string][]Node)
+
+	for i, p := range m.Params {
+		params[p.Data] = args[i]
+	}
+
+	substitute(body, params)
+	return
+}
+
+// findMacroLabels adds a new name for each label defined in the given
+// nodes, including those nested in blocks and functions.
+func findMacroLabels(list []Node, prefix string, names map[string]string) {
+	for _, v := range list {
+		switch tt := v.(type) {
+		case *Label:
+			// Anonymous labels are relative to their position.
+			// They need no renaming.
+			if !isAnonName(tt.Data) {
+				names[tt.Data] = prefix + tt.Data
+			}
+
+		case *Expression:
+			// Expressions hold no label definitions.
+
+		case NodeCollection:
+			findMacroLabels(tt.Children(), prefix, names)
+		}
+	}
+}
+
+// renameLabels renames labels and references to them.
+func renameLabels(list []Node, names map[string]string) {
+	for _, v := range list {
+		switch tt := v.(type) {
+		case *Label:
+			if s, ok := names[tt.Data]; ok {
+				tt.Data = s
+			}
+
+		case *Name:
+			if s, ok := names[tt.Data]; ok {
+				tt.Data = s
+			}
+
+		case NodeCollection:
+			renameLabels(tt.Children(), names)
+		}
+	}
+}
+
+// substitute replaces parameter names with their arguments.
+//
+// An argument which consists of more than one node, is wrapped in
+// a nested expression, unless it makes up the entire expression or
+// block it is used in. This keeps `param * 2` correct for arguments
+// like `a + 1`, while `[param]` still accepts `a + 1`.
+func substitute(n NodeCollection, params map[string][]Node) {
+	list := n.Children()
+	out := make([]Node, 0, len(list))
+	whole := len(StripComments(list)) == 1
+
+	for _, v := range list {
+		switch tt := v.(type) {
+		case *Name:
+			arg, ok := params[tt.Data]
+			if !ok {
+				break
+			}
+
+			if len(arg) == 1 || whole {
+				for _, a := range arg {
+					out = append(out, a.Copy(tt.File(), tt.Line(), tt.Col()))
+				}
+				continue
+			}
+
+			expr := NewExpression(tt.File(), tt.Line(), tt.Col())
+
+			for _, a := range arg {
+				expr.children = append(expr.children, a.Copy(tt.File(), tt.Line(), tt.Col()))
+			}
+
+			out = append(out, expr)
+			continue
+
+		case *Instruction:
+			// The instruction name is not an operand.
+			for _, expr := range tt.children[1:] {
+				substitute(expr.(NodeCollection), params)
+			}
+
+		case NodeCollection:
+			substitute(tt, params)
+		}
+
+		out = append(out, v)
+	}
+
+	n.SetChildren(out)
+}
+
+// nodeErrorf creates a parse error for the given node.
+func (a *AST) nodeErrorf(n Node, f string, argv ...interface{}) error {
+	return NewParseError(a.Files[n.File()], n.Line(), n.Col(), f, argv...)
+}
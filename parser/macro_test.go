@@ -0,0 +1,205 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package parser
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func parseMacros(t *testing.T, src string) (*AST, error) {
+	var ast AST
+
+	if err := ast.Parse(bytes.NewBufferString(src), ""); err != nil {
+		t.Fatal(err)
+	}
+
+	return &ast, ast.ExpandMacros()
+}
+
+func TestMacro(t *testing.T) {
+	ast, err := parseMacros(t, `macro inc2 reg
+   add reg, 2
+endm
+macro count reg, n
+:loop
+   sub reg, 1
+   ifn reg, n
+      set pc, loop
+endm
+   inc2 a
+   count b, 1 + 2
+   count c, 0`)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	list := ast.Root.Children()
+	if len(list) != 9 {
+		t.Fatalf("Node count mismatch. Want 9, got %d", len(list))
+	}
+
+	instr := list[0].(*Instruction)
+	if name := instr.children[0].(*Name).Data; name != "add" {
+		t.Fatalf("Instruction mismatch. Want add, got %s", name)
+	}
+
+	if instr.Line() != 10 {
+		t.Fatalf("Line mismatch. Want 10, got %d", instr.Line())
+	}
+
+	arg := instr.children[1].(*Expression).children[0].(*Name)
+	if arg.Data != "a" {
+		t.Fatalf("Argument mismatch. Want a, got %s", arg.Data)
+	}
+
+	// Multi-node arguments make up the whole operand.
+	expr := list[3].(*Instruction).children[2].(*Expression)
+	if len(expr.children) != 3 {
+		t.Fatalf("Operand size mismatch. Want 3, got %d", len(expr.children))
+	}
+
+	// Each expansion gets its own label.
+	a := list[1].(*Label).Data
+	b := list[5].(*Label).Data
+
+	if a == b || a == "loop" || b == "loop" {
+		t.Fatalf("Labels are not unique: %q, %q", a, b)
+	}
+
+	ref := list[4].(*Instruction).children[2].(*Expression).children[0].(*Name)
+	if ref.Data != a {
+		t.Fatalf("Label reference mismatch. Want %s, got %s", a, ref.Data)
+	}
+}
+
+// Labels anywhere in the body are renamed, to names which can be
+// read back by the lexer.
+func TestMacroLabels(t *testing.T) {
+	var ast AST
+
+	src := `macro m
+def f
+:inner
+   set pc, inner
+end
+:top
+   set pc, top
+:nested
+   set pc, nested
+endm
+   m`
+
+	if err := ast.Parse(bytes.NewBufferString(src), ""); err != nil {
+		t.Fatal(err)
+	}
+
+	// Move the last label into a block of its own.
+	m := ast.Root.children[0].(*Macro)
+	n := len(m.children)
+	block := NewBlock(0, 0, 0)
+	block.children = append([]Node{}, m.children[n-2:]...)
+	m.children = append(m.children[:n-2], block)
+
+	if err := ast.ExpandMacros(); err != nil {
+		t.Fatal(err)
+	}
+
+	var labels []*Label
+	findLabels(ast.Root.children, &labels)
+
+	if len(labels) != 3 {
+		t.Fatalf("Label count mismatch. Want 3, got %d", len(labels))
+	}
+
+	for _, label := range labels {
+		if !strings.HasPrefix(label.Data, "__m_") {
+			t.Fatalf("Label %q was not renamed.", label.Data)
+		}
+
+		var tmp AST
+		if err := tmp.Parse(bytes.NewBufferString(":"+label.Data), ""); err != nil {
+			t.Fatal(err)
+		}
+
+		list := tmp.Root.children
+		if len(list) != 1 || list[0].(*Label).Data != label.Data {
+			t.Fatalf("Label %q can not be read back.", label.Data)
+		}
+	}
+}
+
+// findLabels finds all labels in the given nodes.
+func findLabels(list []Node, labels *[]*Label) {
+	for _, v := range list {
+		switch tt := v.(type) {
+		case *Label:
+			*labels = append(*labels, tt)
+
+		case NodeCollection:
+			findLabels(tt.Children(), labels)
+		}
+	}
+}
+
+func TestMacroErrors(t *testing.T) {
+	for _, src := range []string{
+		"macro m\n   m\nendm\n   m",
+		"macro m a1\nendm\n   m",
+		"macro m\nendm\nmacro m\nendm",
+	} {
+		if _, err := parseMacros(t, src); err == nil {
+			t.Fatalf("Want error for %q", src)
+		}
+	}
+
+	for _, src := range []string{
+		"macro m\n",
+		"endm",
+		"macro m\nmacro n\nendm\nendm",
+		"macro set\nendm",
+		"macro m a\nendm",
+	} {
+		var ast AST
+
+		if err := ast.Parse(bytes.NewBufferString(src), ""); err == nil {
+			t.Fatalf("Want error for %q", src)
+		}
+	}
+}
+
+// A bad macro definition does not keep the others from being expanded.
+func TestMacroErrorsRecover(t *testing.T) {
+	var ast AST
+
+	src := `macro bad a
+   set a, 1
+endm
+macro inc2 reg
+   add reg, 2
+endm
+   inc2 x`
+
+	if err := ast.Parse(bytes.NewBufferString(src), ""); err == nil {
+		t.Fatalf("Want error for bad macro")
+	} else if list, ok := err.(ErrorList); ok {
+		t.Fatalf("Want a single error, got %v", list)
+	}
+
+	if err := ast.ExpandMacros(); err != nil {
+		t.Fatal(err)
+	}
+
+	list := ast.Root.Children()
+	if len(list) != 1 {
+		t.Fatalf("Want 1 node, got %d", len(list))
+	}
+
+	instr, ok := list[0].(*Instruction)
+	if !ok || instr.children[0].(*Name).Data != "add" {
+		t.Fatalf("Want expanded macro, got %v", list[0])
+	}
+}
@@ -30,18 +30,26 @@ func (n *Number) Copy(file, line, col int) Node {
 }
 
 // Parse attempts to process the node's string data as a number.
+// Values which do not fit in a single word yield an error.
 func (n *Number) Parse() (cpu.Word, error) {
-	var v uint64
-	var err error
+	v, err := n.parse(16)
+	return cpu.Word(v), err
+}
 
+// ParseInt processes the node's string data as a number which
+// need not fit in a word, like a file offset.
+func (n *Number) ParseInt() (int, error) {
+	v, err := n.parse(31)
+	return int(v), err
+}
+
+func (n *Number) parse(bits int) (uint64, error) {
 	if len(n.Data) > 2 && n.Data[0] == '0' && n.Data[1] == 'b' {
 		// strconv.ParseUint can't deal with 0b01010101 formatted strings.
 		// So handle these manually.
-		v, err = strconv.ParseUint(n.Data[2:], 2, 64)
-	} else {
-		// Otherwise, just let it figure out if we have octal, decimal or hex values.
-		v, err = strconv.ParseUint(n.Data, 0, 64)
+		return strconv.ParseUint(n.Data[2:], 2, bits)
 	}
 
-	return cpu.Word(v), err
+	// Otherwise, just let it figure out if we have octal, decimal or hex values.
+	return strconv.ParseUint(n.Data, 0, bits)
 }
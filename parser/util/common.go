@@ -6,6 +6,9 @@ package util
 import "github.com/jteeuwen/dcpu/parser"
 
 // FindLabels finds Label all nodes.
+//
+// Local and anonymous labels only have unique names once the AST
+// has been expanded with AST.Expand. ReadSource takes care of this.
 func FindLabels(n []parser.Node, l *[]*parser.Label) {
 	for i := range n {
 		switch tt := n[i].(type) {
@@ -19,9 +22,17 @@ func FindLabels(n []parser.Node, l *[]*parser.Label) {
 }
 
 // FindReferences finds all Label references.
+// As with FindLabels, references to local labels are qualified
+// by AST.Expand.
+//
+// Instruction names are not references. An unknown instruction is
+// reported by the assembler.
 func FindReferences(n []parser.Node, l *[]*parser.Name) {
 	for i := range n {
 		switch tt := n[i].(type) {
+		case *parser.Instruction:
+			FindReferences(tt.Children()[1:], l)
+
 		case parser.NodeCollection:
 			FindReferences(tt.Children(), l)
 
@@ -0,0 +1,306 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package util
+
+import (
+	"errors"
+	"fmt"
+	"github.com/jteeuwen/dcpu/parser"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// includeFiles processes explicit `include "file"` directives.
+// The code from the included file replaces the directive.
+//
+// A file is only ever included once. Subsequent includes of the same
+// file are ignored. Conditional code is pruned before files are
+// included, so only selected directives are processed.
+//
+// A directive whose file can not be read is removed, so the rest of the
+// program can still be checked. Errors are collected.
+func includeFiles(ast *parser.AST, includes []string) error {
+	var errs parser.ErrorList
+
+	for {
+		if err := ast.Prune(); err != nil {
+			errs.Add(err)
+			return errs.Err(ast.MaxErrors)
+		}
+
+		list := ast.Root.Children()
+
+		index, instr, err := findInclude(ast, list)
+		if err != nil || instr == nil {
+			errs.Add(err)
+			return errs.Err(ast.MaxErrors)
+		}
+
+		name := instr.Children()[1].(*parser.Expression).Children()
+		file, err := findFile(ast, instr, firstString(name), includes)
+		if err != nil {
+			errs.Add(err)
+			ast.Root.SetChildren(append(list[:index], list[index+1:]...))
+			continue
+		}
+
+		fd, err := os.Open(file)
+		if err != nil {
+			errs.Add(err)
+			ast.Root.SetChildren(append(list[:index], list[index+1:]...))
+			continue
+		}
+
+		size := len(list)
+		errs.Add(ast.Parse(fd, file))
+		fd.Close()
+
+		// New code has been appended to the AST. Move it to
+		// where the include directive is.
+		list = ast.Root.Children()
+		out := make([]parser.Node, 0, len(list)-1)
+		out = append(out, list[:index]...)
+		out = append(out, list[size:]...)
+		out = append(out, list[index+1:size]...)
+		ast.Root.SetChildren(out)
+	}
+}
+
+// findInclude finds the first include directive.
+// Includes are only allowed at the top level.
+func findInclude(ast *parser.AST, list []parser.Node) (int, *parser.Instruction, error) {
+	for i, v := range list {
+		switch tt := v.(type) {
+		case *parser.Instruction:
+			if tt.Children()[0].(*parser.Name).Data == "include" {
+				return i, tt, nil
+			}
+
+		case *parser.Function:
+			if _, instr, _ := findInclude(ast, tt.Children()); instr != nil {
+				return -1, nil, parser.NewParseError(ast.Files[instr.File()], instr.Line(), instr.Col(),
+					"Include directives can not be used inside a function.")
+			}
+		}
+	}
+
+	return -1, nil, nil
+}
+
+// findFile finds the named file for the given directive. Relative paths
+// are searched for in the directory of the file holding the directive,
+// followed by the include paths.
+func findFile(ast *parser.AST, instr *parser.Instruction, name string, includes []string) (string, error) {
+	var dirs []string
+
+	if filepath.IsAbs(name) {
+		dirs = append(dirs, "")
+	} else {
+		dirs = append(dirs, filepath.Dir(ast.Files[instr.File()]))
+		dirs = append(dirs, includes...)
+	}
+
+	for _, dir := range dirs {
+		file := filepath.Join(dir, name)
+
+		if stat, err := os.Stat(file); err == nil && !stat.IsDir() {
+			return filepath.Abs(file)
+		}
+	}
+
+	return "", parser.NewParseError(ast.Files[instr.File()], instr.Line(), instr.Col(),
+		"File not found: %q", name)
+}
+
+// includeBinaries replaces `incbin "file" [, offset, length] [, mode]`
+// directives with `dat` instructions holding the file contents.
+//
+// Offset and length are in bytes. By default, the rest of the file is
+// included. The mode determines how bytes are packed into words:
+//
+//     "be":   Two bytes per word, Big Endian. This is the default.
+//     "le":   Two bytes per word, Little Endian.
+//     "byte": One byte per word.
+//
+// An odd number of bytes is padded with a zero byte.
+func includeBinaries(ast *parser.AST, n parser.NodeCollection, includes []string) error {
+	var errs parser.ErrorList
+
+	list := n.Children()
+	out := make([]parser.Node, 0, len(list))
+
+	for _, v := range list {
+		switch tt := v.(type) {
+		case *parser.Function:
+			errs.Add(includeBinaries(ast, tt, includes))
+
+		case *parser.Instruction:
+			if tt.Children()[0].(*parser.Name).Data != "incbin" {
+				break
+			}
+
+			// A directive which fails is left out.
+			dat, err := readBinary(ast, tt, includes)
+			errs.Add(err)
+
+			if dat != nil {
+				out = append(out, dat)
+			}
+
+			continue
+		}
+
+		out = append(out, v)
+	}
+
+	n.SetChildren(out)
+	return errs.Err(ast.MaxErrors)
+}
+
+// readBinary reads the file for the given incbin directive and
+// turns it into a dat instruction. Returns nil if there is no data.
+func readBinary(ast *parser.AST, instr *parser.Instruction, includes []string) (*parser.Instruction, error) {
+	var nums []int
+	var mode string
+
+	file, line, col := instr.File(), instr.Line(), instr.Col()
+	errorf := func(f string, argv ...interface{}) error {
+		return parser.NewParseError(ast.Files[file], line, col, f, argv...)
+	}
+
+	args := instr.Children()[1:]
+	name := firstString(args[0].(*parser.Expression).Children())
+
+	for i, v := range args[1:] {
+		list := parser.StripComments(v.(*parser.Expression).Children())
+		if len(list) != 1 {
+			return nil, errorf("Invalid incbin argument. Want a number or string.")
+		}
+
+		switch tt := list[0].(type) {
+		case *parser.String:
+			if i != len(args)-2 {
+				return nil, errorf("The incbin mode must be the last argument.")
+			}
+
+			mode = tt.Data
+
+		case *parser.Number:
+			// Offsets and lengths may exceed the size of a word.
+			n, err := tt.ParseInt()
+			if err != nil {
+				return nil, errorf("%v", err)
+			}
+
+			nums = append(nums, n)
+
+		case parser.NumericNode:
+			w, err := tt.Parse()
+			if err != nil {
+				return nil, errorf("%v", err)
+			}
+
+			nums = append(nums, int(w))
+
+		default:
+			return nil, errorf("Invalid incbin argument. Want a number or string.")
+		}
+	}
+
+	if len(nums) > 2 {
+		return nil, errorf("Too many incbin arguments.")
+	}
+
+	path, err := findFile(ast, instr, name, includes)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errorf("%v", err)
+	}
+
+	offset, length := 0, len(data)
+
+	if len(nums) > 0 {
+		offset = nums[0]
+		length -= offset
+	}
+
+	if len(nums) > 1 {
+		length = nums[1]
+	}
+
+	if offset > len(data) || length < 0 || offset+length > len(data) {
+		return nil, errorf("Range %d:%d is out of bounds for %q (%d bytes).",
+			offset, length, name, len(data))
+	}
+
+	data = data[offset : offset+length]
+
+	words, err := packBytes(data, mode)
+	if err != nil {
+		return nil, errorf("%v", err)
+	}
+
+	if len(words) == 0 {
+		return nil, nil
+	}
+
+	dat := parser.NewInstruction(file, line, col)
+	chld := []parser.Node{parser.NewName(file, line, col, "dat")}
+
+	for _, w := range words {
+		expr := parser.NewExpression(file, line, col)
+		expr.SetChildren([]parser.Node{
+			parser.NewNumber(file, line, col, fmt.Sprintf("0x%04x", w)),
+		})
+		chld = append(chld, expr)
+	}
+
+	dat.SetChildren(chld)
+	return dat, nil
+}
+
+// packBytes packs the given bytes into words.
+func packBytes(data []byte, mode string) (words []uint16, err error) {
+	switch mode {
+	case "byte":
+		for _, b := range data {
+			words = append(words, uint16(b))
+		}
+		return
+
+	case "", "be", "le":
+	default:
+		return nil, errors.New(fmt.Sprintf(
+			"Unknown incbin mode %q. Want \"be\", \"le\" or \"byte\".", mode))
+	}
+
+	if len(data)%2 != 0 {
+		data = append(data, 0)
+	}
+
+	for i := 0; i < len(data); i += 2 {
+		if mode == "le" {
+			words = append(words, uint16(data[i+1])<<8|uint16(data[i]))
+		} else {
+			words = append(words, uint16(data[i])<<8|uint16(data[i+1]))
+		}
+	}
+
+	return
+}
+
+// firstString returns the value of the first string node in the list.
+func firstString(list []parser.Node) string {
+	for _, v := range list {
+		if s, ok := v.(*parser.String); ok {
+			return s.Data
+		}
+	}
+	return ""
+}
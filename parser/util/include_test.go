@@ -0,0 +1,124 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package util
+
+import (
+	"bytes"
+	"github.com/jteeuwen/dcpu/parser"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestInclude(t *testing.T) {
+	var ast parser.AST
+	var buf bytes.Buffer
+
+	if err := ReadSource(&ast, "../../testdata/include/main.dasm", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	sw := NewSourceWriter(&buf, &ast)
+	sw.Comments = false
+	sw.Write()
+
+	want := `   set a, 1
+   set c, 3
+   set b, 2
+
+:data
+   dat 0x0102, 0x0300
+   dat 0x0302
+   dat 0x0001, 0x0002, 0x0003
+`
+
+	if strings.TrimSpace(buf.String()) != strings.TrimSpace(want) {
+		t.Fatalf("Output mismatch.\nWant:\n%s\nHave:\n%s", want, buf.String())
+	}
+}
+
+func TestPackBytes(t *testing.T) {
+	for _, v := range []struct {
+		mode string
+		want []uint16
+	}{
+		{"", []uint16{0x0102, 0x0300}},
+		{"be", []uint16{0x0102, 0x0300}},
+		{"le", []uint16{0x0201, 0x0003}},
+		{"byte", []uint16{1, 2, 3}},
+	} {
+		have, err := packBytes([]byte{1, 2, 3}, v.mode)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(have) != len(v.want) {
+			t.Fatalf("%q: want %04x, got %04x", v.mode, v.want, have)
+		}
+
+		for i := range have {
+			if have[i] != v.want[i] {
+				t.Fatalf("%q: want %04x, got %04x", v.mode, v.want, have)
+			}
+		}
+	}
+
+	if _, err := packBytes(nil, "foo"); err == nil {
+		t.Fatalf("Want error for unknown mode.")
+	}
+}
+
+// Offsets and lengths of incbin ranges are not limited to a word.
+func TestIncbinRange(t *testing.T) {
+	dir, err := ioutil.TempDir("", "incbin")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	data := make([]byte, 0x10004)
+	copy(data[0x10000:], []byte{1, 2, 3, 4})
+
+	if err = ioutil.WriteFile(filepath.Join(dir, "big.bin"), data, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, v := range []struct {
+		src, want string
+	}{
+		{`incbin "big.bin", 0x10000`, "dat 0x0102, 0x0304"},
+		{`incbin "big.bin", 65538, 2`, "dat 0x0304"},
+		{`incbin "big.bin", 0xffff, 3, "byte"`, "dat 0x0000, 0x0001, 0x0002"},
+		{`incbin "big.bin", 0x10005`, "error"},
+		{`incbin "big.bin", 0, 0x10005`, "error"},
+	} {
+		var ast parser.AST
+		var buf bytes.Buffer
+
+		err := ParseSource(&ast, strings.NewReader(v.src), filepath.Join(dir, "main.dasm"), nil)
+
+		if v.want == "error" {
+			if err == nil {
+				t.Errorf("%s: Want error.", v.src)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("%s: %v", v.src, err)
+			continue
+		}
+
+		sw := NewSourceWriter(&buf, &ast)
+		sw.Comments = false
+		sw.Write()
+
+		if have := strings.TrimSpace(buf.String()); have != v.want {
+			t.Errorf("%s: Want %q, have %q.", v.src, v.want, have)
+		}
+	}
+}
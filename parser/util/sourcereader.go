@@ -12,30 +12,116 @@ import (
 )
 
 // ReadSource takes the input files and parses their contents into the given AST.
-func ReadSource(ast *parser.AST, input string, includes []string) (err error) {
-	if err = readSource(ast, input); err != nil {
-		return
+//
+// Errors do not stop the reader. The AST holds as much of the program as
+// could be read, so it can still be passed to the assembler to find
+// errors of its own.
+func ReadSource(ast *parser.AST, input string, includes []string) error {
+	var errs parser.ErrorList
+	errs.Add(readSource(ast, input, includes))
+
+	if ast.Root != nil {
+		errs.Add(resolveIncludes(ast, includes))
+	}
+
+	return errs.Err(ast.MaxErrors)
+}
+
+// ParseSource parses the given source into the given AST, as if it were
+// the contents of the named file. This allows reading code which has not
+// been saved yet. Included files and references are resolved like
+// ReadSource does.
+func ParseSource(ast *parser.AST, r io.Reader, file string, includes []string) error {
+	var errs parser.ErrorList
+	errs.Add(parseSource(ast, r, file, includes))
+
+	if ast.Root != nil {
+		errs.Add(resolveIncludes(ast, includes))
 	}
 
-	return resolveIncludes(ast, includes)
+	return errs.Err(ast.MaxErrors)
+}
+
+// ReadSourceFile parses the contents of the given file into the given AST.
+// Unlike ReadSource, references to undefined labels are not resolved.
+// Files named in include directives are only searched for relative
+// to the file which includes them.
+func ReadSourceFile(ast *parser.AST, input string) error {
+	return readSource(ast, input, nil)
 }
 
 // readSource reads the given file and parses its contents
-// into the given AST.
-func readSource(ast *parser.AST, file string) error {
+// into the given AST. This includes files and binary data named
+// in include and incbin directives.
+func readSource(ast *parser.AST, file string, includes []string) error {
 	fd, err := os.Open(file)
 	if err != nil {
 		return err
 	}
 
 	defer fd.Close()
-	return ast.Parse(fd, file)
+	return parseSource(ast, fd, file, includes)
+}
+
+// parseSource parses the given source into the given AST. This includes
+// files and binary data named in include and incbin directives.
+//
+// Each step runs, even if the previous one failed. Errors are collected.
+func parseSource(ast *parser.AST, r io.Reader, file string, includes []string) error {
+	var errs parser.ErrorList
+	errs.Add(ast.Parse(r, file))
+
+	if ast.Root == nil {
+		return errs.Err(ast.MaxErrors)
+	}
+
+	errs.Add(includeFiles(ast, includes))
+	errs.Add(ast.Expand())
+	errs.Add(includeBinaries(ast, ast.Root, includes))
+	return errs.Err(ast.MaxErrors)
 }
 
 // resolveIncludes finds references to undefined labels.
 // It then tries to find the code for these labels in the supplied
 // include paths. Files should be defined as '<labelname>.dasm'.
-func resolveIncludes(ast *parser.AST, includes []string) (err error) {
+//
+// A reference which can not be resolved, does not stop the search for
+// the others. All of them are reported.
+func resolveIncludes(ast *parser.AST, includes []string) error {
+	var errs parser.ErrorList
+	failed := make(map[string]bool)
+
+	for {
+		ref := findUndefinedRef(ast, failed)
+
+		if ref == nil {
+			// No undefined references left. We're done here.
+			return errs.Err(ast.MaxErrors)
+		}
+
+		var err error
+
+		if len(includes) == 0 {
+			// We have unresolved references, but no places to look
+			// for their implementation. This constitutes a booboo.
+			err = parser.NewParseError(ast.Files[ref.File()], ref.Line(), ref.Col(),
+				"Undefined reference: %q", ref.Data)
+		} else {
+			// The new file may hold its own include requirements.
+			// These are found in the next iteration.
+			err = loadInclude(ast, includes, ref)
+		}
+
+		if err != nil {
+			errs.Add(err)
+			failed[ref.Data] = true
+		}
+	}
+}
+
+// findUndefinedRef returns the first reference to an undefined label,
+// which is not in the given set. Returns nil if there is none.
+func findUndefinedRef(ast *parser.AST, skip map[string]bool) *parser.Name {
 	var labels []*parser.Label
 	var refs []*parser.Name
 	var consts []*parser.Name
@@ -46,27 +132,13 @@ func resolveIncludes(ast *parser.AST, includes []string) (err error) {
 	FindConstants(ast.Root.Children(), &consts)
 	FindFunctions(ast.Root.Children(), &funcs)
 
-	refs = findUndefinedRefs(refs, consts, labels, funcs)
-
-	if len(refs) == 0 {
-		// No undefined references. We're done here.
-		return
-	}
-
-	if len(includes) == 0 {
-		// We have unresolved references, but no places to look
-		// for their implementation. This constitutes a booboo.
-		return parser.NewParseError(ast.Files[refs[0].File()], refs[0].Line(), refs[0].Col(),
-			"Undefined reference: %q", refs[0].Data)
-	}
-
-	for i := range refs {
-		if err = loadInclude(ast, includes, refs[i]); err != nil {
-			return
+	for _, r := range findUndefinedRefs(refs, consts, labels, funcs) {
+		if !skip[r.Data] {
+			return r
 		}
 	}
 
-	return
+	return nil
 }
 
 // loadInclude tries to load the given reference as an include file.
@@ -117,7 +189,7 @@ func loadInclude(ast *parser.AST, includes []string, r *parser.Name) (err error)
 			"Undefined reference: %q", r.Data)
 	}
 
-	if err = readSource(ast, file); err != nil {
+	if err = readSource(ast, file, includes); err != nil {
 		return
 	}
 
@@ -128,8 +200,7 @@ func loadInclude(ast *parser.AST, includes []string, r *parser.Name) (err error)
 				"it did not define the desired label.", r.Data)
 	}
 
-	// This new file may hold its own include requirements.
-	return resolveIncludes(ast, includes)
+	return
 }
 
 // findUndefinedRefs compares both given lists of labels and
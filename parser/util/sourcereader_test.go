@@ -0,0 +1,52 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package util
+
+import (
+	"github.com/jteeuwen/dcpu/parser"
+	"strings"
+	"testing"
+)
+
+func TestParseSourceErrors(t *testing.T) {
+	var ast parser.AST
+
+	src := `include "missing.dasm"
+set a, ,
+foo a
+jsr nothere
+set a, 1
+`
+
+	err := ParseSource(&ast, strings.NewReader(src), "test.dasm", nil)
+	if err == nil {
+		t.Fatalf("Expected errors.")
+	}
+
+	list, ok := err.(parser.ErrorList)
+	if !ok {
+		t.Fatalf("Expected an error list, have: %v", err)
+	}
+
+	want := []string{
+		`test.dasm:2:8 Expected expression`,
+		`test.dasm:1:1 File not found: "missing.dasm"`,
+		`test.dasm:4:5 Undefined reference: "nothere"`,
+	}
+
+	if len(list) != len(want) {
+		t.Fatalf("Error count mismatch: Want %d, have %d:\n%v", len(want), len(list), err)
+	}
+
+	for i := range want {
+		if !strings.HasSuffix(list[i].Error(), want[i]) {
+			t.Fatalf("Error %d mismatch:\nWant: %s\nHave: %v", i, want[i], list[i])
+		}
+	}
+
+	// The lines without syntax errors are still there for the assembler.
+	if n := len(ast.Root.Children()); n != 3 {
+		t.Fatalf("Node count mismatch: Want 3, have %d", n)
+	}
+}
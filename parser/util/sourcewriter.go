@@ -15,6 +15,8 @@ var (
 	newline = []byte{'\n'}
 	lbrack  = []byte{'['}
 	rbrack  = []byte{']'}
+	lparen  = []byte{'('}
+	rparen  = []byte{')'}
 )
 
 // SourceWriter allows us to write an AST out as source code
@@ -29,6 +31,7 @@ type SourceWriter struct {
 	Comments     bool
 	Indent       bool
 	inInstr      bool
+	exprLevel    int
 }
 
 // NewSourceWriter creates a new source writer for the given ast
@@ -81,6 +84,14 @@ func (sw *SourceWriter) writeList(list []parser.Node) {
 			sw.writeBlock(tt)
 
 		case *parser.Expression:
+			if sw.exprLevel > 0 {
+				// Nested expression. Preserve its grouping.
+				sw.w.Write(lparen)
+				sw.writeExpression(tt)
+				sw.w.Write(rparen)
+				break
+			}
+
 			if i > 0 && i < len(list) {
 				sw.w.Write(space)
 			}
@@ -102,6 +113,10 @@ func (sw *SourceWriter) writeList(list []parser.Node) {
 			sw.writeFunction(tt)
 			sw.w.Write(newline)
 
+		case *parser.Macro:
+			sw.writeMacro(tt)
+			sw.w.Write(newline)
+
 		case *parser.Comment:
 			if i > 0 && !sw.inInstr && !preceedingComment {
 				sw.w.Write(newline)
@@ -153,9 +168,11 @@ func (sw *SourceWriter) writeList(list []parser.Node) {
 }
 
 func (sw *SourceWriter) writeBlock(n *parser.Block) {
+	sw.exprLevel++
 	sw.w.Write(lbrack)
 	sw.writeList(n.Children())
 	sw.w.Write(rbrack)
+	sw.exprLevel--
 }
 
 func (sw *SourceWriter) writeInstruction(n *parser.Instruction) {
@@ -187,8 +204,26 @@ func (sw *SourceWriter) writeFunction(n *parser.Function) {
 	sw.w.Write([]byte("end"))
 }
 
+func (sw *SourceWriter) writeMacro(n *parser.Macro) {
+	fmt.Fprintf(sw.w, "macro %s", n.Name.Data)
+
+	for i, p := range n.Params {
+		if i > 0 {
+			sw.w.Write(comma)
+		}
+
+		fmt.Fprintf(sw.w, " %s", p.Data)
+	}
+
+	sw.w.Write(newline)
+	sw.writeList(n.Children())
+	sw.w.Write([]byte("endm"))
+}
+
 func (sw *SourceWriter) writeExpression(n *parser.Expression) {
+	sw.exprLevel++
 	sw.writeList(n.Children())
+	sw.exprLevel--
 }
 
 func (sw *SourceWriter) writeLabel(s string) {
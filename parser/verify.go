@@ -4,19 +4,18 @@
 package parser
 
 // verify performs some sanity checks on the ast nodes.
-func verify(a *AST, list []Node) (err error) {
+// All errors are returned.
+func verify(a *AST, list []Node) error {
+	var errs ErrorList
+
 	for i := range list {
 		switch tt := list[i].(type) {
 		case *Instruction:
-			err = verifyInstruction(a, tt)
-		}
-
-		if err != nil {
-			return
+			errs.Add(verifyInstruction(a, tt))
 		}
 	}
 
-	return
+	return errs.err()
 }
 
 func verifyInstruction(a *AST, n *Instruction) (err error) {
@@ -28,6 +27,10 @@ func verifyInstruction(a *AST, n *Instruction) (err error) {
 		return verifyConstant(a, n)
 	case "def":
 		return verifyDef(a, n)
+	case "if", "ifdef", "ifndef", "else", "endif":
+		return verifyCondition(a, n)
+	case "include", "incbin":
+		return verifyInclude(a, n)
 	}
 
 	var expr *Expression
@@ -106,3 +109,97 @@ fail:
 	return NewParseError(a.Files[n.File()], n.Line(), n.Col(),
 		"Invalid function definition. Expected: def <name>")
 }
+
+func verifyCondition(a *AST, n *Instruction) (err error) {
+	var expr *Expression
+	var list []Node
+	var ok bool
+
+	name := n.children[0].(*Name)
+
+	switch name.Data {
+	case "else", "endif":
+		if len(n.children) > 2 {
+			goto fail
+		}
+
+		// Allow trailing comments.
+		if len(n.children) == 2 && len(StripComments(n.children[1].(*Expression).children)) > 0 {
+			goto fail
+		}
+
+		return
+
+	case "if":
+		if len(n.children) != 2 {
+			goto fail
+		}
+
+		expr, ok = n.children[1].(*Expression)
+		if !ok || len(StripComments(expr.children)) == 0 {
+			goto fail
+		}
+
+		return
+	}
+
+	if len(n.children) != 2 {
+		goto fail
+	}
+
+	list = StripComments(n.children[1].(*Expression).children)
+	if len(list) != 1 {
+		goto fail
+	}
+
+	if _, ok = list[0].(*Name); !ok {
+		goto fail
+	}
+
+	return
+
+fail:
+	switch name.Data {
+	case "if":
+		return NewParseError(a.Files[n.File()], n.Line(), n.Col(),
+			"Invalid condition. Expected: if <expression>")
+	case "else", "endif":
+		return NewParseError(a.Files[n.File()], n.Line(), n.Col(),
+			"Invalid %q. It takes no arguments.", name.Data)
+	}
+
+	return NewParseError(a.Files[n.File()], n.Line(), n.Col(),
+		"Invalid condition. Expected: %s <name>", name.Data)
+}
+
+func verifyInclude(a *AST, n *Instruction) (err error) {
+	var list []Node
+	var ok bool
+
+	name := n.children[0].(*Name)
+
+	if len(n.children) < 2 || (name.Data == "include" && len(n.children) > 2) ||
+		len(n.children) > 5 {
+		goto fail
+	}
+
+	list = StripComments(n.children[1].(*Expression).children)
+	if len(list) != 1 {
+		goto fail
+	}
+
+	if _, ok = list[0].(*String); !ok {
+		goto fail
+	}
+
+	return
+
+fail:
+	if name.Data == "include" {
+		return NewParseError(a.Files[n.File()], n.Line(), n.Col(),
+			"Invalid include. Expected: include \"<file>\"")
+	}
+
+	return NewParseError(a.Files[n.File()], n.Line(), n.Col(),
+		"Invalid incbin. Expected: incbin \"<file>\" [, <offset>, <length>] [, <mode>]")
+}
@@ -29,6 +29,8 @@ type Profile struct {
 }
 
 // New creates a new profile for the given code and debug data.
+//
+// Words without a source mapping, are attributed to the first file.
 func New(code []cpu.Word, dbg *asm.DebugInfo) *Profile {
 	var sym asm.SourceInfo
 
@@ -38,7 +40,11 @@ func New(code []cpu.Word, dbg *asm.DebugInfo) *Profile {
 	p.Data = make([]ProfileData, len(code))
 
 	for pc := range code {
-		sym = dbg.SourceMapping[pc]
+		sym = asm.SourceInfo{}
+
+		if pc < len(dbg.SourceMapping) {
+			sym = dbg.SourceMapping[pc]
+		}
 
 		pd := p.Data[pc]
 		pd.Data = code[pc]
@@ -61,19 +67,19 @@ func (p *Profile) Update(pc cpu.Word, s *cpu.Storage) {
 // This can happen when a branching instruction failed its check and had to
 // be skipped. This increases its cost by an amount dependant on how many
 // instructions where skipped. Nested branches can make this amount increase
-// considerably.
+// considerably. Hardware interrupts can take additional cycles as well.
 func (p *Profile) UpdateCost(pc, cost cpu.Word) {
 	p.Data[pc].Penalty += uint64(cost)
 }
 
 // setInstructionSizes computes and stores the size of each instruction.
 // The size is the number of words the instruction occupies.
+//
+// Programs may fill all of memory when they use `org` or `reserve`.
+// The address is kept in an int, so it can not wrap around.
 func (p *Profile) setInstructionSizes() {
-	var pc, size cpu.Word
-
-	for pc = 0; pc < cpu.Word(len(p.Data)); pc += size {
-		size = cpu.Sizeof(cpu.Decode(p.Data[pc].Data))
-		p.Data[pc].Size = size
+	for pc := 0; pc < len(p.Data); pc += int(p.Data[pc].Size) {
+		p.Data[pc].Size = cpu.Sizeof(cpu.Decode(p.Data[pc].Data))
 	}
 }
 
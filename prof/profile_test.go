@@ -121,3 +121,18 @@ func TestIdentity(t *testing.T) {
 		}
 	}
 }
+
+// Ensure programs which fill all of memory can be profiled.
+func TestFullMemory(t *testing.T) {
+	var dbg asm.DebugInfo
+
+	code := make([]cpu.Word, cpu.MemSize)
+	code[cpu.MemSize-1] = cpu.Encode(cpu.SET, 0, 0x1f)
+
+	p := New(code, &dbg)
+
+	if p.Data[0].Size != 1 || p.Data[cpu.MemSize-1].Size != 2 {
+		t.Fatalf("Want sizes 1 and 2, got %d and %d",
+			p.Data[0].Size, p.Data[cpu.MemSize-1].Size)
+	}
+}
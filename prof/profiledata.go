@@ -5,23 +5,6 @@ package prof
 
 import "github.com/jteeuwen/dcpu/cpu"
 
-// Cycle counts per opcode.
-var opcodes = [...]uint8{
-	// Basic opcodes
-	0, 1, 2, 2, 2, 2, 3, 3, 3, 3, 1, 1, 1, 1, 1, 1,
-	2, 2, 2, 2, 2, 2, 2, 2, 0, 0, 3, 3, 0, 0, 2, 2,
-
-	// Extended opcodes.
-	0, 2, 0, 0, 0, 0, 0, 0, 4, 1, 1, 3, 2, 0, 0, 0,
-	2, 4, 4, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
-}
-
-// Cycle counts per operand.
-var operands = [...]uint8{
-	0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
-	1, 1, 1, 1, 1, 1, 1, 1, 0, 0, 1, 0, 0, 0, 1, 1,
-}
-
 // Profile data for a specific opcode.
 type ProfileData struct {
 	Count uint64 // Number of times this opcode was called.
@@ -53,30 +36,9 @@ func (p *ProfileData) Copy() *ProfileData {
 }
 
 // Cost returns the cycle cost for this entry.
+// This is the base cost as defined by the CPU.
 func (p *ProfileData) Cost() uint8 {
-	var c uint8
-
-	op, a, b := cpu.Decode(p.Data)
-
-	if op == cpu.EXT {
-		c = opcodes[a]
-
-		if b <= 0x1f {
-			c += operands[b]
-		}
-	} else {
-		c = opcodes[op]
-
-		if a <= 0x1f {
-			c += operands[a]
-		}
-
-		if b <= 0x1f {
-			c += operands[b]
-		}
-	}
-
-	return c
+	return uint8(cpu.Cost(cpu.Decode(p.Data)))
 }
 
 // CumulativeCost returns the cumulative cycle cost for this entry.